@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/sse"
+)
+
+// PublishTime publishes the current time to hub every interval, forever. Watch
+// it with 'curl -N http://127.0.0.1:42069/clock'.
+func PublishTime(hub *sse.Hub, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		hub.Publish(sse.Event{
+			Event: "tick",
+			Data:  now.UTC().Format(time.RFC3339),
+		})
+	}
+}
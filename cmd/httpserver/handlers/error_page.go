@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/itsjoeoui/httpfromtcp/cmd/httpserver/templates"
+	"github.com/itsjoeoui/httpfromtcp/internal/errorpage"
+)
+
+// ErrorPage renders the HTML for errors the server runs into itself with the
+// same templates as Handler400 and Handler500, falling back to error.html for
+// status codes without a page of their own.
+func ErrorPage(pages *templates.Set) errorpage.HTMLFunc {
+	return func(p errorpage.Page) ([]byte, error) {
+		name := strconv.Itoa(int(p.StatusCode)) + ".html"
+		if !pages.Has(name) {
+			name = "error.html"
+		}
+
+		page, err := pages.Render(name, templates.Data{
+			StatusCode: int(p.StatusCode),
+			Status:     p.Title,
+			Detail:     p.Detail,
+			Method:     p.Method,
+			Path:       p.Path,
+			RequestID:  p.RequestID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return page.Body, nil
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"compress/flate"
+	"errors"
+	"log"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/itsjoeoui/httpfromtcp/internal/websocket"
+)
+
+// HandlerEcho upgrades to a WebSocket and sends every message back, try it
+// with 'websocat ws://127.0.0.1:42069/echo'.
+func HandlerEcho(w *response.Writer, r *request.Request) {
+	conn, err := websocket.Upgrade(w, r, websocket.WithCompression(flate.BestSpeed))
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		return
+	}
+	defer func() {
+		err := conn.Close()
+		if err != nil {
+			log.Printf("Failed to close WebSocket: %v", err)
+		}
+	}()
+
+	for {
+		typ, message, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				log.Printf("Failed to read WebSocket message: %v", err)
+			}
+			return
+		}
+
+		err = conn.WriteMessage(typ, message)
+		if err != nil {
+			log.Printf("Failed to write WebSocket message: %v", err)
+			return
+		}
+	}
+}
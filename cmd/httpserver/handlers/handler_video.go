@@ -1,34 +1,18 @@
 package handlers
 
 import (
-	"log"
-	"os"
+	"io/fs"
 
-	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/fileserver"
 	"github.com/itsjoeoui/httpfromtcp/internal/request"
 	"github.com/itsjoeoui/httpfromtcp/internal/response"
 )
 
-func HandlerVideo(w *response.Writer, _ *request.Request) {
-	err := w.WriteStatusLine(response.StatusCodeOK)
-	if err != nil {
-		log.Printf("Failed to write status line: %v", err)
-	}
-
-	f, err := os.ReadFile("./assets/vim.mp4")
-	if err != nil {
-		log.Printf("Failed to read file: %v, you can download it with 'just setup'", err)
-	}
-
-	h := response.GetDefaultHeaders(len(f))
-	h.Override(headers.ContentTypeHeader, "video/mp4")
-	err = w.WriteHeaders(h)
-	if err != nil {
-		log.Printf("Failed to write headers: %v", err)
-	}
-
-	_, err = w.WriteBody(f)
-	if err != nil {
-		log.Printf("Failed to write body: %v", err)
+// HandlerVideo serves vim.mp4 from assets, you can download it with
+// 'just setup'. Range requests let browsers seek without restarting the
+// download.
+func HandlerVideo(assets fs.FS) func(w *response.Writer, r *request.Request) {
+	return func(w *response.Writer, r *request.Request) {
+		fileserver.ServeFileFS(w, r, assets, "vim.mp4")
 	}
 }
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/itsjoeoui/httpfromtcp/cmd/httpserver/templates"
+	"github.com/itsjoeoui/httpfromtcp/internal/conditional"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// serveTemplate renders the page for statusCode, e.g. 200.html for 200.
+// Successful responses carry a weak ETag, and Last-Modified when the pages
+// are read from disk, so browsers can revalidate their copy instead of
+// downloading it again.
+func serveTemplate(w *response.Writer, r *request.Request, pages *templates.Set, statusCode response.StatusCode) {
+	path := r.Path()
+
+	page, err := pages.Render(strconv.Itoa(int(statusCode))+".html", templates.Data{
+		StatusCode: int(statusCode),
+		Status:     statusCode.ReasonPhrase(),
+		Method:     r.RequestLine.Method,
+		Path:       path,
+		RequestID:  r.ID,
+	})
+	if err != nil {
+		log.Printf("Failed to render template: %v", err)
+
+		err = w.WriteError(response.StatusCodeInternalServerError, nil)
+		if err != nil {
+			log.Printf("Failed to write error: %v", err)
+		}
+		return
+	}
+
+	if statusCode.IsSuccess() && conditional.Respond(w, r, page.ETag, page.ModTime, nil) {
+		return
+	}
+
+	err = w.WriteStatusLine(statusCode)
+	if err != nil {
+		log.Printf("Failed to write status line: %v", err)
+	}
+
+	h := response.GetDefaultHeaders(len(page.Body))
+	h.Override(headers.ContentTypeHeader, "text/html; charset=utf-8")
+	conditional.SetValidators(h, page.ETag, page.ModTime)
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Failed to write headers: %v", err)
+	}
+
+	_, err = w.WriteBody(page.Body)
+	if err != nil {
+		log.Printf("Failed to write body: %v", err)
+	}
+}
@@ -1,37 +1,131 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/itsjoeoui/httpfromtcp/cmd/httpserver/handlers"
+	"github.com/itsjoeoui/httpfromtcp/cmd/httpserver/templates"
+	"github.com/itsjoeoui/httpfromtcp/internal/compress"
+	"github.com/itsjoeoui/httpfromtcp/internal/errorpage"
+	"github.com/itsjoeoui/httpfromtcp/internal/fileserver"
+	"github.com/itsjoeoui/httpfromtcp/internal/proxy"
 	"github.com/itsjoeoui/httpfromtcp/internal/request"
-	"github.com/itsjoeoui/httpfromtcp/internal/response"
 	"github.com/itsjoeoui/httpfromtcp/internal/server"
+	"github.com/itsjoeoui/httpfromtcp/internal/sse"
 )
 
-const port = 42069
-
-func handler(w *response.Writer, r *request.Request) {
-	switch {
-	case strings.HasPrefix(r.RequestLine.RequestTarget, "/yourproblem"):
-		handlers.Handler400(w, r)
-	case strings.HasPrefix(r.RequestLine.RequestTarget, "/myproblem"):
-		handlers.Handler500(w, r)
-	case strings.HasPrefix(r.RequestLine.RequestTarget, "/video"):
-		handlers.HandlerVideo(w, r)
-	case strings.HasPrefix(r.RequestLine.RequestTarget, "/httpbin"):
-		handlers.HandlerHTTPBin(w, r)
-	default:
-		handlers.Handler200(w, r)
+const (
+	port       = 42069
+	serverName = "httpfromtcp"
+
+	templatesDir = "./cmd/httpserver/templates"
+
+	readTimeout = 10 * time.Second
+	maxBodySize = 10 << 20
+)
+
+var (
+	assetsDir = flag.String("assets", "./assets", "directory served under /assets/ that holds vim.mp4")
+	dev       = flag.Bool("dev", false, "reload the HTML templates from "+templatesDir+" when they change")
+
+	forwardPort = flag.Int("forward-proxy", 0, "also run a forward proxy, CONNECT included, on this port")
+	forwardAuth = flag.String("forward-proxy-auth", "", "user:password the forward proxy requires")
+	forwardHost = flag.String("forward-proxy-allow", "", "comma-separated hosts the forward proxy may reach, \".example.com\" for a domain and its subdomains; any public host if empty")
+)
+
+func newRouter(pages *templates.Set) (*server.Router, error) {
+	router := server.NewRouter()
+
+	httpbin, err := proxy.New("https://httpbin.org",
+		proxy.WithStripPrefix("/httpbin"),
+		proxy.WithContentDigest(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.OpenRoot(*assetsDir)
+	if err != nil {
+		return nil, err
 	}
+	// unlike os.DirFS, doesn't follow symlinks out of the directory
+	assets := root.FS()
+
+	clock := sse.NewHub()
+	go handlers.PublishTime(clock, time.Second)
+
+	router.Handle("/yourproblem", compress.Responses(handlers.Handler400(pages)))
+	router.Handle("/myproblem", compress.Responses(handlers.Handler500(pages)))
+	router.Handle("/video", handlers.HandlerVideo(assets))
+	router.HandleMethod(request.MethodGet, "/echo", handlers.HandlerEcho)
+	router.HandleMethod(request.MethodGet, "/clock", compress.Responses(clock.Serve))
+	router.Handle("/assets/", compress.Responses(fileserver.New(assets,
+		fileserver.WithStripPrefix("/assets"),
+		fileserver.WithDirectoryListing(),
+	).Serve))
+	// not compressed: the Content-Digest trailer covers the bytes as proxied
+	router.Handle("/httpbin", httpbin.Serve)
+	router.Handle("/", compress.Responses(handlers.Handler200(pages)))
+
+	return router, nil
 }
 
 func main() {
-	server, err := server.Serve(handler, port)
+	flag.Parse()
+
+	pages := templates.Embedded()
+	if *dev {
+		var err error
+		pages, err = templates.Dev(templatesDir)
+		if err != nil {
+			log.Fatalf("Failed to load templates: %v", err)
+		}
+	}
+
+	router, err := newRouter(pages)
+	if err != nil {
+		log.Fatalf("Failed to set up routes: %v", err)
+	}
+
+	if *forwardPort != 0 {
+		var opts []proxy.ForwardOption
+		if user, password, ok := strings.Cut(*forwardAuth, ":"); ok {
+			opts = append(opts, proxy.WithBasicAuth(serverName, user, password))
+		}
+		if *forwardHost != "" {
+			opts = append(opts, proxy.WithAllowedHosts(strings.Split(*forwardHost, ",")...))
+		}
+
+		forward, err := server.Serve(proxy.NewForward(opts...).Serve, *forwardPort,
+			server.WithServerName(serverName),
+			server.WithReadTimeout(readTimeout),
+			server.WithMaxBodySize(maxBodySize),
+		)
+		if err != nil {
+			log.Fatalf("Error starting forward proxy: %v", err)
+		}
+		defer func() {
+			err := forward.Close()
+			if err != nil {
+				log.Fatalf("Failed to close forward proxy: %v", err)
+			}
+		}()
+		log.Println("Forward proxy started on port", *forwardPort)
+	}
+
+	server, err := server.Serve(router.Route, port,
+		server.WithServerName(serverName),
+		server.WithErrorRenderer(errorpage.Renderer(handlers.ErrorPage(pages))),
+		server.WithReadTimeout(readTimeout),
+		server.WithMaxBodySize(maxBodySize),
+		server.WithHTTP2(),
+	)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -0,0 +1,193 @@
+// Package templates holds the HTML pages of the example server. They are
+// embedded in the binary and parsed once, or reloaded from disk while
+// developing.
+package templates
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/conditional"
+)
+
+//go:embed *.html
+var embedded embed.FS
+
+const pattern = "*.html"
+
+// Data is what the pages are rendered with.
+type Data struct {
+	StatusCode int
+	Status     string
+	// Detail explains an error, or is empty.
+	Detail    string
+	Method    string
+	Path      string
+	RequestID string
+}
+
+// Page is a rendered template.
+type Page struct {
+	Body []byte
+	// ETag is weak: pages rendered from the same templates and data differ
+	// only in their request ID.
+	ETag string
+	// ModTime is when the template file last changed, zero when embedded.
+	ModTime time.Time
+}
+
+// Set renders the pages by file name, e.g. "200.html".
+type Set struct {
+	fsys fs.FS
+	// dev makes Render reparse the templates when a file changed.
+	dev bool
+
+	mu       sync.Mutex
+	tmpl     *template.Template
+	modTimes map[string]time.Time
+	// version is a hash of the template sources, which changes the ETags of
+	// the pages whenever the templates change, embedded ones included.
+	version string
+}
+
+// Embedded returns the set compiled into the binary.
+func Embedded() *Set {
+	s := &Set{fsys: embedded}
+
+	err := s.parse()
+	if err != nil {
+		// they were parsed at least once before being committed
+		panic(err)
+	}
+	return s
+}
+
+// Dev returns a set read from dir that is reloaded whenever a template in it
+// changes, so pages can be edited without restarting the server.
+func Dev(dir string) (*Set, error) {
+	s := &Set{
+		fsys: os.DirFS(dir),
+		dev:  true,
+	}
+
+	err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Has reports whether there is a template called name.
+func (s *Set) Has(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tmpl.Lookup(name) != nil
+}
+
+// Render executes the template called name with data.
+func (s *Set) Render(name string, data Data) (Page, error) {
+	s.mu.Lock()
+	if s.dev && s.changed() {
+		err := s.parse()
+		if err != nil {
+			// the set stays as it was, so the next request tries again
+			s.mu.Unlock()
+			return Page{}, err
+		}
+	}
+	tmpl, modTime, version := s.tmpl, s.modTimes[name], s.version
+	s.mu.Unlock()
+
+	var body bytes.Buffer
+	err := tmpl.ExecuteTemplate(&body, name, data)
+	if err != nil {
+		return Page{}, err
+	}
+
+	// everything but the request ID decides what the page says
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%s\x00%s\x00%s", version, name, data.StatusCode, data.Status, data.Detail, data.Method, data.Path)
+
+	return Page{
+		Body:    body.Bytes(),
+		ETag:    conditional.Weak(conditional.ETagFromBytes([]byte(key))),
+		ModTime: modTime,
+	}, nil
+}
+
+// parse must be called with mu held, or before the set is shared.
+func (s *Set) parse() error {
+	modTimes, err := s.stat()
+	if err != nil {
+		return err
+	}
+
+	// the templates are parsed from the same bytes that are hashed, so the
+	// version can't describe a file that changed in between
+	tmpl := template.New("")
+	hash := sha256.New()
+	for _, name := range slices.Sorted(maps.Keys(modTimes)) {
+		src, err := fs.ReadFile(s.fsys, name)
+		if err != nil {
+			return err
+		}
+		_, err = tmpl.New(name).Parse(string(src))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "%s\x00%d\x00", name, len(src))
+		hash.Write(src)
+	}
+
+	s.tmpl = tmpl
+	s.modTimes = modTimes
+	s.version = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// changed reports whether a template was added, removed or modified since it
+// was parsed.
+func (s *Set) changed() bool {
+	modTimes, err := s.stat()
+	if err != nil {
+		return true
+	}
+	if len(modTimes) != len(s.modTimes) {
+		return true
+	}
+
+	for name, modTime := range modTimes {
+		if !modTime.Equal(s.modTimes[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Set) stat() (map[string]time.Time, error) {
+	names, err := fs.Glob(s.fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	modTimes := make(map[string]time.Time, len(names))
+	for _, name := range names {
+		info, err := fs.Stat(s.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[name] = info.ModTime()
+	}
+	return modTimes, nil
+}
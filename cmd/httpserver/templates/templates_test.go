@@ -0,0 +1,132 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTemplate writes src to dir/name and sets its modification time, so
+// tests don't depend on the resolution of the file system's clock.
+func writeTemplate(t *testing.T, dir, name, src string, modTime time.Time) {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestEmbedded(t *testing.T) {
+	s := Embedded()
+
+	// Test: Pages are rendered with Data
+	page, err := s.Render("200.html", Data{
+		StatusCode: 200,
+		Status:     "OK",
+		Method:     "GET",
+		Path:       "/coffee",
+		RequestID:  "abc123",
+	})
+	require.NoError(t, err)
+	assert.Contains(t, string(page.Body), "<title>200 OK</title>")
+	assert.Contains(t, string(page.Body), "GET /coffee &middot; request abc123")
+	assert.True(t, page.ModTime.IsZero())
+
+	// Test: The request ID doesn't change the ETag
+	again, err := s.Render("200.html", Data{StatusCode: 200, Status: "OK", Method: "GET", Path: "/coffee", RequestID: "def456"})
+	require.NoError(t, err)
+	assert.Equal(t, page.ETag, again.ETag)
+	assert.Regexp(t, `^W/"`, page.ETag)
+
+	// Test: What the page says does
+	other, err := s.Render("200.html", Data{StatusCode: 200, Status: "OK", Method: "GET", Path: "/tea", RequestID: "abc123"})
+	require.NoError(t, err)
+	assert.NotEqual(t, page.ETag, other.ETag)
+
+	// Test: Templates are looked up by file name
+	assert.True(t, s.Has("error.html"))
+	assert.False(t, s.Has("404.html"))
+	_, err = s.Render("404.html", Data{})
+	require.Error(t, err)
+}
+
+func TestDev(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	writeTemplate(t, dir, "200.html", "<p>{{.Path}}</p>", start)
+
+	s, err := Dev(dir)
+	require.NoError(t, err)
+
+	page, err := s.Render("200.html", Data{Path: "/"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>/</p>", string(page.Body))
+	assert.True(t, page.ModTime.Equal(start))
+
+	// Test: A template is reloaded once its file changes
+	writeTemplate(t, dir, "200.html", "<h1>{{.Path}}</h1>", start.Add(time.Minute))
+	reloaded, err := s.Render("200.html", Data{Path: "/"})
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>/</h1>", string(reloaded.Body))
+	assert.True(t, reloaded.ModTime.Equal(start.Add(time.Minute)))
+
+	// Test: And a changed template changes the ETag
+	assert.NotEqual(t, page.ETag, reloaded.ETag)
+
+	// Test: Added templates are picked up too
+	writeTemplate(t, dir, "400.html", "<p>{{.Detail}}</p>", start.Add(time.Minute))
+	added, err := s.Render("400.html", Data{Detail: "bad"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>bad</p>", string(added.Body))
+
+	// Test: A template that doesn't parse fails the render and leaves the
+	// previous set in place
+	writeTemplate(t, dir, "200.html", "<h1>{{.Path</h1>", start.Add(2*time.Minute))
+	_, err = s.Render("200.html", Data{Path: "/"})
+	require.Error(t, err)
+	assert.True(t, s.Has("400.html"))
+
+	var previous strings.Builder
+	require.NoError(t, s.tmpl.ExecuteTemplate(&previous, "200.html", Data{Path: "/"}))
+	assert.Equal(t, "<h1>/</h1>", previous.String())
+
+	// Test: Once fixed, the template is picked up again
+	writeTemplate(t, dir, "200.html", "<h2>{{.Path}}</h2>", start.Add(3*time.Minute))
+	fixed, err := s.Render("200.html", Data{Path: "/"})
+	require.NoError(t, err)
+	assert.Equal(t, "<h2>/</h2>", string(fixed.Body))
+}
+
+func TestDevSameModTime(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	writeTemplate(t, dir, "200.html", "<p>old</p>", modTime)
+
+	before, err := Dev(dir)
+	require.NoError(t, err)
+	old, err := before.Render("200.html", Data{})
+	require.NoError(t, err)
+
+	// Test: A release that edits a template changes its ETag even when the
+	// modification time is the same, as it is for embedded templates
+	writeTemplate(t, dir, "200.html", "<p>new</p>", modTime)
+	after, err := Dev(dir)
+	require.NoError(t, err)
+	updated, err := after.Render("200.html", Data{})
+	require.NoError(t, err)
+	assert.NotEqual(t, old.ETag, updated.ETag)
+}
+
+func TestDevParseError(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "200.html", "{{.Path", time.Now())
+
+	// Test: A set that doesn't parse to begin with is an error
+	_, err := Dev(dir)
+	require.Error(t, err)
+}
@@ -0,0 +1,341 @@
+// Package client sends HTTP/1.1 requests and reads their responses, reusing
+// connections between requests to the same host.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+const (
+	// DefaultTimeout bounds a single exchange, from connecting to reading the
+	// last byte of the response.
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxRedirects is how many redirects Do follows.
+	DefaultMaxRedirects = 10
+	// DefaultMaxIdleConns is how many idle connections are kept per host.
+	DefaultMaxIdleConns = 2
+	// DefaultIdleTimeout is how long an idle connection is kept.
+	DefaultIdleTimeout = 90 * time.Second
+	// DefaultMaxBodySize is the largest response body read, in bytes.
+	DefaultMaxBodySize = 32 << 20
+)
+
+// Client sends requests. It is safe for concurrent use.
+type Client struct {
+	pool         *connPool
+	timeout      time.Duration
+	maxRedirects int
+	maxBodySize  int
+	dialer       *net.Dialer
+	tlsConfig    *tls.Config
+}
+
+// Option configures a Client in New.
+type Option func(*Client)
+
+// WithTimeout replaces DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithMaxRedirects replaces DefaultMaxRedirects. With n = 0 redirects are
+// returned as is.
+func WithMaxRedirects(n int) Option {
+	return func(c *Client) {
+		c.maxRedirects = n
+	}
+}
+
+// WithMaxBodySize replaces DefaultMaxBodySize. Larger response bodies fail
+// the request with response.ErrorBodyTooLarge; with n = 0 there is no limit.
+func WithMaxBodySize(n int) Option {
+	return func(c *Client) {
+		c.maxBodySize = n
+	}
+}
+
+// WithMaxIdleConns replaces DefaultMaxIdleConns and DefaultIdleTimeout.
+func WithMaxIdleConns(n int, idleTimeout time.Duration) Option {
+	return func(c *Client) {
+		c.pool.maxIdle = n
+		c.pool.idleTimeout = idleTimeout
+	}
+}
+
+// WithDialer replaces the dialer connections are opened with, e.g. to vet
+// the addresses connected to in its Control function.
+func WithDialer(d *net.Dialer) Option {
+	return func(c *Client) {
+		c.dialer = d
+	}
+}
+
+// WithTLSConfig replaces the default configuration of https connections.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		pool: &connPool{
+			idle:        map[string][]*persistConn{},
+			maxIdle:     DefaultMaxIdleConns,
+			idleTimeout: DefaultIdleTimeout,
+		},
+		timeout:      DefaultTimeout,
+		maxRedirects: DefaultMaxRedirects,
+		maxBodySize:  DefaultMaxBodySize,
+		dialer:       &net.Dialer{},
+		tlsConfig:    &tls.Config{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewRequest returns a request for the absolute http or https URL rawURL.
+func NewRequest(method, rawURL string, body []byte) (*request.Request, error) {
+	_, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HTTPVersion:   "1.1",
+			RequestTarget: rawURL,
+			Method:        method,
+		},
+		Headers:     headers.NewHeaders(),
+		Body:        body,
+		ParserState: request.ParserStateDone,
+	}, nil
+}
+
+// Get fetches rawURL.
+func (c *Client) Get(rawURL string) (*response.Response, error) {
+	req, err := NewRequest(request.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post sends body with contentType to rawURL.
+func (c *Client) Post(rawURL, contentType string, body []byte) (*response.Response, error) {
+	req, err := NewRequest(request.MethodPost, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers.Set(headers.ContentTypeHeader, contentType)
+	return c.Do(req)
+}
+
+// Do sends req, whose target must be in absolute-form, and follows
+// redirects. It asks for trailers with "TE: trailers" unless req sets TE.
+func (c *Client) Do(req *request.Request) (*response.Response, error) {
+	for redirects := 0; ; redirects++ {
+		target, err := parseURL(req.RequestLine.RequestTarget)
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := c.roundTrip(req, target)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := stream.readAll()
+		if err != nil {
+			return nil, err
+		}
+
+		next := redirect(req, resp, target)
+		if next == nil || c.maxRedirects == 0 {
+			return resp, nil
+		}
+		if redirects == c.maxRedirects {
+			return nil, ErrorTooManyRedirects
+		}
+		req = next
+	}
+}
+
+// RoundTrip sends req, whose target must be in absolute-form, and returns the
+// response as soon as its headers arrived, without following redirects. The
+// body is then read from the Stream as it arrives, which lets proxies relay
+// it without holding all of it; the timeout of c doesn't apply to it.
+func (c *Client) RoundTrip(req *request.Request) (*Stream, error) {
+	target, err := parseURL(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := c.roundTrip(req, target)
+	if err != nil {
+		return nil, err
+	}
+	err = stream.pc.SetDeadline(time.Time{})
+	if err != nil {
+		stream.release(false)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// CloseIdleConnections closes the connections kept for reuse.
+func (c *Client) CloseIdleConnections() {
+	c.pool.closeIdle()
+}
+
+// roundTrip sends req over a pooled or new connection. A request that fails
+// on a pooled connection the server had already given up on is sent again
+// over a new one if that is safe.
+func (c *Client) roundTrip(req *request.Request, target *url.URL) (*Stream, error) {
+	key := target.Scheme + "://" + target.Host
+
+	pc := c.pool.get(key)
+	if pc != nil {
+		resp, err := c.exchange(key, pc, req, target)
+		if !errors.Is(err, errorNoResponse) || !isIdempotent(req.RequestLine.Method) {
+			return resp, err
+		}
+	}
+
+	conn, err := c.dial(target)
+	if err != nil {
+		return nil, err
+	}
+	return c.exchange(key, newPersistConn(conn), req, target)
+}
+
+// exchange writes req to pc and reads the response up to the end of its
+// headers. The deadline of pc stays set for the body.
+func (c *Client) exchange(key string, pc *persistConn, req *request.Request, target *url.URL) (*Stream, error) {
+	err := pc.SetDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		pc.close()
+		return nil, err
+	}
+
+	err = outgoingRequest(req, target).Write(pc)
+	if err == nil {
+		_, err = pc.reader.Peek(1)
+	}
+	if err != nil {
+		pc.close()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", errorNoResponse, err)
+	}
+
+	resp, err := response.ResponseHeadFromReader(pc.reader,
+		response.WithRequestMethod(req.RequestLine.Method),
+		response.WithMaxBodySize(c.maxBodySize),
+	)
+	if err != nil {
+		pc.close()
+		return nil, err
+	}
+
+	return &Stream{
+		Response: resp,
+		body:     resp.BodyReader(),
+		pc:       pc,
+		key:      key,
+		pool:     c.pool,
+	}, nil
+}
+
+func (c *Client) dial(target *url.URL) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	dialer := c.dialer
+	if target.Scheme == "http" {
+		return dialer.DialContext(ctx, "tcp", target.Host)
+	}
+
+	cfg := c.tlsConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = target.Hostname()
+	}
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: cfg}
+	return tlsDialer.DialContext(ctx, "tcp", target.Host)
+}
+
+// outgoingRequest returns a copy of req to send to target: in origin-form,
+// with a Host and, unless req has its own TE, announcing "TE: trailers",
+// which requires listing TE in Connection too.
+func outgoingRequest(req *request.Request, target *url.URL) *request.Request {
+	out := *req
+	out.RequestLine.RequestTarget = target.RequestURI()
+	out.Headers = headers.NewHeaders()
+	for k, v := range req.Headers {
+		out.Headers.Override(k, v)
+	}
+
+	if _, ok := out.Headers.Get(headers.HostHeader); !ok {
+		out.Headers.Set(headers.HostHeader, hostHeader(target))
+	}
+	if _, ok := out.Headers.Get(headers.TEHeader); !ok {
+		out.Headers.Set(headers.TEHeader, "trailers")
+		out.Headers.Set(headers.ConnectionHeader, "TE")
+	}
+	return &out
+}
+
+// hostHeader returns the host of target, without the port if it is the
+// default one that parseURL filled in.
+func hostHeader(target *url.URL) string {
+	if (target.Scheme == "http" && target.Port() == "80") || (target.Scheme == "https" && target.Port() == "443") {
+		return strings.TrimSuffix(target.Host, ":"+target.Port())
+	}
+	return target.Host
+}
+
+// parseURL parses an absolute http or https URL and adds the default port
+// to its host.
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrorInvalidURL, rawURL)
+	}
+
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// isIdempotent reports whether sending a request with method twice has the
+// same effect as sending it once (RFC 9110 section 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case request.MethodGet, request.MethodHead, request.MethodOptions, request.MethodPut, request.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,438 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve starts our own server for handler and returns its base URL.
+func serve(t *testing.T, handler server.Handler) string {
+	t.Helper()
+
+	s, err := server.Serve(handler, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	return "http://" + s.Addr().String()
+}
+
+// serveRaw accepts connections and answers every request on them with the
+// raw bytes reply returns for its target, closing the connection
+// afterwards.
+func serveRaw(t *testing.T, reply func(target string) string) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				_, _ = io.WriteString(conn, reply(req.RequestLine.RequestTarget))
+			}()
+		}
+	}()
+
+	return "http://" + l.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	base := serve(t, func(w *response.Writer, req *request.Request) {
+		host, _ := req.Headers.Get(headers.HostHeader)
+		body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + host + " " + string(req.Body)
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	})
+	host := strings.TrimPrefix(base, "http://")
+	c := New()
+
+	// Test: GET sends the target in origin-form and the Host it came with
+	resp, err := c.Get(base + "/path?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeOK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "GET /path?q=1 "+host+" ", string(resp.Body))
+	contentType, _ := resp.Headers.Get(headers.ContentTypeHeader)
+	assert.Equal(t, "text/plain", contentType)
+
+	// Test: POST sends its body
+	resp, err = c.Post(base+"/submit", "text/plain", []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "POST /submit "+host+" hello", string(resp.Body))
+
+	// Test: HEAD reads no body, whatever Content-Length says
+	req, err := NewRequest(request.MethodHead, base+"/", nil)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Body)
+	contentLength, _ := resp.Headers.Get(headers.ContentLengthHeader)
+	assert.NotEqual(t, "0", contentLength)
+
+	// Test: a Host set on the request wins
+	req, err = NewRequest(request.MethodGet, base+"/", nil)
+	require.NoError(t, err)
+	req.Headers.Set(headers.HostHeader, "example.com")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / example.com ", string(resp.Body))
+}
+
+func TestClientChunked(t *testing.T) {
+	base := serve(t, func(w *response.Writer, _ *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Remove(headers.ContentLengthHeader)
+		h.Override(headers.TransferEncodingHeader, "chunked")
+		_ = w.DeclareTrailers(headers.XContentLengthHeader)
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte("hello "))
+		_, _ = w.WriteChunkedBody([]byte("world"))
+		_, _ = w.WriteChunkedBodyDone()
+		_ = w.WriteTrailers(headers.Headers{headers.XContentLengthHeader: "11"})
+	})
+
+	// Test: a chunked body is put back together, along with its trailers
+	resp, err := New().Get(base + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(resp.Body))
+	length, ok := resp.Trailers.Get(headers.XContentLengthHeader)
+	assert.True(t, ok)
+	assert.Equal(t, "11", length)
+}
+
+func TestClientFraming(t *testing.T) {
+	base := serveRaw(t, func(target string) string {
+		switch {
+		case strings.HasPrefix(target, "/close"):
+			return "HTTP/1.1 200 OK\r\ncontent-type: text/plain\r\n\r\nuntil the end"
+		case strings.HasPrefix(target, "/interim"):
+			return "HTTP/1.1 100 Continue\r\n\r\n" +
+				"HTTP/1.1 103 Early Hints\r\nlink: </style.css>\r\n\r\n" +
+				"HTTP/1.1 200 OK\r\ncontent-length: 4\r\n\r\ndone"
+		case strings.HasPrefix(target, "/nocontent"):
+			return "HTTP/1.1 204 No Content\r\n\r\n"
+		default:
+			return "HTTP/1.1 200 OK\r\ncontent-length: 10\r\n\r\nshort"
+		}
+	})
+	c := New(WithTimeout(time.Second))
+
+	// Test: without Content-Length the body ends with the connection
+	resp, err := c.Get(base + "/close")
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(resp.Body))
+
+	// Test: interim responses are skipped
+	resp, err = c.Get(base + "/interim")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeOK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "done", string(resp.Body))
+	_, ok := resp.Headers.Get("link")
+	assert.False(t, ok)
+
+	// Test: a 204 has no body
+	resp, err = c.Get(base + "/nocontent")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeNoContent, resp.StatusLine.StatusCode)
+	assert.Empty(t, resp.Body)
+
+	// Test: a body shorter than its Content-Length is an error
+	_, err = c.Get(base + "/short")
+	assert.ErrorIs(t, err, response.ErrorIncompleteResponse)
+}
+
+func TestClientMaxBodySize(t *testing.T) {
+	// an upstream whose body never ends
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				_, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				_, err = io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+				for err == nil {
+					_, err = io.WriteString(conn, strings.Repeat("x", 1024))
+				}
+			}()
+		}
+	}()
+	base := "http://" + l.Addr().String()
+
+	// Test: The body is cut off once it grows past the limit
+	c := New(WithTimeout(5*time.Second), WithMaxBodySize(64<<10))
+	_, err = c.Get(base + "/endless")
+	assert.ErrorIs(t, err, response.ErrorBodyTooLarge)
+
+	// Test: Bodies within the limit are read as usual
+	small := serveRaw(t, func(string) string {
+		return "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\nhello"
+	})
+	resp, err := c.Get(small)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(resp.Body))
+}
+
+func TestClientMalformedResponse(t *testing.T) {
+	base := serveRaw(t, func(target string) string {
+		switch {
+		case strings.HasPrefix(target, "/status"):
+			return "HTTP/1.1 OK\r\n\r\n"
+		case strings.HasPrefix(target, "/header"):
+			return "HTTP/1.1 200 OK\r\nno colon here\r\n\r\n"
+		default:
+			return "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\nzz\r\n"
+		}
+	})
+	c := New()
+
+	_, err := c.Get(base + "/status")
+	assert.ErrorIs(t, err, response.ErrorStatusLineMalformed)
+	_, err = c.Get(base + "/header")
+	assert.ErrorIs(t, err, response.ErrorFieldLineMalformed)
+	_, err = c.Get(base + "/chunk")
+	assert.ErrorIs(t, err, response.ErrorChunkMalformed)
+}
+
+func TestClientReuse(t *testing.T) {
+	var conns atomic.Int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+	c := New()
+
+	// Test: requests to the same host share a connection
+	for _, path := range []string{"/a", "/b", "/c"} {
+		resp, err := c.Get(upstream.URL + path)
+		require.NoError(t, err)
+		assert.Equal(t, path, string(resp.Body))
+	}
+	assert.Equal(t, int32(1), conns.Load())
+
+	// Test: a pooled connection the server closed is replaced
+	upstream.CloseClientConnections()
+	time.Sleep(10 * time.Millisecond)
+	resp, err := c.Get(upstream.URL + "/d")
+	require.NoError(t, err)
+	assert.Equal(t, "/d", string(resp.Body))
+	assert.Equal(t, int32(2), conns.Load())
+
+	// Test: after closing idle connections the next request dials again
+	c.CloseIdleConnections()
+	_, err = c.Get(upstream.URL + "/e")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), conns.Load())
+
+	// Test: responses with "Connection: close" are not pooled
+	closing := serveRaw(t, func(string) string {
+		return "HTTP/1.1 200 OK\r\nconnection: close\r\ncontent-length: 0\r\n\r\n"
+	})
+	_, err = c.Get(closing + "/")
+	require.NoError(t, err)
+	assert.Empty(t, c.pool.idle[closing])
+	assert.Len(t, c.pool.idle[upstream.URL], 1)
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	var conns atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Count")
+		_, _ = io.WriteString(w, "first ")
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/wait" {
+			<-release
+		}
+		_, _ = io.WriteString(w, "second")
+		w.Header().Set("X-Count", "2")
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+	c := New(WithMaxRedirects(0))
+
+	// Test: The response is returned before its body is complete
+	req, err := NewRequest(request.MethodGet, upstream.URL+"/wait", nil)
+	require.NoError(t, err)
+	stream, err := c.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeOK, stream.StatusLine.StatusCode)
+
+	buf := make([]byte, len("first "))
+	_, err = io.ReadFull(stream, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first ", string(buf))
+
+	// Test: The rest and the trailers follow
+	close(release)
+	rest, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(rest))
+	count, _ := stream.Trailers.Get("x-count")
+	assert.Equal(t, "2", count)
+	require.NoError(t, stream.Close())
+
+	// Test: A body read to the end frees the connection for the next request
+	req, err = NewRequest(request.MethodGet, upstream.URL+"/", nil)
+	require.NoError(t, err)
+	stream, err = c.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), conns.Load())
+
+	// Test: One closed early doesn't
+	require.NoError(t, stream.Close())
+	assert.Empty(t, c.pool.idle[upstream.URL])
+	_, err = stream.Read(buf)
+	assert.ErrorIs(t, err, net.ErrClosed)
+	resp, err := c.Get(upstream.URL + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "first second", string(resp.Body))
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+func TestClientRedirects(t *testing.T) {
+	other := serve(t, func(w *response.Writer, req *request.Request) {
+		_, cookie := req.Headers.Get(headers.CookieHeader)
+		body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget
+		if cookie {
+			body += " with cookie"
+		}
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	})
+
+	var base string
+	base = serve(t, func(w *response.Writer, req *request.Request) {
+		redirects := map[string]struct {
+			status   response.StatusCode
+			location string
+		}{
+			"/moved":     {response.StatusCodeMovedPermanently, "/final"},
+			"/see-other": {response.StatusCodeSeeOther, "final"},
+			"/temporary": {response.StatusCodeTemporaryRedirect, base + "/final?kept"},
+			"/elsewhere": {response.StatusCodeFound, other + "/final"},
+			"/loop":      {response.StatusCodeFound, "/loop"},
+		}
+		r, ok := redirects[req.RequestLine.RequestTarget]
+		if !ok {
+			body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body)
+			_ = w.WriteStatusLine(response.StatusCodeOK)
+			_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			_, _ = w.WriteBody([]byte(body))
+			return
+		}
+		_ = w.WriteError(r.status, headers.Headers{headers.LocationHeader: r.location})
+	})
+	c := New()
+
+	// Test: a relative Location is followed
+	resp, err := c.Get(base + "/moved")
+	require.NoError(t, err)
+	assert.Equal(t, "GET /final ", string(resp.Body))
+
+	// Test: 303 turns a POST into a GET without body
+	resp, err = c.Post(base+"/see-other", "text/plain", []byte("payload"))
+	require.NoError(t, err)
+	assert.Equal(t, "GET /final ", string(resp.Body))
+
+	// Test: 307 repeats the POST with its body
+	resp, err = c.Post(base+"/temporary", "text/plain", []byte("payload"))
+	require.NoError(t, err)
+	assert.Equal(t, "POST /final?kept payload", string(resp.Body))
+
+	// Test: credentials are not sent to another host
+	req, err := NewRequest(request.MethodGet, base+"/elsewhere", nil)
+	require.NoError(t, err)
+	req.Headers.Set(headers.CookieHeader, "session=secret")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /final", string(resp.Body))
+
+	// Test: a redirect loop is cut short
+	_, err = New(WithMaxRedirects(3)).Get(base + "/loop")
+	assert.ErrorIs(t, err, ErrorTooManyRedirects)
+
+	// Test: without following, the redirect itself is returned
+	resp, err = New(WithMaxRedirects(0)).Get(base + "/moved")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeMovedPermanently, resp.StatusLine.StatusCode)
+	location, _ := resp.Headers.Get(headers.LocationHeader)
+	assert.Equal(t, "/final", location)
+}
+
+func TestClientTimeout(t *testing.T) {
+	stalled := serveRaw(t, func(string) string {
+		time.Sleep(200 * time.Millisecond)
+		return "HTTP/1.1 200 OK\r\ncontent-length: 0\r\n\r\n"
+	})
+
+	// Test: a server that doesn't answer in time fails the request
+	start := time.Now()
+	_, err := New(WithTimeout(50 * time.Millisecond)).Get(stalled + "/")
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestClientInvalidURL(t *testing.T) {
+	for _, rawURL := range []string{"/relative", "ftp://example.com/", "http://", "http://[::1"} {
+		_, err := New().Get(rawURL)
+		assert.ErrorIs(t, err, ErrorInvalidURL, rawURL)
+	}
+}
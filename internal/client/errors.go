@@ -0,0 +1,13 @@
+package client
+
+import "errors"
+
+var (
+	ErrorInvalidURL       = errors.New("request target is not an absolute http or https URL")
+	ErrorTooManyRedirects = errors.New("too many redirects")
+
+	// errorNoResponse wraps failures on a reused connection before any of
+	// the response arrived, typically because the server closed it while it
+	// was idle.
+	errorNoResponse = errors.New("connection closed before the response")
+)
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// readBufferSize bounds the lines of a response: the status line, fields and
+// chunk sizes.
+const readBufferSize = 64 << 10
+
+// persistConn is a connection that may carry several requests in turn.
+type persistConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	idleSince time.Time
+}
+
+func newPersistConn(conn net.Conn) *persistConn {
+	return &persistConn{
+		Conn:   conn,
+		reader: bufio.NewReaderSize(conn, readBufferSize),
+	}
+}
+
+func (pc *persistConn) close() {
+	err := pc.Close()
+	if err != nil {
+		log.Printf("Failed to close connection: %v", err)
+	}
+}
+
+// connPool keeps idle connections per scheme, host and port.
+type connPool struct {
+	mu          sync.Mutex
+	idle        map[string][]*persistConn
+	maxIdle     int
+	idleTimeout time.Duration
+}
+
+// get returns the most recently used idle connection for key, or nil.
+func (p *connPool) get(key string) *persistConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		p.idle[key] = conns
+
+		if time.Since(pc.idleSince) < p.idleTimeout {
+			return pc
+		}
+		pc.close()
+	}
+	return nil
+}
+
+// put keeps pc for the next request to key, unless there are enough idle
+// connections already.
+func (p *connPool) put(key string, pc *persistConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[key]) >= p.maxIdle {
+		pc.close()
+		return
+	}
+	pc.idleSince = time.Now()
+	p.idle[key] = append(p.idle[key], pc)
+}
+
+// closeIdle closes every idle connection.
+func (p *connPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, conns := range p.idle {
+		for _, pc := range conns {
+			pc.close()
+		}
+		delete(p.idle, key)
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"net/url"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// redirect returns the request to send after resp to req, which was sent to
+// target, or nil if resp is not a redirect to follow.
+func redirect(req *request.Request, resp *response.Response, target *url.URL) *request.Request {
+	switch resp.StatusLine.StatusCode {
+	case response.StatusCodeMovedPermanently, response.StatusCodeFound, response.StatusCodeSeeOther,
+		response.StatusCodeTemporaryRedirect, response.StatusCodePermanentRedirect:
+	default:
+		return nil
+	}
+
+	location, ok := resp.Headers.Get(headers.LocationHeader)
+	if !ok {
+		return nil
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil
+	}
+	next := target.ResolveReference(ref)
+	next.Fragment = ""
+
+	out := &request.Request{
+		RequestLine: request.RequestLine{
+			HTTPVersion:   req.RequestLine.HTTPVersion,
+			RequestTarget: next.String(),
+			Method:        req.RequestLine.Method,
+		},
+		Headers:     headers.NewHeaders(),
+		Body:        req.Body,
+		ParserState: request.ParserStateDone,
+	}
+	for k, v := range req.Headers {
+		out.Headers.Override(k, v)
+	}
+
+	// 307 and 308 repeat the request as is; the others turn it into a GET,
+	// which browsers also do for 301 and 302 despite what they were meant for
+	// (RFC 9110 section 15.4)
+	if resp.StatusLine.StatusCode == response.StatusCodeSeeOther ||
+		(resp.StatusLine.StatusCode != response.StatusCodeTemporaryRedirect &&
+			resp.StatusLine.StatusCode != response.StatusCodePermanentRedirect &&
+			req.RequestLine.Method == request.MethodPost) {
+		if out.RequestLine.Method != request.MethodHead {
+			out.RequestLine.Method = request.MethodGet
+		}
+		out.Body = nil
+		out.Headers.Remove(headers.ContentTypeHeader)
+		out.Headers.Remove(headers.ContentEncodingHeader)
+	}
+
+	// the Host of the old target doesn't apply to the new one, nor do its
+	// credentials
+	out.Headers.Remove(headers.HostHeader)
+	if !sameOrigin(target, next) {
+		out.Headers.Remove(headers.AuthorizationHeader)
+		out.Headers.Remove(headers.CookieHeader)
+	}
+
+	return out
+}
+
+// sameOrigin compares scheme and host, the latter with its default port
+// filled in by parseURL.
+func sameOrigin(a, b *url.URL) bool {
+	next, err := parseURL(b.String())
+	if err != nil {
+		return false
+	}
+	return a.Scheme == next.Scheme && a.Host == next.Host
+}
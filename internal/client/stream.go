@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// Stream is a response returned by RoundTrip, whose body is read from the
+// Stream itself. Once the body was read to the end, the connection goes back
+// to the pool; closing the Stream before that closes the connection. Either
+// way it must be closed.
+type Stream struct {
+	*response.Response
+
+	body io.Reader
+	// pc is nil once the connection was released.
+	pc   *persistConn
+	key  string
+	pool *connPool
+}
+
+// Read reads the body, decoded from its framing. Trailers are set once it
+// returned io.EOF.
+func (s *Stream) Read(p []byte) (int, error) {
+	if s.pc == nil {
+		if s.ParserState == response.ParserStateDone {
+			return 0, io.EOF
+		}
+		return 0, net.ErrClosed
+	}
+
+	n, err := s.body.Read(p)
+	switch {
+	case errors.Is(err, io.EOF):
+		s.release(true)
+	case err != nil:
+		s.release(false)
+	}
+	return n, err
+}
+
+// Close releases the connection, which is only reused if the body was read
+// to the end.
+func (s *Stream) Close() error {
+	if s.pc != nil {
+		s.release(s.ParserState == response.ParserStateDone)
+	}
+	return nil
+}
+
+// readAll reads the whole body into Body and returns the response.
+func (s *Stream) readAll() (*response.Response, error) {
+	body, err := io.ReadAll(s)
+	if err != nil {
+		return nil, err
+	}
+	s.Body = body
+	return s.Response, nil
+}
+
+// release pools the connection if the response was read completely and the
+// connection may carry another request, or closes it.
+func (s *Stream) release(complete bool) {
+	pc := s.pc
+	s.pc = nil
+
+	// anything sent past the response can't be matched to a request
+	if !complete || !s.KeepAlive() || pc.reader.Buffered() > 0 {
+		pc.close()
+		return
+	}
+	err := pc.SetDeadline(time.Time{})
+	if err != nil {
+		pc.close()
+		return
+	}
+	s.pool.put(s.key, pc)
+}
@@ -0,0 +1,232 @@
+// Package compress negotiates content codings with clients, applies them to
+// response bodies and undoes them on request bodies.
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/conditional"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+)
+
+const (
+	Gzip     = "gzip"
+	Deflate  = "deflate"
+	Identity = "identity"
+)
+
+// DefaultMinLength is the smallest fixed-length body worth compressing; below
+// it the gzip header and footer eat most of the savings.
+const DefaultMinLength = 256
+
+// Negotiate picks the coding from offered, in order of our preference, that
+// Accept-Encoding ranks highest. It returns "" when the body should be sent
+// as is: when the client sent no Accept-Encoding at all, or ranks identity,
+// i.e. no coding, above all of offered. Identity ranks like any coding, and
+// with q=1 when neither it nor "*" is listed; on a tie the coding wins.
+func Negotiate(acceptEncoding string, offered ...string) string {
+	values := headers.ParseQualityValues(acceptEncoding)
+
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		q, _ := quality(values, coding)
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	identityQ, listed := quality(values, Identity)
+	if !listed && len(values) > 0 {
+		identityQ = 1
+	}
+	if identityQ > bestQ {
+		return ""
+	}
+	return best
+}
+
+// quality returns the weight values give coding, from its own element or
+// else from "*", and whether either is there.
+func quality(values []headers.QualityValue, coding string) (float64, bool) {
+	q, wildcard := 0.0, -1.0
+	explicit := false
+	for _, qv := range values {
+		switch qv.Value {
+		case coding:
+			q, explicit = qv.Q, true
+		case "*":
+			wildcard = qv.Q
+		}
+	}
+	if !explicit && wildcard >= 0 {
+		return wildcard, true
+	}
+	return q, explicit
+}
+
+// compressibleTypes are the media types whose bodies shrink when compressed.
+// Images, audio, video and archives are already compressed.
+var compressibleTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/problem+json",
+	"application/wasm",
+	"application/xml",
+	"image/svg+xml",
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	default:
+		return slices.Contains(compressibleTypes, mediaType)
+	}
+}
+
+type config struct {
+	minLength      int
+	level          int
+	maxDecodedSize int
+	codings        []string
+}
+
+// Option configures Responses or Requests.
+type Option func(*config)
+
+// WithMinLength overrides DefaultMinLength for Responses. Bodies without a
+// Content-Length are always compressed.
+func WithMinLength(n int) Option {
+	return func(c *config) {
+		c.minLength = n
+	}
+}
+
+// WithLevel sets the compression level for Responses, from flate.BestSpeed to
+// flate.BestCompression.
+func WithLevel(level int) Option {
+	return func(c *config) {
+		c.level = level
+	}
+}
+
+// Responses wraps next so that eligible response bodies are compressed with
+// the best coding the client accepts, gzip or deflate. It works for both
+// fixed-length and chunked bodies: the Content-Length of the former is
+// dropped and the body is sent chunked instead.
+func Responses(next server.Handler, opts ...Option) server.Handler {
+	cfg := config{
+		minLength: DefaultMinLength,
+		level:     flate.DefaultCompression,
+		codings:   []string{Gzip, Deflate},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		acceptEncoding, _ := req.Headers.Get(headers.AcceptEncodingHeader)
+		w.SetTransform(&transform{
+			cfg:    cfg,
+			coding: Negotiate(acceptEncoding, cfg.codings...),
+		})
+
+		next(w, req)
+
+		err := w.Finish()
+		if err != nil {
+			log.Printf("Failed to finish compressed response: %v", err)
+		}
+	}
+}
+
+type transform struct {
+	cfg    config
+	coding string
+}
+
+func (t *transform) Wrap(statusCode response.StatusCode, h headers.Headers, dst io.Writer) io.WriteCloser {
+	contentType, _ := h.Get(headers.ContentTypeHeader)
+	if !isCompressible(contentType) {
+		return nil
+	}
+	if encoding, ok := h.Get(headers.ContentEncodingHeader); ok && !strings.EqualFold(encoding, Identity) {
+		return nil
+	}
+	if _, ok := h.Get(headers.ContentRangeHeader); ok {
+		// a part of the content can't be recompressed on its own
+		return nil
+	}
+
+	// from here on what we send depends on Accept-Encoding, even if it turns
+	// out to be the body as is
+	addVary(h, headers.AcceptEncodingHeader)
+
+	if t.coding == "" || !statusCode.AllowsBody() || statusCode == response.StatusCodePartialContent {
+		return nil
+	}
+	if lengthStr, ok := h.Get(headers.ContentLengthHeader); ok {
+		length, err := strconv.Atoi(lengthStr)
+		if err == nil && length < t.cfg.minLength {
+			return nil
+		}
+	}
+
+	encoder, err := t.newEncoder(dst)
+	if err != nil {
+		log.Printf("Failed to create %s encoder: %v", t.coding, err)
+		return nil
+	}
+
+	h.Remove(headers.ContentLengthHeader)
+	h.Override(headers.ContentEncodingHeader, t.coding)
+	if etag, ok := h.Get(headers.ETagHeader); ok {
+		// the compressed bytes differ, but mean the same thing
+		h.Override(headers.ETagHeader, conditional.Weak(etag))
+	}
+
+	return encoder
+}
+
+func (t *transform) newEncoder(dst io.Writer) (io.WriteCloser, error) {
+	switch t.coding {
+	case Gzip:
+		return gzip.NewWriterLevel(dst, t.cfg.level)
+	case Deflate:
+		// "deflate" in HTTP means deflate data in the zlib format
+		return zlib.NewWriterLevel(dst, t.cfg.level)
+	default:
+		return nil, ErrorUnsupportedCoding
+	}
+}
+
+func addVary(h headers.Headers, field string) {
+	vary, ok := h.Get(headers.VaryHeader)
+	if !ok {
+		h.Override(headers.VaryHeader, field)
+		return
+	}
+
+	for _, existing := range headers.SplitList(vary) {
+		if existing == "*" || strings.EqualFold(existing, field) {
+			return
+		}
+	}
+	h.Set(headers.VaryHeader, field)
+}
@@ -0,0 +1,270 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var page = strings.Repeat("<p>hello, compressible world</p>\n", 50)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", Gzip},
+		{"deflate", Deflate},
+		{"gzip, deflate, br", Gzip},
+		{"deflate, gzip;q=0.5", Deflate},
+		{"gzip;q=0.5, deflate;q=0.5", ""},
+		{"gzip;q=0.5, deflate;q=0.5, identity;q=0.5", Gzip},
+		{"br", ""},
+		{"*", Gzip},
+		{"*;q=0.1, gzip;q=0", Deflate},
+		{"identity", ""},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"identity;q=1, gzip;q=0.1", ""},
+		{"gzip;q=0.5", ""},
+		{"gzip;q=0.5, identity;q=0.5", Gzip},
+		{"gzip;q=0.5, identity;q=0.6", ""},
+		{"gzip;q=0.5, *;q=0.6", Deflate},
+		{"gzip;q=0.5, identity;q=0", Gzip},
+		{"*;q=0.5, identity", ""},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, Negotiate(c.acceptEncoding, Gzip, Deflate), c.acceptEncoding)
+	}
+}
+
+func fixedHandler(contentType, body string) func(w *response.Writer, r *request.Request) {
+	return func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		h := response.GetDefaultHeaders(len(body))
+		h.Override(headers.ContentTypeHeader, contentType)
+		h.Override(headers.ETagHeader, `"abc"`)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(body))
+	}
+}
+
+func chunkedHandler(w *response.Writer, _ *request.Request) {
+	_ = w.WriteStatusLine(response.StatusCodeOK)
+	h := response.GetDefaultHeaders(0)
+	h.Remove(headers.ContentLengthHeader)
+	h.Override(headers.ContentTypeHeader, "application/json")
+	h.Override(headers.TransferEncodingHeader, "chunked")
+	h.Override(headers.VaryHeader, "Accept-Language")
+	_ = w.WriteHeaders(h)
+	for range 3 {
+		_, _ = w.WriteChunkedBody([]byte(`{"hello":"world"}`))
+	}
+	_, _ = w.WriteChunkedBodyDone()
+	_ = w.WriteTrailers(nil)
+}
+
+func run(handler func(w *response.Writer, r *request.Request), method, acceptEncoding string, opts ...Option) (head string, body []byte) {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: "/"},
+		Headers:     headers.NewHeaders(),
+	}
+	if acceptEncoding != "" {
+		req.Headers.Set(headers.AcceptEncodingHeader, acceptEncoding)
+	}
+
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	if method == request.MethodHead {
+		w.DiscardBody()
+	}
+	Responses(handler, opts...)(w, req)
+
+	head, rest, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	return head + "\r\n", []byte(rest)
+}
+
+func dechunk(t *testing.T, body []byte) []byte {
+	t.Helper()
+
+	decoded, err := io.ReadAll(httputil.NewChunkedReader(bytes.NewReader(body)))
+	require.NoError(t, err)
+	return decoded
+}
+
+func gunzip(t *testing.T, body []byte) string {
+	t.Helper()
+
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func TestResponses(t *testing.T) {
+	// Test: Fixed length body becomes chunked gzip
+	head, body := run(fixedHandler("text/html", page), "GET", "gzip, deflate")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Contains(t, head, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, head, "vary: accept-encoding\r\n")
+	assert.Contains(t, head, "etag: W/\"abc\"\r\n")
+	assert.NotContains(t, head, "content-length")
+	assert.True(t, bytes.HasSuffix(body, []byte("0\r\n\r\n")))
+	compressed := dechunk(t, body)
+	assert.Less(t, len(compressed), len(page))
+	assert.Equal(t, page, gunzip(t, compressed))
+
+	// Test: Chunked body stays chunked and keeps its Vary
+	head, body = run(chunkedHandler, "GET", "gzip")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Contains(t, head, "vary: Accept-Language, accept-encoding\r\n")
+	assert.Equal(t, strings.Repeat(`{"hello":"world"}`, 3), gunzip(t, dechunk(t, body)))
+
+	// Test: Deflate
+	head, body = run(fixedHandler("text/plain", page), "GET", "deflate")
+	assert.Contains(t, head, "content-encoding: deflate\r\n")
+	r, err := zlib.NewReader(bytes.NewReader(dechunk(t, body)))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+
+	// Test: HEAD gets the same headers without a body
+	head, body = run(fixedHandler("text/html", page), "HEAD", "gzip")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Empty(t, body)
+}
+
+func TestResponsesSkipped(t *testing.T) {
+	// Test: Client doesn't accept any coding
+	head, body := run(fixedHandler("text/html", page), "GET", "")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "vary: accept-encoding\r\n")
+	assert.Equal(t, page, string(body))
+
+	// Test: Already compressed media
+	head, body = run(fixedHandler("video/mp4", page), "GET", "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.NotContains(t, head, "vary")
+	assert.Equal(t, page, string(body))
+
+	// Test: Too small to bother
+	head, body = run(fixedHandler("text/html", "tiny"), "GET", "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Equal(t, "tiny", string(body))
+
+	// Test: Unless configured otherwise
+	head, _ = run(fixedHandler("text/html", "tiny"), "GET", "gzip", WithMinLength(0))
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+
+	// Test: Bodies that are already encoded
+	precompressed := func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		h := response.GetDefaultHeaders(len(page))
+		h.Override(headers.ContentEncodingHeader, "br")
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(page))
+	}
+	head, _ = run(precompressed, "GET", "gzip")
+	assert.Contains(t, head, "content-encoding: br\r\n")
+	assert.Contains(t, head, "content-length: ")
+}
+
+func encode(t *testing.T, coding string, body []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch coding {
+	case Gzip:
+		w = gzip.NewWriter(buf)
+	case Deflate:
+		w = zlib.NewWriter(buf)
+	}
+	_, err := w.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func upload(contentEncoding string, body []byte, opts ...Option) (status string, received *request.Request) {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "POST", RequestTarget: "/"},
+		Headers:     headers.NewHeaders(),
+		Body:        body,
+	}
+	req.Headers.Set(headers.ContentLengthHeader, strconv.Itoa(len(body)))
+	if contentEncoding != "" {
+		req.Headers.Set(headers.ContentEncodingHeader, contentEncoding)
+	}
+
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	Requests(func(w *response.Writer, r *request.Request) {
+		received = r
+		_ = w.WriteError(response.StatusCodeOK, nil)
+	}, opts...)(w, req)
+
+	status, _, _ = strings.Cut(buf.String(), "\r\n")
+	return status, received
+}
+
+func TestRequests(t *testing.T) {
+	// Test: Plain body is passed through
+	status, req := upload("", []byte(page))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, page, string(req.Body))
+
+	// Test: Gzip
+	status, req = upload("gzip", encode(t, Gzip, []byte(page)))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, page, string(req.Body))
+	_, ok := req.Headers.Get(headers.ContentEncodingHeader)
+	assert.False(t, ok)
+	length, _ := req.Headers.Get(headers.ContentLengthHeader)
+	assert.Equal(t, strconv.Itoa(len(page)), length)
+
+	// Test: Deflate
+	status, req = upload("deflate", encode(t, Deflate, []byte(page)))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, page, string(req.Body))
+
+	// Test: Codings are undone in reverse order
+	status, req = upload("gzip, identity, deflate", encode(t, Deflate, encode(t, Gzip, []byte(page))))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, page, string(req.Body))
+
+	// Test: Unsupported coding
+	status, req = upload("br", []byte(page))
+	assert.Equal(t, "HTTP/1.1 415 Unsupported Media Type", status)
+	assert.Nil(t, req)
+
+	// Test: Corrupt body
+	status, req = upload("gzip", []byte(page))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	assert.Nil(t, req)
+
+	// Test: Zip bomb
+	bomb := encode(t, Gzip, make([]byte, 1<<20))
+	status, req = upload("gzip", bomb, WithMaxDecodedSize(64<<10))
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+	assert.Nil(t, req)
+
+	// Test: Exactly at the limit
+	status, req = upload("gzip", encode(t, Gzip, []byte(page)), WithMaxDecodedSize(len(page)))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Len(t, req.Body, len(page))
+}
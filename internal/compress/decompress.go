@@ -0,0 +1,129 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+)
+
+// DefaultMaxDecodedSize caps how large a request body may grow when it is
+// decompressed. A few kilobytes of gzip can expand to gigabytes.
+const DefaultMaxDecodedSize = 10 << 20
+
+// WithMaxDecodedSize overrides DefaultMaxDecodedSize for Requests.
+func WithMaxDecodedSize(n int) Option {
+	return func(c *config) {
+		c.maxDecodedSize = n
+	}
+}
+
+// Requests wraps next so that request bodies sent with a Content-Encoding of
+// gzip or deflate reach it decompressed, with Content-Encoding removed and
+// Content-Length updated to match. Other codings are rejected with 415, a body
+// that doesn't decode with 400 and one that decodes to more than the size
+// limit with 413.
+//
+// A Content-Digest covers the encoded body, so digest.RequireValid has to run
+// before Requests, not inside it.
+func Requests(next server.Handler, opts ...Option) server.Handler {
+	cfg := config{
+		maxDecodedSize: DefaultMaxDecodedSize,
+		codings:        []string{Gzip, Deflate},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		encoding, ok := req.Headers.Get(headers.ContentEncodingHeader)
+		if !ok {
+			next(w, req)
+			return
+		}
+
+		body, err := decode(req.Body, headers.SplitList(encoding), cfg)
+		switch {
+		case errors.Is(err, ErrorUnsupportedCoding):
+			// tell the client what it may use instead
+			extra := headers.NewHeaders()
+			extra.Set(headers.AcceptEncodingHeader, strings.Join(cfg.codings, ", "))
+			writeError(w, response.StatusCodeUnsupportedMediaType, extra)
+			return
+		case errors.Is(err, ErrorDecodedBodyTooLong):
+			writeError(w, response.StatusCodeContentTooLarge, nil)
+			return
+		case err != nil:
+			log.Printf("Failed to decode %s request body: %v", encoding, err)
+			writeError(w, response.StatusCodeBadRequest, nil)
+			return
+		}
+
+		req.Body = body
+		req.Headers.Remove(headers.ContentEncodingHeader)
+		req.Headers.Override(headers.ContentLengthHeader, strconv.Itoa(len(body)))
+
+		next(w, req)
+	}
+}
+
+// decode undoes codings, which are listed in the order they were applied.
+func decode(body []byte, codings []string, cfg config) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(body)
+
+	for _, coding := range slices.Backward(codings) {
+		coding = strings.ToLower(coding)
+		if coding == Identity {
+			continue
+		}
+		if !slices.Contains(cfg.codings, coding) {
+			return nil, ErrorUnsupportedCoding
+		}
+
+		decoder, err := newDecoder(coding, r)
+		if err != nil {
+			return nil, err
+		}
+		r = decoder
+	}
+
+	// read one byte past the limit to tell a body that fits exactly from one
+	// that doesn't
+	decoded, err := io.ReadAll(io.LimitReader(r, int64(cfg.maxDecodedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > cfg.maxDecodedSize {
+		return nil, ErrorDecodedBodyTooLong
+	}
+
+	return decoded, nil
+}
+
+func newDecoder(coding string, r io.Reader) (io.Reader, error) {
+	switch coding {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Deflate:
+		return zlib.NewReader(r)
+	default:
+		return nil, ErrorUnsupportedCoding
+	}
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, extra headers.Headers) {
+	err := w.WriteError(statusCode, extra)
+	if err != nil {
+		log.Printf("Failed to write %d response: %v", statusCode, err)
+	}
+}
@@ -0,0 +1,8 @@
+package compress
+
+import "errors"
+
+var (
+	ErrorUnsupportedCoding  = errors.New("unsupported content coding")
+	ErrorDecodedBodyTooLong = errors.New("decoded body exceeds the size limit")
+)
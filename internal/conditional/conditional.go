@@ -0,0 +1,180 @@
+// Package conditional generates validators (ETag and Last-Modified) and
+// evaluates conditional requests against them as described in RFC 9110
+// section 13.
+package conditional
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// Result is the outcome of evaluating the preconditions of a request.
+type Result int
+
+const (
+	// ResultProceed means the request should be answered normally.
+	ResultProceed Result = iota
+	// ResultNotModified means the client's cached copy is still good (304).
+	ResultNotModified
+	// ResultPreconditionFailed means the client's precondition is false (412).
+	ResultPreconditionFailed
+)
+
+// ETagFromBytes returns a strong entity tag for a buffered body.
+func ETagFromBytes(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+}
+
+// ETagFromFile returns a strong entity tag for a file from its size and
+// modification time, so it can be computed without reading the file.
+func ETagFromFile(size int64, modTime time.Time) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// Weak marks etag as weak, e.g. for a representation that is semantically
+// but not byte-for-byte equivalent, such as a compressed variant.
+func Weak(etag string) string {
+	if etag == "" || isWeak(etag) {
+		return etag
+	}
+	return "W/" + etag
+}
+
+func isWeak(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+// strongMatch compares two entity tags with the strong comparison function:
+// both must be strong and identical.
+func strongMatch(a, b string) bool {
+	return !isWeak(a) && !isWeak(b) && a == b
+}
+
+// weakMatch compares two entity tags with the weak comparison function, which
+// ignores the weakness indicator.
+func weakMatch(a, b string) bool {
+	return opaqueTag(a) == opaqueTag(b)
+}
+
+// matchesAny reports whether etag matches a list field value such as
+// If-Match or If-None-Match. "*" matches any current representation.
+func matchesAny(value, etag string, match func(a, b string) bool) bool {
+	if strings.TrimSpace(value) == "*" {
+		return etag != ""
+	}
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range headers.SplitList(value) {
+		if match(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check evaluates If-Match, If-Unmodified-Since, If-None-Match and
+// If-Modified-Since in the order of RFC 9110 section 13.2.2 against the
+// validators of the selected representation. An empty etag or zero
+// lastModified means the representation has no such validator.
+//
+// Preconditions only apply to requests that would otherwise succeed, so
+// callers should skip Check when they are about to send an error.
+func Check(req *request.Request, etag string, lastModified time.Time) Result {
+	method := req.RequestLine.Method
+	isGetOrHead := method == request.MethodGet || method == request.MethodHead
+	lastModified = lastModified.Truncate(time.Second)
+
+	if ifMatch, ok := req.Headers.Get(headers.IfMatchHeader); ok {
+		if !matchesAny(ifMatch, etag, strongMatch) {
+			return ResultPreconditionFailed
+		}
+	} else if since, ok := parseDateHeader(req, headers.IfUnmodifiedSinceHeader); ok && !lastModified.IsZero() {
+		if lastModified.After(since) {
+			return ResultPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch, ok := req.Headers.Get(headers.IfNoneMatchHeader); ok {
+		if matchesAny(ifNoneMatch, etag, weakMatch) {
+			if isGetOrHead {
+				return ResultNotModified
+			}
+			return ResultPreconditionFailed
+		}
+	} else if since, ok := parseDateHeader(req, headers.IfModifiedSinceHeader); ok && isGetOrHead && !lastModified.IsZero() {
+		if !lastModified.After(since) {
+			return ResultNotModified
+		}
+	}
+
+	return ResultProceed
+}
+
+func parseDateHeader(req *request.Request, key string) (time.Time, bool) {
+	value, ok := req.Headers.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := headers.ParseTime(value)
+	if err != nil {
+		// invalid dates make the precondition be ignored
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// SetValidators adds ETag and Last-Modified to h, skipping the ones that are
+// not available.
+func SetValidators(h headers.Headers, etag string, lastModified time.Time) {
+	if etag != "" {
+		h.Override(headers.ETagHeader, etag)
+	}
+	if !lastModified.IsZero() {
+		h.Override(headers.LastModifiedHeader, headers.FormatTime(lastModified))
+	}
+}
+
+// Respond runs Check and, unless the request should proceed, writes the 304
+// or 412 response and returns true. extra holds headers the full response
+// would have carried that a 304 must repeat, such as Vary; it may be nil.
+func Respond(w *response.Writer, req *request.Request, etag string, lastModified time.Time, extra headers.Headers) bool {
+	var statusCode response.StatusCode
+	switch Check(req, etag, lastModified) {
+	case ResultNotModified:
+		statusCode = response.StatusCodeNotModified
+	case ResultPreconditionFailed:
+		statusCode = response.StatusCodePreconditionFailed
+	default:
+		return false
+	}
+
+	h := headers.NewHeaders()
+	for k, v := range extra {
+		h.Override(k, v)
+	}
+	if statusCode == response.StatusCodeNotModified {
+		SetValidators(h, etag, lastModified)
+	}
+
+	err := w.WriteError(statusCode, h)
+	if err != nil {
+		log.Printf("Failed to write %d response: %v", statusCode, err)
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package conditional
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+var lastModified = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+const (
+	etag   = `"v2"`
+	before = "Thu, 29 Feb 2024 12:00:00 GMT"
+	same   = "Fri, 01 Mar 2024 12:00:00 GMT"
+	after  = "Sat, 02 Mar 2024 12:00:00 GMT"
+)
+
+func newRequest(method string, h map[string]string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: "/"},
+		Headers:     headers.NewHeaders(),
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	return req
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		h      map[string]string
+		want   Result
+	}{
+		{"no preconditions", "GET", nil, ResultProceed},
+
+		{"if-match hit", "PUT", map[string]string{"If-Match": `"v1", "v2"`}, ResultProceed},
+		{"if-match miss", "PUT", map[string]string{"If-Match": `"v1"`}, ResultPreconditionFailed},
+		{"if-match weak never matches", "PUT", map[string]string{"If-Match": `W/"v2"`}, ResultPreconditionFailed},
+		{"if-match star", "PUT", map[string]string{"If-Match": "*"}, ResultProceed},
+
+		{"if-unmodified-since ok", "PUT", map[string]string{"If-Unmodified-Since": same}, ResultProceed},
+		{"if-unmodified-since stale", "PUT", map[string]string{"If-Unmodified-Since": before}, ResultPreconditionFailed},
+		{"if-unmodified-since invalid", "PUT", map[string]string{"If-Unmodified-Since": "yesterday"}, ResultProceed},
+		{"if-match wins over if-unmodified-since", "PUT", map[string]string{
+			"If-Match":            etag,
+			"If-Unmodified-Since": before,
+		}, ResultProceed},
+
+		{"if-none-match hit", "GET", map[string]string{"If-None-Match": `"v1", W/"v2"`}, ResultNotModified},
+		{"if-none-match hit on head", "HEAD", map[string]string{"If-None-Match": etag}, ResultNotModified},
+		{"if-none-match hit on put", "PUT", map[string]string{"If-None-Match": "*"}, ResultPreconditionFailed},
+		{"if-none-match miss", "GET", map[string]string{"If-None-Match": `"v1"`}, ResultProceed},
+
+		{"if-modified-since fresh", "GET", map[string]string{"If-Modified-Since": same}, ResultNotModified},
+		{"if-modified-since later", "GET", map[string]string{"If-Modified-Since": after}, ResultNotModified},
+		{"if-modified-since stale", "GET", map[string]string{"If-Modified-Since": before}, ResultProceed},
+		{"if-modified-since ignored for post", "POST", map[string]string{"If-Modified-Since": same}, ResultProceed},
+		{"if-none-match wins over if-modified-since", "GET", map[string]string{
+			"If-None-Match":     `"v1"`,
+			"If-Modified-Since": after,
+		}, ResultProceed},
+
+		{"failed if-match wins over if-none-match", "GET", map[string]string{
+			"If-Match":      `"v1"`,
+			"If-None-Match": etag,
+		}, ResultPreconditionFailed},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, Check(newRequest(c.method, c.h), etag, lastModified), c.name)
+	}
+
+	// Test: Representations without validators
+	assert.Equal(t, ResultPreconditionFailed, Check(newRequest("PUT", map[string]string{"If-Match": "*"}), "", time.Time{}))
+	assert.Equal(t, ResultProceed, Check(newRequest("GET", map[string]string{"If-Modified-Since": same}), "", time.Time{}))
+}
+
+func TestETags(t *testing.T) {
+	assert.Equal(t, ETagFromBytes([]byte("a")), ETagFromBytes([]byte("a")))
+	assert.NotEqual(t, ETagFromBytes([]byte("a")), ETagFromBytes([]byte("b")))
+	assert.True(t, strings.HasPrefix(ETagFromBytes(nil), `"`))
+
+	assert.Equal(t, `"17b8a23358908000-a"`, ETagFromFile(10, lastModified))
+
+	assert.Equal(t, `W/"v2"`, Weak(etag))
+	assert.Equal(t, `W/"v2"`, Weak(Weak(etag)))
+	assert.Equal(t, "", Weak(""))
+}
+
+func TestRespond(t *testing.T) {
+	// Test: 304 repeats validators and extra headers without a body
+	buf := &bytes.Buffer{}
+	extra := map[string]string{"vary": "accept-encoding"}
+	handled := Respond(response.NewWriter(buf), newRequest("GET", map[string]string{"If-None-Match": etag}), etag, lastModified, extra)
+	assert.True(t, handled)
+	resp := buf.String()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, resp, "etag: \"v2\"\r\n")
+	assert.Contains(t, resp, "last-modified: Fri, 01 Mar 2024 12:00:00 GMT\r\n")
+	assert.Contains(t, resp, "vary: accept-encoding\r\n")
+	assert.NotContains(t, resp, "content-length")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: 412
+	buf = &bytes.Buffer{}
+	handled = Respond(response.NewWriter(buf), newRequest("DELETE", map[string]string{"If-Match": `"old"`}), etag, lastModified, nil)
+	assert.True(t, handled)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 412 Precondition Failed\r\n"))
+
+	// Test: Nothing written when the request proceeds
+	buf = &bytes.Buffer{}
+	assert.False(t, Respond(response.NewWriter(buf), newRequest("GET", nil), etag, lastModified, nil))
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,211 @@
+// Package cookie parses the Cookie header of requests and formats the
+// Set-Cookie fields of responses as described in RFC 6265.
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+// Cookie is a name-value pair sent back by the client.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Parse parses the value of a Cookie header into its cookies, in the order
+// sent (RFC 6265 section 5.4). Malformed pairs are skipped instead of
+// failing the whole header, and a value in double quotes is unquoted.
+func Parse(value string) []Cookie {
+	var cookies []Cookie
+	for pair := range strings.SplitSeq(value, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		value = unquote(strings.TrimSpace(value))
+		if !isToken(name) || !isValidValue(value) {
+			continue
+		}
+
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// SameSite restricts when a cookie is sent along with cross-site requests.
+type SameSite string
+
+const (
+	SameSiteStrict SameSite = "Strict"
+	SameSiteLax    SameSite = "Lax"
+	SameSiteNone   SameSite = "None"
+)
+
+// SetCookie is a cookie for the client to store, with the attributes of RFC
+// 6265 section 4.1 and Partitioned. Zero attributes are left out.
+type SetCookie struct {
+	Name  string
+	Value string
+
+	Expires time.Time
+	// MaxAge is the lifetime in seconds. A negative MaxAge is sent as
+	// "Max-Age=0", which deletes the cookie right away.
+	MaxAge int
+	Domain string
+	Path   string
+
+	Secure   bool
+	HttpOnly bool
+	// SameSite None requires Secure.
+	SameSite SameSite
+	// Partitioned keys the cookie to the top-level site it was set on
+	// (CHIPS). It requires Secure.
+	Partitioned bool
+}
+
+// Format returns the value of the Set-Cookie field for c. It fails when a
+// name, value or attribute can't be sent as is, or when c lacks Secure but
+// needs it: for SameSite None, Partitioned and the "__Secure-" and "__Host-"
+// name prefixes.
+func (c *SetCookie) Format() (string, error) {
+	err := c.validate()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(headers.FormatTime(c.Expires))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=")
+		b.WriteString(c.Domain)
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(c.Path)
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != "" {
+		b.WriteString("; SameSite=")
+		b.WriteString(string(c.SameSite))
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+
+	return b.String(), nil
+}
+
+func (c *SetCookie) validate() error {
+	switch {
+	case c.Name == "" || !isToken(c.Name):
+		return fmt.Errorf("%w: %q", ErrorInvalidName, c.Name)
+	case !isValidValue(unquote(c.Value)):
+		return fmt.Errorf("%w: %q", ErrorInvalidValue, c.Value)
+	case !isValidAttribute(c.Domain):
+		return fmt.Errorf("%w: Domain %q", ErrorInvalidAttribute, c.Domain)
+	case !isValidAttribute(c.Path):
+		return fmt.Errorf("%w: Path %q", ErrorInvalidAttribute, c.Path)
+	}
+
+	switch c.SameSite {
+	case "", SameSiteStrict, SameSiteLax:
+	case SameSiteNone:
+		if !c.Secure {
+			return fmt.Errorf("%w: SameSite=None", ErrorNotSecure)
+		}
+	default:
+		return fmt.Errorf("%w: SameSite %q", ErrorInvalidAttribute, c.SameSite)
+	}
+
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("%w: Partitioned", ErrorNotSecure)
+	}
+
+	// cookie prefixes (RFC 6265bis section 4.1.3)
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		return fmt.Errorf("%w: %s", ErrorNotSecure, c.Name)
+	}
+	if strings.HasPrefix(c.Name, "__Host-") {
+		if !c.Secure {
+			return fmt.Errorf("%w: %s", ErrorNotSecure, c.Name)
+		}
+		if c.Domain != "" || c.Path != "/" {
+			return fmt.Errorf("%w: %s needs Path=/ and no Domain", ErrorInvalidAttribute, c.Name)
+		}
+	}
+	return nil
+}
+
+// unquote removes the double quotes a cookie value may be wrapped in.
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isValidValue reports whether value is made of cookie-octets: printable
+// ASCII except whitespace, double quotes, commas, semicolons and
+// backslashes.
+func isValidValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidAttribute reports whether value can be the value of Domain or
+// Path: any printable characters but semicolons.
+func isValidAttribute(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < ' ' || c >= 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
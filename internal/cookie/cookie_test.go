@@ -0,0 +1,87 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs come back in order, quotes removed
+	assert.Equal(t, []Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "empty", Value: ""},
+		{Name: "session", Value: "older"},
+	}, Parse(`session=abc123; theme="dark";empty=; session=older`))
+
+	// Test: Malformed pairs are skipped, the rest is kept
+	assert.Equal(t, []Cookie{
+		{Name: "ok", Value: "1"},
+		{Name: "also", Value: "2"},
+	}, Parse(`novalue; ok=1; bad name=x; =nameless; sp=a b; also=2`))
+
+	// Test: An empty header has no cookies
+	assert.Empty(t, Parse(""))
+}
+
+func TestSetCookieFormat(t *testing.T) {
+	// Test: Every attribute is written in order
+	c := &SetCookie{
+		Name:        "__Host-id",
+		Value:       "a3fWa",
+		Expires:     time.Date(2026, time.October, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Path:        "/",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	value, err := c.Format()
+	require.NoError(t, err)
+	assert.Equal(t, "__Host-id=a3fWa; Expires=Wed, 21 Oct 2026 07:28:00 GMT; Max-Age=3600; Path=/; Secure; HttpOnly; SameSite=None; Partitioned", value)
+
+	// Test: Zero attributes are left out
+	value, err = (&SetCookie{Name: "theme", Value: "light"}).Format()
+	require.NoError(t, err)
+	assert.Equal(t, "theme=light", value)
+
+	// Test: A negative Max-Age deletes the cookie
+	value, err = (&SetCookie{Name: "theme", Domain: "example.com", MaxAge: -1, SameSite: SameSiteLax}).Format()
+	require.NoError(t, err)
+	assert.Equal(t, "theme=; Max-Age=0; Domain=example.com; SameSite=Lax", value)
+
+	// Test: A quoted value is kept as is
+	value, err = (&SetCookie{Name: "q", Value: `"quoted"`}).Format()
+	require.NoError(t, err)
+	assert.Equal(t, `q="quoted"`, value)
+}
+
+func TestSetCookieInvalid(t *testing.T) {
+	tests := []struct {
+		cookie SetCookie
+		err    error
+	}{
+		{SetCookie{Name: "", Value: "x"}, ErrorInvalidName},
+		{SetCookie{Name: "a b", Value: "x"}, ErrorInvalidName},
+		{SetCookie{Name: "a", Value: "x;y"}, ErrorInvalidValue},
+		{SetCookie{Name: "a", Value: "x y"}, ErrorInvalidValue},
+		{SetCookie{Name: "a", Value: "x,y"}, ErrorInvalidValue},
+		{SetCookie{Name: "a", Path: "/;evil"}, ErrorInvalidAttribute},
+		{SetCookie{Name: "a", Domain: "example.com\r\nx: y"}, ErrorInvalidAttribute},
+		{SetCookie{Name: "a", SameSite: "Sometimes"}, ErrorInvalidAttribute},
+		{SetCookie{Name: "a", SameSite: SameSiteNone}, ErrorNotSecure},
+		{SetCookie{Name: "a", Partitioned: true}, ErrorNotSecure},
+		{SetCookie{Name: "__Secure-a"}, ErrorNotSecure},
+		{SetCookie{Name: "__Host-a", Secure: true}, ErrorInvalidAttribute},
+		{SetCookie{Name: "__Host-a", Secure: true, Path: "/", Domain: "example.com"}, ErrorInvalidAttribute},
+	}
+
+	for _, tt := range tests {
+		_, err := tt.cookie.Format()
+		assert.ErrorIs(t, err, tt.err, tt.cookie.Name)
+	}
+}
@@ -0,0 +1,10 @@
+package cookie
+
+import "errors"
+
+var (
+	ErrorInvalidName      = errors.New("invalid cookie name")
+	ErrorInvalidValue     = errors.New("invalid cookie value")
+	ErrorInvalidAttribute = errors.New("invalid cookie attribute")
+	ErrorNotSecure        = errors.New("cookie must be secure")
+)
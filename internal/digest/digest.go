@@ -0,0 +1,223 @@
+// Package digest implements the Content-Digest and Repr-Digest fields of
+// RFC 9530, hashing content incrementally so bodies never need to be held in
+// memory just to be digested.
+package digest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+)
+
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha-256"
+	SHA512 Algorithm = "sha-512"
+)
+
+// DefaultAlgorithm is used when the peer expressed no preference.
+const DefaultAlgorithm = SHA256
+
+// Supported lists the algorithms this package can compute, strongest first.
+var Supported = []Algorithm{SHA512, SHA256}
+
+func (a Algorithm) newHash() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New()
+	case SHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+func (a Algorithm) supported() bool {
+	return slices.Contains(Supported, a)
+}
+
+// Digester hashes everything written to it with one or more algorithms. Use
+// it as an io.Writer next to the body, e.g. with io.MultiWriter.
+type Digester struct {
+	algorithms []Algorithm
+	hashes     []hash.Hash
+}
+
+// New returns a Digester for algs, or for DefaultAlgorithm if none are given.
+// Unsupported algorithms are ignored.
+func New(algs ...Algorithm) *Digester {
+	if len(algs) == 0 {
+		algs = []Algorithm{DefaultAlgorithm}
+	}
+
+	d := &Digester{}
+	for _, alg := range algs {
+		if !alg.supported() || slices.Contains(d.algorithms, alg) {
+			continue
+		}
+		d.algorithms = append(d.algorithms, alg)
+		d.hashes = append(d.hashes, alg.newHash())
+	}
+
+	return d
+}
+
+func (d *Digester) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		// hash.Hash never returns an error
+		_, _ = h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Value returns the field value for everything written so far, e.g.
+// "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:".
+func (d *Digester) Value() string {
+	values := make([]string, 0, len(d.hashes))
+	for i, h := range d.hashes {
+		sum := base64.StdEncoding.EncodeToString(h.Sum(nil))
+		values = append(values, fmt.Sprintf("%s=:%s:", d.algorithms[i], sum))
+	}
+	return strings.Join(values, ", ")
+}
+
+// Sum digests a buffered body in one go.
+func Sum(body []byte, algs ...Algorithm) string {
+	d := New(algs...)
+	_, _ = d.Write(body)
+	return d.Value()
+}
+
+// Parse parses a Content-Digest or Repr-Digest value into raw digests by
+// algorithm. Unknown algorithms are kept so callers can tell "unsupported"
+// apart from "absent".
+func Parse(value string) (map[Algorithm][]byte, error) {
+	digests := map[Algorithm][]byte{}
+
+	for _, member := range headers.SplitList(value) {
+		key, item, ok := strings.Cut(member, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrorMalformedField, member)
+		}
+
+		// parameters carry no meaning for digests
+		item, _, _ = strings.Cut(item, ";")
+		item = strings.TrimSpace(item)
+		if len(item) < 2 || item[0] != ':' || item[len(item)-1] != ':' {
+			return nil, fmt.Errorf("%w: %q", ErrorMalformedField, member)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(item[1 : len(item)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrorMalformedField, member)
+		}
+
+		digests[Algorithm(strings.ToLower(strings.TrimSpace(key)))] = raw
+	}
+
+	return digests, nil
+}
+
+// Verify checks body against a Content-Digest or Repr-Digest value. Every
+// supported algorithm present must match; if none is supported the result is
+// ErrorUnsupportedAlgorithm.
+func Verify(value string, body []byte) error {
+	digests, err := Parse(value)
+	if err != nil {
+		return err
+	}
+
+	verified := false
+	for alg, want := range digests {
+		if !alg.supported() {
+			continue
+		}
+
+		h := alg.newHash()
+		_, _ = h.Write(body)
+		if !bytes.Equal(h.Sum(nil), want) {
+			return fmt.Errorf("%w: %s", ErrorDigestMismatch, alg)
+		}
+		verified = true
+	}
+
+	if !verified {
+		return ErrorUnsupportedAlgorithm
+	}
+	return nil
+}
+
+// VerifyRequest checks the Content-Digest and Repr-Digest fields of req, if
+// present, against its body. A request without either field is valid.
+func VerifyRequest(req *request.Request) error {
+	for _, field := range []string{headers.ContentDigestHeader, headers.ReprDigestHeader} {
+		value, ok := req.Headers.Get(field)
+		if !ok {
+			continue
+		}
+
+		err := Verify(value, req.Body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// Negotiate picks algorithms from a Want-Content-Digest or Want-Repr-Digest
+// value, most preferred first. Algorithms with a weight of zero or that are
+// not supported are left out. An empty value yields DefaultAlgorithm.
+func Negotiate(want string) []Algorithm {
+	if strings.TrimSpace(want) == "" {
+		return []Algorithm{DefaultAlgorithm}
+	}
+
+	type preference struct {
+		alg    Algorithm
+		weight int
+	}
+
+	var prefs []preference
+	for _, member := range headers.SplitList(want) {
+		key, weightStr, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		alg := Algorithm(strings.ToLower(strings.TrimSpace(key)))
+		weight, err := strconv.Atoi(strings.TrimSpace(weightStr))
+		if err != nil || weight <= 0 || weight > 10 || !alg.supported() {
+			continue
+		}
+		prefs = append(prefs, preference{alg: alg, weight: weight})
+	}
+
+	slices.SortStableFunc(prefs, func(a, b preference) int {
+		return b.weight - a.weight
+	})
+
+	algs := make([]Algorithm, 0, len(prefs))
+	for _, p := range prefs {
+		algs = append(algs, p.alg)
+	}
+	return algs
+}
+
+// Want returns a Want-Content-Digest value advertising the supported
+// algorithms, e.g. to accompany a rejected request.
+func Want() string {
+	values := make([]string, 0, len(Supported))
+	for i, alg := range Supported {
+		values = append(values, fmt.Sprintf("%s=%d", alg, 10-i))
+	}
+	return strings.Join(values, ", ")
+}
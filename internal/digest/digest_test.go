@@ -0,0 +1,84 @@
+package digest
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// body and digests from RFC 9530 appendix D
+const (
+	body         = `{"hello": "world"}`
+	sha256Digest = "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"
+	sha512Digest = "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"
+)
+
+func TestDigester(t *testing.T) {
+	// Test: Single algorithm, streamed in pieces
+	d := New(SHA256)
+	_, err := io.Copy(d, iotest.OneByteReader(strings.NewReader(body)))
+	require.NoError(t, err)
+	assert.Equal(t, sha256Digest, d.Value())
+
+	// Test: Several algorithms at once, unsupported ones ignored
+	assert.Equal(t, sha512Digest+", "+sha256Digest, Sum([]byte(body), SHA512, "md5", SHA256))
+
+	// Test: Default algorithm
+	assert.Equal(t, sha256Digest, Sum([]byte(body)))
+}
+
+func TestVerify(t *testing.T) {
+	require.NoError(t, Verify(sha256Digest, []byte(body)))
+	require.NoError(t, Verify(sha512Digest+", "+sha256Digest+";x=1", []byte(body)))
+	require.NoError(t, Verify("md5=:AAAA:, "+sha256Digest, []byte(body)))
+
+	require.ErrorIs(t, Verify(sha256Digest, []byte("tampered")), ErrorDigestMismatch)
+	require.ErrorIs(t, Verify("md5=:AAAA:", []byte(body)), ErrorUnsupportedAlgorithm)
+	require.ErrorIs(t, Verify("sha-256=X48E", []byte(body)), ErrorMalformedField)
+
+	// Test: Requests without digest fields pass
+	req := &request.Request{Headers: headers.NewHeaders(), Body: []byte(body)}
+	require.NoError(t, VerifyRequest(req))
+
+	req.Headers.Set(headers.ReprDigestHeader, sha512Digest)
+	require.NoError(t, VerifyRequest(req))
+
+	req.Headers.Set(headers.ContentDigestHeader, Sum([]byte("other")))
+	require.ErrorIs(t, VerifyRequest(req), ErrorDigestMismatch)
+}
+
+func TestNegotiate(t *testing.T) {
+	assert.Equal(t, []Algorithm{SHA256}, Negotiate(""))
+	assert.Equal(t, []Algorithm{SHA512, SHA256}, Negotiate("sha-256=3, sha-512=10"))
+	assert.Equal(t, []Algorithm{SHA256}, Negotiate("sha-256=1, sha-512=0, md5=10"))
+	assert.Empty(t, Negotiate("sha-512=0"))
+	assert.Equal(t, "sha-512=10, sha-256=9", Want())
+}
+
+func TestRequireValid(t *testing.T) {
+	ok := func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(0))
+	}
+
+	req := &request.Request{Headers: headers.NewHeaders(), Body: []byte(body)}
+	req.Headers.Set(headers.ContentDigestHeader, sha256Digest)
+
+	buf := &bytes.Buffer{}
+	RequireValid(ok)(response.NewWriter(buf), req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+
+	req.Body = []byte("tampered")
+	buf = &bytes.Buffer{}
+	RequireValid(ok)(response.NewWriter(buf), req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, buf.String(), "want-content-digest: sha-512=10, sha-256=9\r\n")
+}
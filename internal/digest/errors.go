@@ -0,0 +1,9 @@
+package digest
+
+import "errors"
+
+var (
+	ErrorMalformedField       = errors.New("malformed digest field")
+	ErrorUnsupportedAlgorithm = errors.New("no supported digest algorithm")
+	ErrorDigestMismatch       = errors.New("digest does not match content")
+)
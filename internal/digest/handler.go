@@ -0,0 +1,42 @@
+package digest
+
+import (
+	"log"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+)
+
+// RequireValid wraps next so that requests whose Content-Digest or
+// Repr-Digest doesn't match their body are rejected with 400. The rejection
+// carries Want-Content-Digest so the client learns which algorithms we take.
+func RequireValid(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		verifyErr := VerifyRequest(req)
+		if verifyErr == nil {
+			next(w, req)
+			return
+		}
+
+		err := w.WriteStatusLine(response.StatusCodeBadRequest)
+		if err != nil {
+			log.Printf("Failed to write status line: %v", err)
+		}
+
+		body := []byte(verifyErr.Error())
+
+		h := response.GetDefaultHeaders(len(body))
+		h.Set(headers.WantContentDigestHeader, Want())
+		err = w.WriteHeaders(h)
+		if err != nil {
+			log.Printf("Failed to write headers: %v", err)
+		}
+
+		_, err = w.WriteBody(body)
+		if err != nil {
+			log.Printf("Failed to write body: %v", err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package errorpage renders error responses as HTML, plain text or RFC 9457
+// problem details, whichever the client prefers.
+package errorpage
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+)
+
+const (
+	MediaTypeText    = "text/plain"
+	MediaTypeHTML    = "text/html"
+	MediaTypeProblem = "application/problem+json"
+)
+
+// Page describes the error being rendered.
+type Page struct {
+	StatusCode response.StatusCode
+	// Title is the reason phrase of StatusCode.
+	Title string
+	// Detail explains what went wrong, or is empty.
+	Detail string
+
+	// Method, Path and RequestID are empty when the request could not be
+	// parsed.
+	Method    string
+	Path      string
+	RequestID string
+}
+
+// HTMLFunc renders p as an HTML document.
+type HTMLFunc func(p Page) ([]byte, error)
+
+// Renderer returns a server.ErrorRenderer that answers in the media type the
+// client's Accept ranks highest. Plain text is sent when the client has no
+// preference, and HTML is only offered if html is not nil.
+func Renderer(html HTMLFunc) server.ErrorRenderer {
+	offered := []string{MediaTypeText, MediaTypeProblem}
+	if html != nil {
+		offered = []string{MediaTypeText, MediaTypeHTML, MediaTypeProblem}
+	}
+
+	return func(req *request.Request, statusCode response.StatusCode, detail string, h headers.Headers) []byte {
+		page := Page{
+			StatusCode: statusCode,
+			Title:      statusCode.ReasonPhrase(),
+			Detail:     detail,
+		}
+
+		accept := ""
+		if req != nil {
+			accept, _ = req.Headers.Get(headers.AcceptHeader)
+			page.Method = req.RequestLine.Method
+			page.Path = req.Path()
+			page.RequestID = req.ID
+		}
+
+		h.Set(headers.VaryHeader, "Accept")
+
+		switch Negotiate(accept, offered...) {
+		case MediaTypeHTML:
+			body, err := html(page)
+			if err == nil {
+				h.Override(headers.ContentTypeHeader, MediaTypeHTML+"; charset=utf-8")
+				return body
+			}
+			log.Printf("Failed to render %d page: %v", statusCode, err)
+		case MediaTypeProblem:
+			body, err := Problem(page)
+			if err == nil {
+				h.Override(headers.ContentTypeHeader, MediaTypeProblem)
+				return body
+			}
+			log.Printf("Failed to render %d problem: %v", statusCode, err)
+		}
+
+		h.Override(headers.ContentTypeHeader, MediaTypeText+"; charset=utf-8")
+		return Text(page)
+	}
+}
+
+// Text renders p as a line of plain text.
+func Text(p Page) []byte {
+	if p.Detail != "" {
+		return []byte(p.Detail)
+	}
+	return []byte(strings.ToLower(p.Title))
+}
+
+// problem is the application/problem+json document of RFC 9457.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Problem renders p as problem details. The type is about:blank since the
+// status code says all there is to say about the kind of problem.
+func Problem(p Page) ([]byte, error) {
+	return json.Marshal(problem{
+		Type:      "about:blank",
+		Title:     p.Title,
+		Status:    int(p.StatusCode),
+		Detail:    p.Detail,
+		Instance:  p.Path,
+		RequestID: p.RequestID,
+	})
+}
+
+// Negotiate picks the media type from offered, in order of our preference,
+// that Accept ranks highest, or the first one if the client accepts none of
+// them: an error is better sent in a format the client didn't ask for than not
+// at all. application/json stands in for application/problem+json.
+func Negotiate(accept string, offered ...string) string {
+	if len(offered) == 0 {
+		return ""
+	}
+
+	values := headers.ParseQualityValues(accept)
+	if len(values) == 0 {
+		return offered[0]
+	}
+
+	best, bestQ := offered[0], 0.0
+	for _, mediaType := range offered {
+		q := quality(values, mediaType)
+		if q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+	return best
+}
+
+// quality returns the q of the most specific range in values that matches
+// mediaType, or zero.
+func quality(values []headers.QualityValue, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := 0.0, 0
+	for _, qv := range values {
+		var s int
+		switch {
+		case qv.Value == mediaType:
+			s = 3
+		case qv.Value == "application/json" && mediaType == MediaTypeProblem:
+			s = 2
+		case qv.Value == typ+"/*":
+			s = 1
+		case qv.Value == "*/*":
+			s = 0
+		default:
+			continue
+		}
+
+		if s >= specificity {
+			q, specificity = qv.Q, s
+		}
+	}
+	return q
+}
@@ -0,0 +1,101 @@
+package errorpage
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	offered := []string{MediaTypeText, MediaTypeHTML, MediaTypeProblem}
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"", MediaTypeText},
+		{"*/*", MediaTypeText},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", MediaTypeHTML},
+		{"application/problem+json", MediaTypeProblem},
+		{"application/json", MediaTypeProblem},
+		{"text/*;q=0.5, application/json", MediaTypeProblem},
+		{"text/*", MediaTypeText},
+		{"text/plain;q=0, text/*", MediaTypeHTML},
+		{"image/png", MediaTypeText},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, Negotiate(c.accept, offered...), c.accept)
+	}
+}
+
+func render(html HTMLFunc, accept string, req *request.Request) (headers.Headers, string) {
+	if req != nil && accept != "" {
+		req.Headers.Set(headers.AcceptHeader, accept)
+	}
+
+	h := headers.NewHeaders()
+	body := Renderer(html)(req, response.StatusCodeNotFound, "", h)
+	return h, string(body)
+}
+
+func newRequest() *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/missing?x=1"},
+		Headers:     headers.NewHeaders(),
+		ID:          "abc",
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	html := func(p Page) ([]byte, error) {
+		return []byte("<h1>" + p.Title + " " + p.Path + "</h1>"), nil
+	}
+
+	// Test: Plain text by default
+	h, body := render(html, "", newRequest())
+	assert.Equal(t, "text/plain; charset=utf-8", h[headers.ContentTypeHeader])
+	assert.Equal(t, "Accept", h[headers.VaryHeader])
+	assert.Equal(t, "not found", body)
+
+	// Test: HTML for browsers
+	h, body = render(html, "text/html", newRequest())
+	assert.Equal(t, "text/html; charset=utf-8", h[headers.ContentTypeHeader])
+	assert.Equal(t, "<h1>Not Found /missing</h1>", body)
+
+	// Test: Problem details
+	h, body = render(html, "application/problem+json", newRequest())
+	assert.Equal(t, MediaTypeProblem, h[headers.ContentTypeHeader])
+	var p map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &p))
+	assert.Equal(t, map[string]any{
+		"type":       "about:blank",
+		"title":      "Not Found",
+		"status":     float64(404),
+		"instance":   "/missing",
+		"request_id": "abc",
+	}, p)
+
+	// Test: No HTML offered without a renderer for it
+	h, _ = render(nil, "text/html", newRequest())
+	assert.Equal(t, "text/plain; charset=utf-8", h[headers.ContentTypeHeader])
+
+	// Test: Failing HTML falls back to text
+	broken := func(Page) ([]byte, error) {
+		return nil, errors.New("boom")
+	}
+	h, body = render(broken, "text/html", newRequest())
+	assert.Equal(t, "text/plain; charset=utf-8", h[headers.ContentTypeHeader])
+	assert.Equal(t, "not found", body)
+
+	// Test: Unparsed requests get plain text with the detail
+	h = headers.NewHeaders()
+	body = string(Renderer(html)(nil, response.StatusCodeBadRequest, "request line malformed", h))
+	assert.Equal(t, "text/plain; charset=utf-8", h[headers.ContentTypeHeader])
+	assert.Equal(t, "request line malformed", body)
+}
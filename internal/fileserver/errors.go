@@ -0,0 +1,8 @@
+package fileserver
+
+import "errors"
+
+var (
+	ErrorMalformedRange     = errors.New("malformed range")
+	ErrorUnsatisfiableRange = errors.New("range not satisfiable")
+)
@@ -0,0 +1,351 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// FileServer serves the files of a file system tree.
+//
+// Request paths are cleaned and resolved inside the root, so "..", encoded
+// or not, can never escape it. Directories are served through their index
+// file, or listed if that is enabled. When the client accepts gzip and a
+// "<name>.gz" sibling exists, it is sent instead with Content-Encoding: gzip.
+type FileServer struct {
+	fsys          fs.FS
+	stripPrefix   string
+	indexFiles    []string
+	listDirectory bool
+}
+
+// Option configures a FileServer in New.
+type Option func(*FileServer)
+
+// WithStripPrefix removes prefix from request paths before they are looked up,
+// for file servers mounted below "/".
+func WithStripPrefix(prefix string) Option {
+	return func(fsrv *FileServer) {
+		fsrv.stripPrefix = prefix
+	}
+}
+
+// WithIndexFiles replaces the files tried, in order, for directory requests.
+// The default is index.html.
+func WithIndexFiles(names ...string) Option {
+	return func(fsrv *FileServer) {
+		fsrv.indexFiles = names
+	}
+}
+
+// WithDirectoryListing makes directories without an index file show an HTML
+// listing of their entries instead of 404.
+func WithDirectoryListing() Option {
+	return func(fsrv *FileServer) {
+		fsrv.listDirectory = true
+	}
+}
+
+func New(fsys fs.FS, opts ...Option) *FileServer {
+	fsrv := &FileServer{
+		fsys:       fsys,
+		indexFiles: []string{"index.html"},
+	}
+	for _, opt := range opts {
+		opt(fsrv)
+	}
+	return fsrv
+}
+
+// Dir returns a FileServer rooted at the directory dir of the local disk.
+// Unlike os.DirFS, the os.Root it opens doesn't follow symbolic links that
+// lead out of dir; files behind them fail to open.
+func Dir(dir string, opts ...Option) (*FileServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return New(root.FS(), opts...), nil
+}
+
+// Serve has the server.Handler signature.
+func (fsrv *FileServer) Serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if method != request.MethodGet && method != request.MethodHead {
+		extra := headers.NewHeaders()
+		extra.Set(headers.AllowHeader, "GET, HEAD")
+		writeError(w, response.StatusCodeMethodNotAllowed, extra)
+		return
+	}
+
+	urlPath, err := fsrv.urlPath(req.Path())
+	if err != nil {
+		writeError(w, response.StatusCodeNotFound, nil)
+		return
+	}
+
+	name := fsName(urlPath)
+	info, err := fs.Stat(fsrv.fsys, name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+
+	if !info.IsDir() {
+		fsrv.serveFile(w, req, name, info)
+		return
+	}
+
+	// relative links in an index or listing only work from "dir/"
+	if !strings.HasSuffix(urlPath, "/") {
+		redirect(w, req, path.Base(urlPath)+"/")
+		return
+	}
+
+	for _, index := range fsrv.indexFiles {
+		indexName := path.Join(name, index)
+		indexInfo, err := fs.Stat(fsrv.fsys, indexName)
+		if err == nil && indexInfo.Mode().IsRegular() {
+			fsrv.serveFile(w, req, indexName, indexInfo)
+			return
+		}
+	}
+
+	if !fsrv.listDirectory {
+		writeError(w, response.StatusCodeNotFound, nil)
+		return
+	}
+	fsrv.serveListing(w, urlPath, name)
+}
+
+// ServeFileFS replies to req with the contents of the named file in fsys.
+func ServeFileFS(w *response.Writer, req *request.Request, fsys fs.FS, name string) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	if info.IsDir() {
+		writeError(w, response.StatusCodeNotFound, nil)
+		return
+	}
+
+	New(fsys).serveFile(w, req, name, info)
+}
+
+// urlPath extracts the decoded, cleaned path of rawPath below the strip
+// prefix. The result always starts with "/" and keeps a trailing slash.
+func (fsrv *FileServer) urlPath(rawPath string) (string, error) {
+	p, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(p, "\x00\\") {
+		return "", fs.ErrInvalid
+	}
+
+	if fsrv.stripPrefix != "" {
+		stripped, ok := strings.CutPrefix(p, fsrv.stripPrefix)
+		if !ok {
+			return "", fs.ErrNotExist
+		}
+		p = stripped
+	}
+
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// fsName turns a cleaned URL path into an fs.FS name.
+func fsName(urlPath string) string {
+	name := strings.Trim(urlPath, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (fsrv *FileServer) serveFile(w *response.Writer, req *request.Request, name string, info fs.FileInfo) {
+	ctype := ""
+	extra := headers.NewHeaders()
+
+	gzName := name + ".gz"
+	gzInfo, err := fs.Stat(fsrv.fsys, gzName)
+	if err == nil && gzInfo.Mode().IsRegular() {
+		// the response now depends on Accept-Encoding, whichever file we pick
+		extra.Set(headers.VaryHeader, headers.AcceptEncodingHeader)
+
+		if acceptsEncoding(req, "gzip") {
+			original, err := fsrv.open(name)
+			if err != nil {
+				writeOpenError(w, err)
+				return
+			}
+			ctype, err = contentType(name, original)
+			closeFile(original)
+			if err != nil {
+				log.Printf("Failed to detect content type: %v", err)
+				writeError(w, response.StatusCodeInternalServerError, nil)
+				return
+			}
+
+			extra.Set(headers.ContentEncodingHeader, "gzip")
+			name, info = gzName, gzInfo
+		}
+	}
+
+	f, err := fsrv.open(name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer closeFile(f)
+
+	if ctype == "" {
+		ctype, err = contentType(name, f)
+		if err != nil {
+			log.Printf("Failed to detect content type: %v", err)
+			writeError(w, response.StatusCodeInternalServerError, nil)
+			return
+		}
+	}
+
+	serveContent(w, req, ctype, info.ModTime(), f, extra)
+}
+
+type seekableFile interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// open opens name for reading, buffering files of file systems that can't
+// seek so that ranges still work.
+func (fsrv *FileServer) open(name string) (seekableFile, error) {
+	f, err := fsrv.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if sf, ok := f.(seekableFile); ok {
+		return sf, nil
+	}
+
+	defer closeFile(f)
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func closeFile(f io.Closer) {
+	err := f.Close()
+	if err != nil {
+		log.Printf("Failed to close file: %v", err)
+	}
+}
+
+func (fsrv *FileServer) serveListing(w *response.Writer, urlPath, name string) {
+	entries, err := fs.ReadDir(fsrv.fsys, name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	var body bytes.Buffer
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(&body, "<html>\n  <head>\n    <title>Index of %s</title>\n  </head>\n  <body>\n", title)
+	fmt.Fprintf(&body, "    <h1>Index of %s</h1>\n    <ul>\n", title)
+	if urlPath != "/" {
+		fmt.Fprintf(&body, "      <li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		href := (&url.URL{Path: entryName}).EscapedPath()
+		if strings.Contains(entryName, ":") {
+			// keep "a:b" from being read as a scheme
+			href = "./" + href
+		}
+		fmt.Fprintf(&body, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(entryName))
+	}
+	fmt.Fprintf(&body, "    </ul>\n  </body>\n</html>\n")
+
+	err = w.WriteStatusLine(response.StatusCodeOK)
+	if err != nil {
+		log.Printf("Failed to write status line: %v", err)
+	}
+
+	h := response.GetDefaultHeaders(body.Len())
+	h.Override(headers.ContentTypeHeader, "text/html; charset=utf-8")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Failed to write headers: %v", err)
+	}
+
+	_, err = w.WriteBody(body.Bytes())
+	if err != nil {
+		log.Printf("Failed to write body: %v", err)
+	}
+}
+
+// redirect sends a 301 to location, which is relative to the request path.
+func redirect(w *response.Writer, req *request.Request, location string) {
+	location = (&url.URL{Path: location}).String()
+	if query := req.RawQuery(); query != "" {
+		location += "?" + query
+	}
+
+	extra := headers.NewHeaders()
+	extra.Set(headers.LocationHeader, location)
+	writeError(w, response.StatusCodeMovedPermanently, extra)
+}
+
+// acceptsEncoding reports whether Accept-Encoding allows coding.
+func acceptsEncoding(req *request.Request, coding string) bool {
+	value, ok := req.Headers.Get(headers.AcceptEncodingHeader)
+	if !ok {
+		return false
+	}
+
+	accepted := false
+	for _, qv := range headers.ParseQualityValues(value) {
+		switch qv.Value {
+		case coding:
+			// an explicit entry beats the wildcard
+			return qv.Q > 0
+		case "*":
+			accepted = qv.Q > 0
+		}
+	}
+	return accepted
+}
@@ -0,0 +1,166 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFS = fstest.MapFS{
+	"index.html":          {Data: []byte("<html>home</html>"), ModTime: modTime},
+	"style.css":           {Data: []byte("body {}"), ModTime: modTime},
+	"style.css.gz":        {Data: []byte("gzipped css"), ModTime: modTime},
+	"notes":               {Data: []byte("just some text"), ModTime: modTime},
+	"blob":                {Data: []byte{0x00, 0x01, 0x02}, ModTime: modTime},
+	"docs/a <b>.txt":      {Data: []byte("a"), ModTime: modTime},
+	"docs/sub/c.txt":      {Data: []byte("c"), ModTime: modTime},
+	"private/secret.txt":  {Data: []byte("s"), ModTime: time.Time{}},
+	"private/index.shtml": {Data: []byte("i"), ModTime: time.Time{}},
+}
+
+func get(fsrv *FileServer, method, target string, h map[string]string) string {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target},
+		Headers:     headers.NewHeaders(),
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+
+	buf := &bytes.Buffer{}
+	fsrv.Serve(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func TestFileServer(t *testing.T) {
+	fsrv := New(testFS, WithDirectoryListing())
+
+	// Test: Regular file with its type from the extension
+	resp := get(fsrv, "GET", "/style.css", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "content-type: text/css; charset=utf-8\r\n")
+	assert.Contains(t, resp, "vary: accept-encoding\r\n")
+	assert.NotContains(t, resp, "content-encoding")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nbody {}"))
+
+	// Test: Precompressed sibling for clients that take gzip
+	resp = get(fsrv, "GET", "/style.css", map[string]string{"Accept-Encoding": "br, gzip;q=0.5"})
+	assert.Contains(t, resp, "content-encoding: gzip\r\n")
+	assert.Contains(t, resp, "content-type: text/css; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\ngzipped css"))
+
+	// Test: But not for those that refuse it
+	resp = get(fsrv, "GET", "/style.css", map[string]string{"Accept-Encoding": "*, gzip;q=0"})
+	assert.NotContains(t, resp, "content-encoding")
+
+	// Test: Sniffed types for files without an extension
+	assert.Contains(t, get(fsrv, "GET", "/notes", nil), "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, get(fsrv, "GET", "/blob", nil), "content-type: application/octet-stream\r\n")
+
+	// Test: Index file for the root
+	resp = get(fsrv, "GET", "/", nil)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n<html>home</html>"))
+
+	// Test: Directories without a trailing slash redirect
+	resp = get(fsrv, "GET", "/docs?x=1", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, resp, "location: docs/?x=1\r\n")
+
+	// Test: Listing escapes names
+	resp = get(fsrv, "GET", "/docs/", nil)
+	assert.Contains(t, resp, "content-type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, resp, `<a href="a%20%3Cb%3E.txt">a &lt;b&gt;.txt</a>`)
+	assert.Contains(t, resp, `<a href="sub/">sub/</a>`)
+	assert.Contains(t, resp, `<a href="../">../</a>`)
+
+	// Test: Listing is opt-in
+	resp = get(New(testFS), "GET", "/docs/", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Custom index files
+	resp = get(New(testFS, WithIndexFiles("index.shtml")), "GET", "/private/", nil)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\ni"))
+
+	// Test: Missing files and other methods
+	assert.True(t, strings.HasPrefix(get(fsrv, "GET", "/nope.txt", nil), "HTTP/1.1 404 Not Found\r\n"))
+	resp = get(fsrv, "POST", "/style.css", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, resp, "allow: GET, HEAD\r\n")
+}
+
+func TestFileServerTraversal(t *testing.T) {
+	fsrv := New(testFS, WithStripPrefix("/static"))
+
+	// Test: Prefix is stripped
+	resp := get(fsrv, "GET", "/static/docs/sub/c.txt", nil)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nc"))
+
+	// Test: Dot segments are resolved inside the root
+	resp = get(fsrv, "GET", "/static/../../docs/sub/../sub/c.txt", nil)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nc"))
+	resp = get(fsrv, "GET", "/static/%2e%2e/%2e%2e/etc/passwd", nil)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Encoded separators and NUL bytes are refused
+	for _, target := range []string{"/static/docs%5csub%5cc.txt", "/static/notes%00.txt", "/static/%zz", "/other/notes"} {
+		resp = get(fsrv, "GET", target, nil)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"), target)
+	}
+}
+
+func TestDirSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "public.txt"), []byte("public"), 0o644))
+	require.NoError(t, os.Symlink("public.txt", filepath.Join(root, "inside.txt")))
+	require.NoError(t, os.Symlink("../secret.txt", filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Symlink("..", filepath.Join(root, "parent")))
+
+	fsrv, err := Dir(root)
+	require.NoError(t, err)
+
+	// Test: A symlink within the root is followed
+	resp := get(fsrv, "GET", "/inside.txt", nil)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\npublic"))
+
+	// Test: One leading out of it isn't, whether to a file or a directory
+	for _, target := range []string{"/escape.txt", "/parent/secret.txt", "/parent/"} {
+		resp = get(fsrv, "GET", target, nil)
+		assert.False(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), target)
+		assert.NotContains(t, resp, "secret", target)
+	}
+
+	// Test: The root must exist
+	_, err = Dir(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestSniffContentType(t *testing.T) {
+	cases := map[string]string{
+		"\x89PNG\x0D\x0A\x1A\x0Arest":           "image/png",
+		"\x00\x00\x00\x18ftypmp42":              "video/mp4",
+		"%PDF-1.7":                              "application/pdf",
+		"  <!DOCTYPE html><html></html>":        "text/html; charset=utf-8",
+		"<?xml version=\"1.0\"?>":               "text/xml; charset=utf-8",
+		"plain old text\n":                      "text/plain; charset=utf-8",
+		"h\xc3\xa9llo w\xc3\xb6rld \xe2\x82":    "text/plain; charset=utf-8",
+		"\x00\x01binary":                        "application/octet-stream",
+		"\xff\xfe\xfd invalid utf-8 everywhere": "application/octet-stream",
+	}
+
+	for data, want := range cases {
+		assert.Equal(t, want, sniffContentType([]byte(data)), "%q", data)
+	}
+}
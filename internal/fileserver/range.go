@@ -0,0 +1,137 @@
+package fileserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+// maxRanges caps how many ranges a single request may ask for before the
+// Range header is ignored altogether.
+const maxRanges = 64
+
+// byteRange is a resolved range of a representation of known size.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+func unsatisfiedContentRange(size int64) string {
+	return fmt.Sprintf("bytes */%d", size)
+}
+
+// parseRange resolves a Range header value against a representation of size
+// bytes. Specs that start past the end are dropped; if nothing is left the
+// result is ErrorUnsatisfiableRange. Syntax errors and units other than bytes
+// give ErrorMalformedRange, which callers should treat as no Range at all.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, ErrorMalformedRange
+	}
+
+	specs := headers.SplitList(set)
+	if len(specs) == 0 || len(specs) > maxRanges {
+		return nil, ErrorMalformedRange
+	}
+
+	var ranges []byteRange
+	for _, spec := range specs {
+		firstStr, lastStr, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ErrorMalformedRange
+		}
+		firstStr = strings.TrimSpace(firstStr)
+		lastStr = strings.TrimSpace(lastStr)
+
+		if firstStr == "" {
+			// suffix-range: the last N bytes
+			suffix, err := parseRangeInt(lastStr)
+			if err != nil {
+				return nil, err
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			suffix = min(suffix, size)
+			ranges = append(ranges, byteRange{start: size - suffix, length: suffix})
+			continue
+		}
+
+		first, err := parseRangeInt(firstStr)
+		if err != nil {
+			return nil, err
+		}
+
+		last := size - 1
+		if lastStr != "" {
+			last, err = parseRangeInt(lastStr)
+			if err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, ErrorMalformedRange
+			}
+			last = min(last, size-1)
+		}
+
+		if first >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: first, length: last - first + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, ErrorUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" || strings.ContainsAny(s, "+-") {
+		return 0, ErrorMalformedRange
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrorMalformedRange
+	}
+	return n, nil
+}
+
+func sumRanges(ranges []byteRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	return total
+}
+
+// ifRangeMatches evaluates If-Range (RFC 9110 section 13.1.5): the Range is
+// honoured only if the validator still identifies the current representation.
+// Entity tags must match strongly and dates exactly.
+func ifRangeMatches(value, etag string, modTime time.Time) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return true
+	}
+
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && value == etag
+	}
+
+	if modTime.IsZero() {
+		return false
+	}
+	t, err := headers.ParseTime(value)
+	if err != nil {
+		return false
+	}
+	return t.Equal(modTime.Truncate(time.Second))
+}
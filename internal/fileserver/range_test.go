@@ -0,0 +1,59 @@
+package fileserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		value string
+		want  []byteRange
+		err   error
+	}{
+		{"bytes=0-499", []byteRange{{0, 500}}, nil},
+		{"bytes=500-", []byteRange{{500, 500}}, nil},
+		{"bytes=-200", []byteRange{{800, 200}}, nil},
+		{"bytes=-2000", []byteRange{{0, 1000}}, nil},
+		{"bytes=900-5000", []byteRange{{900, 100}}, nil},
+		{"bytes=0-0, -1", []byteRange{{0, 1}, {999, 1}}, nil},
+		{"bytes= 0-9 ,20-29", []byteRange{{0, 10}, {20, 10}}, nil},
+		{"bytes=1000-, 0-9", []byteRange{{0, 10}}, nil},
+		{"bytes=1000-", nil, ErrorUnsatisfiableRange},
+		{"bytes=-0", nil, ErrorUnsatisfiableRange},
+		{"bytes=5-1", nil, ErrorMalformedRange},
+		{"bytes=a-b", nil, ErrorMalformedRange},
+		{"bytes=--5", nil, ErrorMalformedRange},
+		{"bytes=", nil, ErrorMalformedRange},
+		{"items=0-5", nil, ErrorMalformedRange},
+		{"0-5", nil, ErrorMalformedRange},
+	}
+
+	for _, c := range cases {
+		ranges, err := parseRange(c.value, 1000)
+		if c.err != nil {
+			require.ErrorIs(t, err, c.err, c.value)
+			continue
+		}
+		require.NoError(t, err, c.value)
+		assert.Equal(t, c.want, ranges, c.value)
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modTime := time.Date(2024, time.March, 1, 12, 0, 0, 500, time.UTC)
+
+	assert.True(t, ifRangeMatches("", "", modTime))
+	assert.True(t, ifRangeMatches("Fri, 01 Mar 2024 12:00:00 GMT", "", modTime))
+	assert.False(t, ifRangeMatches("Fri, 01 Mar 2024 11:59:59 GMT", "", modTime))
+	assert.False(t, ifRangeMatches("Fri, 01 Mar 2024 12:00:00 GMT", "", time.Time{}))
+	assert.False(t, ifRangeMatches("not a date", "", modTime))
+
+	assert.True(t, ifRangeMatches(`"v1"`, `"v1"`, modTime))
+	assert.False(t, ifRangeMatches(`"v1"`, `"v2"`, modTime))
+	assert.False(t, ifRangeMatches(`W/"v1"`, `W/"v1"`, modTime))
+	assert.False(t, ifRangeMatches(`"v1"`, "", modTime))
+}
@@ -0,0 +1,301 @@
+// Package fileserver serves files and other seekable content, with support
+// for byte-range requests.
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/conditional"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+const defaultContentType = "application/octet-stream"
+
+// ServeFile replies to req with the contents of the file at path.
+func ServeFile(w *response.Writer, req *request.Request, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			log.Printf("Failed to close file: %v", err)
+		}
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	if info.IsDir() {
+		writeError(w, response.StatusCodeNotFound, nil)
+		return
+	}
+
+	ServeContent(w, req, info.Name(), info.ModTime(), f)
+}
+
+// ServeContent replies to req with content, honouring Range and If-Range so
+// clients can resume downloads and seek in media. name picks the Content-Type
+// by extension, the first bytes of content are sniffed if that fails.
+//
+// modTime provides Last-Modified and, with the size, a strong ETag; both are
+// used to answer conditional requests with 304 or 412. A zero modTime omits
+// the validators.
+//
+// A single satisfiable range is answered with 206 and Content-Range, several
+// with a multipart/byteranges body, and ranges that are all past the end with
+// 416. Malformed ranges are ignored and the full content is sent.
+func ServeContent(w *response.Writer, req *request.Request, name string, modTime time.Time, content io.ReadSeeker) {
+	ctype, err := contentType(name, content)
+	if err != nil {
+		log.Printf("Failed to detect content type: %v", err)
+		writeError(w, response.StatusCodeInternalServerError, nil)
+		return
+	}
+
+	serveContent(w, req, ctype, modTime, content, nil)
+}
+
+// serveContent is ServeContent with the Content-Type already known and extra
+// headers, which may be nil, added to successful responses.
+func serveContent(w *response.Writer, req *request.Request, contentType string, modTime time.Time, content io.ReadSeeker, extra headers.Headers) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		log.Printf("Failed to determine content size: %v", err)
+		writeError(w, response.StatusCodeInternalServerError, nil)
+		return
+	}
+
+	etag := ""
+	if !modTime.IsZero() {
+		etag = conditional.ETagFromFile(size, modTime)
+	}
+
+	if conditional.Respond(w, req, etag, modTime, extra) {
+		return
+	}
+
+	h := response.GetDefaultHeaders(int(size))
+	h.Override(headers.ContentTypeHeader, contentType)
+	h.Override(headers.AcceptRangesHeader, "bytes")
+	conditional.SetValidators(h, etag, modTime)
+	for k, v := range extra {
+		h.Override(k, v)
+	}
+
+	ranges, err := requestedRanges(req, size, etag, modTime)
+	if errors.Is(err, ErrorUnsatisfiableRange) {
+		extra := headers.NewHeaders()
+		extra.Set(headers.AcceptRangesHeader, "bytes")
+		extra.Set(headers.ContentRangeHeader, unsatisfiedContentRange(size))
+		writeError(w, response.StatusCodeRangeNotSatisfiable, extra)
+		return
+	}
+
+	switch len(ranges) {
+	case 0:
+		serveFull(w, h, content, size)
+	case 1:
+		serveRange(w, h, content, size, ranges[0])
+	default:
+		serveMultipart(w, h, content, size, contentType, ranges)
+	}
+}
+
+// requestedRanges returns the ranges to serve, or none for a full response.
+func requestedRanges(req *request.Request, size int64, etag string, modTime time.Time) ([]byteRange, error) {
+	method := req.RequestLine.Method
+	if method != request.MethodGet && method != request.MethodHead {
+		return nil, nil
+	}
+
+	value, ok := req.Headers.Get(headers.RangeHeader)
+	if !ok {
+		return nil, nil
+	}
+
+	ifRange, _ := req.Headers.Get(headers.IfRangeHeader)
+	if !ifRangeMatches(ifRange, etag, modTime) {
+		return nil, nil
+	}
+
+	ranges, err := parseRange(value, size)
+	if errors.Is(err, ErrorMalformedRange) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// asking for more than the whole thing is either a broken client or an
+	// attempt to make us do a lot of work, send it once instead
+	if sumRanges(ranges) > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
+
+func serveFull(w *response.Writer, h headers.Headers, content io.ReadSeeker, size int64) {
+	err := w.WriteStatusLine(response.StatusCodeOK)
+	if err != nil {
+		log.Printf("Failed to write status line: %v", err)
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Failed to write headers: %v", err)
+	}
+
+	err = copySection(w, content, 0, size)
+	if err != nil {
+		log.Printf("Failed to write body: %v", err)
+	}
+}
+
+func serveRange(w *response.Writer, h headers.Headers, content io.ReadSeeker, size int64, r byteRange) {
+	err := w.WriteStatusLine(response.StatusCodePartialContent)
+	if err != nil {
+		log.Printf("Failed to write status line: %v", err)
+	}
+
+	h.Override(headers.ContentLengthHeader, strconv.FormatInt(r.length, 10))
+	h.Override(headers.ContentRangeHeader, r.contentRange(size))
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Failed to write headers: %v", err)
+	}
+
+	err = copySection(w, content, r.start, r.length)
+	if err != nil {
+		log.Printf("Failed to write body: %v", err)
+	}
+}
+
+func serveMultipart(w *response.Writer, h headers.Headers, content io.ReadSeeker, size int64, contentType string, ranges []byteRange) {
+	boundary := randomBoundary()
+
+	contentLength, err := multipartLength(boundary, contentType, size, ranges)
+	if err != nil {
+		log.Printf("Failed to compute multipart length: %v", err)
+		writeError(w, response.StatusCodeInternalServerError, nil)
+		return
+	}
+
+	err = w.WriteStatusLine(response.StatusCodePartialContent)
+	if err != nil {
+		log.Printf("Failed to write status line: %v", err)
+	}
+
+	h.Override(headers.ContentLengthHeader, strconv.FormatInt(contentLength, 10))
+	h.Override(headers.ContentTypeHeader, "multipart/byteranges; boundary="+boundary)
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Failed to write headers: %v", err)
+	}
+
+	err = writeMultipart(w, boundary, contentType, size, ranges, func(part io.Writer, r byteRange) error {
+		return copySection(part, content, r.start, r.length)
+	})
+	if err != nil {
+		log.Printf("Failed to write body: %v", err)
+	}
+}
+
+// writeMultipart writes a multipart/byteranges body, calling writePart to
+// fill in the bytes of every range.
+func writeMultipart(dst io.Writer, boundary, contentType string, size int64, ranges []byteRange, writePart func(io.Writer, byteRange) error) error {
+	mw := multipart.NewWriter(dst)
+	err := mw.SetBoundary(boundary)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {r.contentRange(size)},
+		})
+		if err != nil {
+			return err
+		}
+
+		err = writePart(part, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+// multipartLength computes the exact Content-Length of a multipart/byteranges
+// body without reading any content.
+func multipartLength(boundary, contentType string, size int64, ranges []byteRange) (int64, error) {
+	counter := &countingWriter{}
+	err := writeMultipart(counter, boundary, contentType, size, ranges, func(_ io.Writer, r byteRange) error {
+		counter.n += r.length
+		return nil
+	})
+	return counter.n, err
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func copySection(dst io.Writer, content io.ReadSeeker, start, length int64) error {
+	_, err := content.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(dst, content, length)
+	return err
+}
+
+func randomBoundary() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+func writeOpenError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, response.StatusCodeNotFound, nil)
+	case errors.Is(err, fs.ErrPermission):
+		writeError(w, response.StatusCodeForbidden, nil)
+	default:
+		log.Printf("Failed to open file: %v", err)
+		writeError(w, response.StatusCodeInternalServerError, nil)
+	}
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, extra headers.Headers) {
+	err := w.WriteError(statusCode, extra)
+	if err != nil {
+		log.Printf("Failed to write %d response: %v", statusCode, err)
+	}
+}
@@ -0,0 +1,108 @@
+package fileserver
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+const benchFileSize = 16 << 20
+
+// benchConn returns the server side of a loopback TCP connection whose client
+// side is drained in the background, like a browser downloading a video.
+func benchConn(b *testing.B) net.Conn {
+	b.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = ln.Close() })
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = client.Close() })
+	go func() {
+		_, _ = io.Copy(io.Discard, client)
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func benchFile(b *testing.B) string {
+	b.Helper()
+
+	path := filepath.Join(b.TempDir(), "video.mp4")
+	err := os.WriteFile(path, make([]byte, benchFileSize), 0o644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// readFileHandler is how HandlerVideo used to serve the video: read it all,
+// then write it out in one go.
+func readFileHandler(w *response.Writer, path string) {
+	err := w.WriteStatusLine(response.StatusCodeOK)
+	if err != nil {
+		log.Printf("Failed to write status line: %v", err)
+	}
+
+	f, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read file: %v", err)
+	}
+
+	h := response.GetDefaultHeaders(len(f))
+	h.Override(headers.ContentTypeHeader, "video/mp4")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Failed to write headers: %v", err)
+	}
+
+	_, err = w.WriteBody(f)
+	if err != nil {
+		log.Printf("Failed to write body: %v", err)
+	}
+}
+
+func BenchmarkServeVideo(b *testing.B) {
+	path := benchFile(b)
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: request.MethodGet, RequestTarget: "/video"},
+		Headers:     headers.NewHeaders(),
+	}
+
+	b.Run("ReadFile", func(b *testing.B) {
+		conn := benchConn(b)
+		b.SetBytes(benchFileSize)
+		b.ReportAllocs()
+		for b.Loop() {
+			readFileHandler(response.NewWriter(conn), path)
+		}
+	})
+
+	b.Run("ServeFile", func(b *testing.B) {
+		conn := benchConn(b)
+		b.SetBytes(benchFileSize)
+		b.ReportAllocs()
+		for b.Loop() {
+			ServeFile(response.NewWriter(conn), req, path)
+		}
+	})
+}
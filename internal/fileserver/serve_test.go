@@ -0,0 +1,167 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+var modTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func serve(t *testing.T, method string, h map[string]string) (head, body string) {
+	t.Helper()
+
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: "/clip.txt"},
+		Headers:     headers.NewHeaders(),
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	if method == request.MethodHead {
+		w.DiscardBody()
+	}
+	ServeContent(w, req, "clip.txt", modTime, strings.NewReader(content))
+
+	head, body, ok := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, ok)
+	return head + "\r\n", body
+}
+
+func TestServeContent(t *testing.T) {
+	// Test: Full content advertises range support
+	head, body := serve(t, request.MethodGet, nil)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head, "accept-ranges: bytes\r\n")
+	assert.Contains(t, head, "content-length: 36\r\n")
+	assert.Contains(t, head, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, head, "last-modified: Fri, 01 Mar 2024 12:00:00 GMT\r\n")
+	assert.Equal(t, content, body)
+
+	// Test: Single range
+	head, body = serve(t, request.MethodGet, map[string]string{"Range": "bytes=10-15"})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, head, "content-range: bytes 10-15/36\r\n")
+	assert.Contains(t, head, "content-length: 6\r\n")
+	assert.Equal(t, "abcdef", body)
+
+	// Test: HEAD with a range reports the range without sending it
+	head, body = serve(t, request.MethodHead, map[string]string{"Range": "bytes=-4"})
+	assert.Contains(t, head, "content-range: bytes 32-35/36\r\n")
+	assert.Empty(t, body)
+
+	// Test: Unsatisfiable range
+	head, _ = serve(t, request.MethodGet, map[string]string{"Range": "bytes=100-"})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, head, "content-range: bytes */36\r\n")
+
+	// Test: Malformed ranges are ignored
+	head, body = serve(t, request.MethodGet, map[string]string{"Range": "bytes=9-1"})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, content, body)
+
+	// Test: Stale If-Range sends everything
+	head, body = serve(t, request.MethodGet, map[string]string{
+		"Range":    "bytes=0-1",
+		"If-Range": "Thu, 29 Feb 2024 12:00:00 GMT",
+	})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, content, body)
+
+	// Test: Fresh If-Range keeps the range
+	head, body = serve(t, request.MethodGet, map[string]string{
+		"Range":    "bytes=0-1",
+		"If-Range": "Fri, 01 Mar 2024 12:00:00 GMT",
+	})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Equal(t, "01", body)
+
+	// Test: Ranges are only for GET
+	head, _ = serve(t, request.MethodPost, map[string]string{"Range": "bytes=0-1"})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestServeContentMultipart(t *testing.T) {
+	head, body := serve(t, request.MethodGet, map[string]string{"Range": "bytes=0-3, 30-"})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, head, "content-length: "+strconv.Itoa(len(body))+"\r\n")
+
+	contentType := headerValue(head, "content-type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+
+	wants := []struct{ contentRange, data string }{
+		{"bytes 0-3/36", "0123"},
+		{"bytes 30-35/36", "uvwxyz"},
+	}
+	for _, want := range wants {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, want.data, string(data))
+	}
+	_, err = mr.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Overlapping ranges adding up to more than the content are ignored
+	head, body = serve(t, request.MethodGet, map[string]string{"Range": "bytes=0-, 0-, 0-"})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, content, body)
+}
+
+func headerValue(head, key string) string {
+	for line := range strings.SplitSeq(head, "\r\n") {
+		k, v, ok := strings.Cut(line, ": ")
+		if ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestServeContentConditional(t *testing.T) {
+	head, _ := serve(t, request.MethodGet, nil)
+	etag := headerValue(head, "etag")
+	assert.NotEmpty(t, etag)
+
+	// Test: Revalidation by entity tag
+	head, body := serve(t, request.MethodGet, map[string]string{"If-None-Match": etag})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, head, "etag: "+etag+"\r\n")
+	assert.Empty(t, body)
+
+	// Test: Revalidation by date
+	head, _ = serve(t, request.MethodGet, map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 12:00:00 GMT"})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Failed precondition
+	head, _ = serve(t, request.MethodGet, map[string]string{"If-Match": `"something-else"`})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 412 Precondition Failed\r\n"))
+
+	// Test: If-Range by entity tag
+	head, body = serve(t, request.MethodGet, map[string]string{"Range": "bytes=0-1", "If-Range": etag})
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Equal(t, "01", body)
+}
@@ -0,0 +1,107 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"path"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of the content is inspected to guess its type.
+const sniffLen = 512
+
+type signature struct {
+	offset      int
+	magic       []byte
+	contentType string
+}
+
+// signatures covers the formats this server is likely to host. Anything else
+// is either text or application/octet-stream.
+var signatures = []signature{
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("\x89PNG\x0D\x0A\x1A\x0A"), "image/png"},
+	{0, []byte("\xFF\xD8\xFF"), "image/jpeg"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{8, []byte("WEBP"), "image/webp"},
+	{4, []byte("ftyp"), "video/mp4"},
+	{0, []byte("\x1A\x45\xDF\xA3"), "video/webm"},
+	{0, []byte("OggS"), "application/ogg"},
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("PK\x03\x04"), "application/zip"},
+	{0, []byte("\x1F\x8B\x08"), "application/x-gzip"},
+	{0, []byte("wOFF"), "font/woff"},
+	{0, []byte("wOF2"), "font/woff2"},
+}
+
+// markupPrefixes identify text formats that deserve more than text/plain.
+var markupPrefixes = []signature{
+	{0, []byte("<!doctype html"), "text/html; charset=utf-8"},
+	{0, []byte("<html"), "text/html; charset=utf-8"},
+	{0, []byte("<head"), "text/html; charset=utf-8"},
+	{0, []byte("<body"), "text/html; charset=utf-8"},
+	{0, []byte("<?xml"), "text/xml; charset=utf-8"},
+	{0, []byte("<svg"), "image/svg+xml"},
+}
+
+// sniffContentType guesses the media type of data from its first bytes.
+func sniffContentType(data []byte) string {
+	data = data[:min(len(data), sniffLen)]
+
+	for _, sig := range signatures {
+		if len(data) >= sig.offset+len(sig.magic) && bytes.Equal(data[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.contentType
+		}
+	}
+
+	if !looksLikeText(data) {
+		return defaultContentType
+	}
+
+	trimmed := bytes.ToLower(bytes.TrimLeft(data, " \t\r\n\ufeff"))
+	for _, sig := range markupPrefixes {
+		if bytes.HasPrefix(trimmed, sig.magic) {
+			return sig.contentType
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// looksLikeText reports whether data is UTF-8 without control characters
+// other than whitespace. A rune cut off at the end of the sample is allowed.
+func looksLikeText(data []byte) bool {
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size <= 1 {
+			return len(data)-i < utf8.UTFMax && !utf8.FullRune(data[i:])
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+// contentType picks a Content-Type for content called name: by extension if
+// it has a known one, by sniffing otherwise. content is rewound afterwards.
+func contentType(name string, content io.ReadSeeker) (string, error) {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t, nil
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return sniffContentType(buf[:n]), nil
+}
@@ -4,22 +4,100 @@ package headers
 import (
 	"bytes"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/itsjoeoui/httpfromtcp/internal/common"
 )
 
 const (
-	ContentLengthHeader    = "content-length"
-	ContentTypeHeader      = "content-type"
-	ConnectionHeader       = "connection"
-	TransferEncodingHeader = "transfer-encoding"
-	TrailerHeader          = "trailer"
-	XContentLengthHeader   = "x-content-length"
-	XContentSHA256         = "x-content-sha256"
+	AcceptHeader                 = "accept"
+	AcceptEncodingHeader         = "accept-encoding"
+	AcceptRangesHeader           = "accept-ranges"
+	AllowHeader                  = "allow"
+	AuthorizationHeader          = "authorization"
+	CacheControlHeader           = "cache-control"
+	ContentLengthHeader          = "content-length"
+	ContentDigestHeader          = "content-digest"
+	ContentEncodingHeader        = "content-encoding"
+	ContentRangeHeader           = "content-range"
+	ContentTypeHeader            = "content-type"
+	ConnectionHeader             = "connection"
+	CookieHeader                 = "cookie"
+	DateHeader                   = "date"
+	ETagHeader                   = "etag"
+	ForwardedHeader              = "forwarded"
+	HostHeader                   = "host"
+	HTTP2SettingsHeader          = "http2-settings"
+	IfMatchHeader                = "if-match"
+	IfModifiedSinceHeader        = "if-modified-since"
+	IfNoneMatchHeader            = "if-none-match"
+	IfRangeHeader                = "if-range"
+	IfUnmodifiedSinceHeader      = "if-unmodified-since"
+	KeepAliveHeader              = "keep-alive"
+	LastEventIDHeader            = "last-event-id"
+	LastModifiedHeader           = "last-modified"
+	LocationHeader               = "location"
+	ProxyAuthenticateHeader      = "proxy-authenticate"
+	ProxyAuthorizationHeader     = "proxy-authorization"
+	ProxyConnectionHeader        = "proxy-connection"
+	RangeHeader                  = "range"
+	ReprDigestHeader             = "repr-digest"
+	RetryAfterHeader             = "retry-after"
+	SecWebSocketAcceptHeader     = "sec-websocket-accept"
+	SecWebSocketExtensionsHeader = "sec-websocket-extensions"
+	SecWebSocketKeyHeader        = "sec-websocket-key"
+	SecWebSocketVersionHeader    = "sec-websocket-version"
+	ServerHeader                 = "server"
+	SetCookieHeader              = "set-cookie"
+	TEHeader                     = "te"
+	TransferEncodingHeader       = "transfer-encoding"
+	TrailerHeader                = "trailer"
+	UpgradeHeader                = "upgrade"
+	VaryHeader                   = "vary"
+	ViaHeader                    = "via"
+	WantContentDigestHeader      = "want-content-digest"
+	WantReprDigestHeader         = "want-repr-digest"
+	XContentLengthHeader         = "x-content-length"
+	XForwardedForHeader          = "x-forwarded-for"
+	XRequestIDHeader             = "x-request-id"
 )
 
+// TimeFormat is the IMF-fixdate layout used by Date and other HTTP date
+// fields (RFC 9110 section 5.6.7).
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// FormatTime formats t as an IMF-fixdate.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// obsoleteTimeFormats are the RFC 850 and asctime layouts that recipients
+// must still accept (RFC 9110 section 5.6.7).
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ParseTime parses an HTTP date in any of the three allowed formats.
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(TimeFormat, value)
+	if err == nil {
+		return t, nil
+	}
+
+	for _, layout := range obsoleteTimeFormats {
+		t, obsoleteErr := time.Parse(layout, value)
+		if obsoleteErr == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
 type Headers map[string]string
 
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
@@ -41,7 +119,7 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	}
 
 	fieldName := splitReq[0]
-	if unicode.IsSpace(rune(fieldName[len(fieldName)-1])) {
+	if len(fieldName) == 0 || unicode.IsSpace(rune(fieldName[len(fieldName)-1])) {
 		return 0, false, ErrorInvalidFieldNameFormat
 	}
 
@@ -57,6 +135,12 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	return crlfIdx + len(common.CRLF), false, nil
 }
 
+// IsToken reports whether s is a token (RFC 9110 section 5.6.2), the syntax
+// of field names and methods.
+func IsToken(s string) bool {
+	return s != "" && isValidToken([]byte(s))
+}
+
 var tokenChars = []byte{'!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~'}
 
 func isValidToken(data []byte) bool {
@@ -81,12 +165,26 @@ func (h Headers) Get(key string) (string, bool) {
 	return value, ok
 }
 
+// Set adds value to key, after any value it already has. Repeated fields are
+// combined into a list, or for Cookie into a single cookie-string (RFC 6265
+// section 5.4). Set-Cookie values can't be combined at all, so for Set-Cookie
+// Set replaces the value instead; more are added with
+// response.Writer.AddSetCookie.
 func (h Headers) Set(key, value string) {
 	key = strings.ToLower(key)
+	if key == SetCookieHeader {
+		h[key] = value
+		return
+	}
+
+	separator := ", "
+	if key == CookieHeader {
+		separator = "; "
+	}
 
 	existingValue, ok := h[key]
 	if ok {
-		h[key] = existingValue + ", " + value
+		h[key] = existingValue + separator + value
 	} else {
 		h[key] = value
 	}
@@ -101,3 +199,88 @@ func (h Headers) Remove(key string) {
 	key = strings.ToLower(key)
 	delete(h, key)
 }
+
+// SplitList splits a comma separated field value into its trimmed, non-empty
+// elements.
+func SplitList(value string) []string {
+	var elements []string
+	for element := range strings.SplitSeq(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// QualityValue is one element of a list with weights such as Accept-Encoding
+// or TE, e.g. "gzip;q=0.8".
+type QualityValue struct {
+	Value string
+	Q     float64
+}
+
+// ParseQualityValues parses a weighted list. Values are lowercased, a missing
+// or malformed weight counts as 1 and other parameters are dropped. Order is
+// preserved, callers decide how to break ties.
+func ParseQualityValues(value string) []QualityValue {
+	var values []QualityValue
+	for _, element := range SplitList(value) {
+		name, params, _ := strings.Cut(element, ";")
+		qv := QualityValue{Value: strings.ToLower(strings.TrimSpace(name)), Q: 1}
+
+		for param := range strings.SplitSeq(params, ";") {
+			key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err == nil && q >= 0 && q <= 1 {
+				qv.Q = q
+			}
+		}
+
+		values = append(values, qv)
+	}
+	return values
+}
+
+// forbiddenTrailers are fields that control message framing, routing,
+// authentication or caching, which a recipient must not find in a trailer
+// section (RFC 9110 section 6.5.1).
+var forbiddenTrailers = []string{
+	"age",
+	"authorization",
+	"cache-control",
+	"connection",
+	"content-encoding",
+	"content-length",
+	"content-range",
+	"content-type",
+	"cookie",
+	"date",
+	"expect",
+	"expires",
+	"host",
+	"keep-alive",
+	"location",
+	"max-forwards",
+	"pragma",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"proxy-connection",
+	"range",
+	"retry-after",
+	"set-cookie",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+	"vary",
+	"www-authenticate",
+}
+
+// IsForbiddenTrailer reports whether key may not be sent as a trailer field.
+func IsForbiddenTrailer(key string) bool {
+	return slices.Contains(forbiddenTrailers, strings.ToLower(key))
+}
@@ -57,6 +57,14 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// Test: Empty field name
+	headers = NewHeaders()
+	data = []byte(": localhost:42069\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrorInvalidFieldNameFormat)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
 	// Test: Invalid character header
 	headers = NewHeaders()
 	data = []byte("H©st: localhost:42069\r\n\r\n")
@@ -74,4 +82,36 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, "localhost:8000, localhost:42069", headers["host"])
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
+
+	// Test: Repeated Cookie lines form a single cookie-string
+	headers = map[string]string{"cookie": "a=1"}
+	data = []byte("Cookie: b=2\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "a=1; b=2", headers["cookie"])
+
+	// Test: A repeated Set-Cookie replaces the previous one, since the values
+	// can't be combined
+	headers = NewHeaders()
+	headers.Set("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	headers.Set("Set-Cookie", "b=2")
+	assert.Equal(t, "b=2", headers["set-cookie"])
+}
+
+func TestParseQualityValues(t *testing.T) {
+	// Test: Weights, defaults and other parameters
+	values := ParseQualityValues("gzip;q=0.8, Deflate, br;level=5;q=0, *;q=0.1")
+	assert.Equal(t, []QualityValue{
+		{Value: "gzip", Q: 0.8},
+		{Value: "deflate", Q: 1},
+		{Value: "br", Q: 0},
+		{Value: "*", Q: 0.1},
+	}, values)
+
+	// Test: Malformed weights count as 1
+	values = ParseQualityValues("gzip;q=2, identity;q=abc")
+	assert.Equal(t, []QualityValue{{Value: "gzip", Q: 1}, {Value: "identity", Q: 1}}, values)
+
+	// Test: Empty
+	assert.Empty(t, ParseQualityValues(" , "))
 }
@@ -0,0 +1,812 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+)
+
+// serverConn is the server side of an HTTP/2 connection. A single goroutine
+// reads frames, handlers write their responses from their own goroutines.
+type serverConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	handler Handler
+	cfg     config
+
+	// owned by the reading goroutine
+	decoder     *hpackDecoder
+	sawSettings bool
+	// recvWindow is how much more DATA the client may send on the
+	// connection.
+	recvWindow int64
+	// blockStreamID is the stream whose header block continues in
+	// CONTINUATION frames, block what arrived of it so far.
+	blockStreamID  uint32
+	block          []byte
+	blockEndStream bool
+
+	// wmu serializes frames and guards the encoder, whose state depends on
+	// the order header blocks go out in.
+	wmu      sync.Mutex
+	encoder  *hpackEncoder
+	writeBuf []byte
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// streams are the open streams: ones still receiving their request or
+	// sending their response.
+	streams      map[uint32]*stream
+	lastStreamID uint32
+	// sendWindow is how much more DATA the server may send on the
+	// connection.
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  int
+	goingAway         bool
+	closed            bool
+
+	handlers sync.WaitGroup
+}
+
+func newServerConn(conn net.Conn, reader *bufio.Reader, handler Handler, cfg config) *serverConn {
+	sc := &serverConn{
+		conn:              conn,
+		reader:            reader,
+		handler:           handler,
+		cfg:               cfg,
+		decoder:           newHPACKDecoder(defaultHeaderTableSize, cfg.maxHeaderListSize),
+		recvWindow:        defaultInitialWindowSize,
+		encoder:           newHPACKEncoder(),
+		streams:           map[uint32]*stream{},
+		sendWindow:        defaultInitialWindowSize,
+		peerInitialWindow: defaultInitialWindowSize,
+		peerMaxFrameSize:  defaultMaxFrameSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+func (sc *serverConn) serve() error {
+	defer sc.shutdown()
+
+	// the server preface goes out first, even before the client's arrived
+	var settings []byte
+	settings = appendSetting(settings, settingMaxConcurrentStreams, uint32(sc.cfg.maxConcurrentStreams))
+	settings = appendSetting(settings, settingMaxHeaderListSize, uint32(sc.cfg.maxHeaderListSize))
+	err := sc.writeFrame(frameSettings, 0, 0, settings)
+	if err != nil {
+		return err
+	}
+
+	if sc.cfg.upgrade != nil {
+		err = sc.startUpgraded()
+		if err != nil {
+			return sc.fail(err)
+		}
+	}
+
+	preface := make([]byte, len(ClientPreface))
+	_, err = io.ReadFull(sc.reader, preface)
+	if err != nil {
+		return err
+	}
+	if string(preface) != ClientPreface {
+		return sc.fail(connError{CodeProtocolError, "invalid client preface"})
+	}
+
+	for {
+		sc.setIdleDeadline()
+
+		f, err := readFrame(sc.reader, defaultMaxFrameSize)
+		var netErr net.Error
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case errors.As(err, &netErr) && netErr.Timeout():
+			sc.goAway(CodeNoError, "idle")
+			return nil
+		case err != nil:
+			return sc.fail(err)
+		}
+
+		err = sc.processFrame(f)
+		var se streamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.streamID, se.code)
+			continue
+		}
+		if err != nil {
+			return sc.fail(err)
+		}
+	}
+}
+
+// fail ends the connection after err: with a GOAWAY for a protocol error,
+// as is for anything else.
+func (sc *serverConn) fail(err error) error {
+	var ce connError
+	if errors.As(err, &ce) {
+		sc.goAway(ce.code, ce.reason)
+	}
+	return err
+}
+
+// shutdown wakes up handlers waiting to send, closes the connection and waits
+// for them to return.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	err := sc.conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Failed to close connection: %v", err)
+	}
+	sc.handlers.Wait()
+}
+
+// setIdleDeadline gives a connection without open streams until the idle
+// timeout to start another one.
+func (sc *serverConn) setIdleDeadline() {
+	sc.mu.Lock()
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	var deadline time.Time
+	if idle && sc.cfg.idleTimeout > 0 {
+		deadline = time.Now().Add(sc.cfg.idleTimeout)
+	}
+	err := sc.conn.SetReadDeadline(deadline)
+	if err != nil {
+		log.Printf("Failed to set read deadline: %v", err)
+	}
+}
+
+func (sc *serverConn) processFrame(f *frame) error {
+	if !sc.sawSettings && (f.typ != frameSettings || f.has(flagAck)) {
+		return connError{CodeProtocolError, "client preface must start with SETTINGS"}
+	}
+	if sc.blockStreamID != 0 && (f.typ != frameContinuation || f.streamID != sc.blockStreamID) {
+		return connError{CodeProtocolError, "header block interrupted"}
+	}
+
+	switch f.typ {
+	case frameData:
+		return sc.processData(f)
+	case frameHeaders:
+		return sc.processHeaders(f)
+	case frameContinuation:
+		return sc.processContinuation(f)
+	case framePriority:
+		if f.streamID == 0 {
+			return connError{CodeProtocolError, "PRIORITY on stream 0"}
+		}
+		if len(f.payload) != 5 {
+			return streamError{f.streamID, CodeFrameSizeError, "PRIORITY of wrong length"}
+		}
+		// every stream is served as soon as it can be
+		return nil
+	case frameRSTStream:
+		return sc.processRSTStream(f)
+	case frameSettings:
+		return sc.processSettings(f)
+	case framePushPromise:
+		return connError{CodeProtocolError, "clients can't push"}
+	case framePing:
+		return sc.processPing(f)
+	case frameGoAway:
+		if f.streamID != 0 {
+			return connError{CodeProtocolError, "GOAWAY on a stream"}
+		}
+		sc.mu.Lock()
+		sc.goingAway = true
+		sc.mu.Unlock()
+		return nil
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	default:
+		// unknown frame types are ignored (RFC 9113 section 5.5)
+		return nil
+	}
+}
+
+func (sc *serverConn) processHeaders(f *frame) error {
+	if f.streamID == 0 || f.streamID%2 == 0 {
+		return connError{CodeProtocolError, "HEADERS on a stream the client can't open"}
+	}
+	block, err := f.content()
+	if err != nil {
+		return err
+	}
+
+	if !f.has(flagEndHeaders) {
+		sc.blockStreamID = f.streamID
+		sc.block = append(sc.block[:0], block...)
+		sc.blockEndStream = f.has(flagEndStream)
+		return nil
+	}
+	return sc.processHeaderBlock(f.streamID, block, f.has(flagEndStream))
+}
+
+func (sc *serverConn) processContinuation(f *frame) error {
+	if sc.blockStreamID == 0 {
+		return connError{CodeProtocolError, "CONTINUATION without HEADERS"}
+	}
+	if len(sc.block)+len(f.payload) > sc.cfg.maxHeaderListSize {
+		return connError{CodeEnhanceYourCalm, "header block too large"}
+	}
+
+	sc.block = append(sc.block, f.payload...)
+	if !f.has(flagEndHeaders) {
+		return nil
+	}
+
+	streamID := sc.blockStreamID
+	sc.blockStreamID = 0
+	return sc.processHeaderBlock(streamID, sc.block, sc.blockEndStream)
+}
+
+// processHeaderBlock handles a complete header block: the request of a new
+// stream, or the trailers of one receiving its body.
+func (sc *serverConn) processHeaderBlock(streamID uint32, block []byte, endStream bool) error {
+	// decoded even if the stream is refused, to keep the table in sync
+	fields, err := sc.decoder.decode(block)
+	if err != nil {
+		return connError{CodeCompressionError, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[streamID]
+	switch {
+	case st != nil:
+		sc.mu.Unlock()
+		return sc.processTrailers(st, fields, endStream)
+	case streamID <= sc.lastStreamID:
+		sc.mu.Unlock()
+		return connError{CodeStreamClosed, "HEADERS on a closed stream"}
+	}
+	sc.lastStreamID = streamID
+	goingAway := sc.goingAway
+	full := len(sc.streams) >= sc.cfg.maxConcurrentStreams
+	sc.mu.Unlock()
+
+	if goingAway {
+		return nil
+	}
+	if full {
+		return streamError{streamID, CodeRefusedStream, "too many concurrent streams"}
+	}
+
+	req, err := sc.newRequest(fields)
+	if err != nil {
+		return streamError{streamID, CodeProtocolError, err.Error()}
+	}
+
+	st = sc.openStream(streamID, req)
+	if err := sc.checkContentLength(st); err != nil {
+		st.discard = true
+		sc.dispatch(st, err)
+	}
+	if endStream {
+		return sc.endRequest(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processTrailers(st *stream, fields []headerField, endStream bool) error {
+	if st.remoteClosed() {
+		return streamError{st.id, CodeStreamClosed, "HEADERS after END_STREAM"}
+	}
+	if !endStream {
+		return streamError{st.id, CodeProtocolError, "trailers without END_STREAM"}
+	}
+
+	for _, f := range fields {
+		err := validateField(f)
+		if err != nil {
+			return streamError{st.id, CodeProtocolError, err.Error()}
+		}
+		if !st.discard {
+			st.req.Trailers.Set(f.name, f.value)
+		}
+	}
+	return sc.endRequest(st)
+}
+
+func (sc *serverConn) processData(f *frame) error {
+	if f.streamID == 0 {
+		return connError{CodeProtocolError, "DATA on stream 0"}
+	}
+
+	// flow control counts the whole payload, padding included
+	length := int64(len(f.payload))
+	sc.recvWindow -= length
+	if sc.recvWindow < 0 {
+		return connError{CodeFlowControlError, "DATA beyond the connection window"}
+	}
+	data, err := f.content()
+	if err != nil {
+		return err
+	}
+	// bodies are buffered whole, so whatever arrived can be replaced at once
+	err = sc.updateRecvWindow(0, length)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[f.streamID]
+	idle := f.streamID > sc.lastStreamID
+	sc.mu.Unlock()
+	switch {
+	case idle:
+		return connError{CodeProtocolError, "DATA on an idle stream"}
+	case st == nil || st.remoteClosed():
+		return streamError{f.streamID, CodeStreamClosed, "DATA after END_STREAM"}
+	}
+
+	st.recvWindow -= length
+	if st.recvWindow < 0 {
+		return streamError{st.id, CodeFlowControlError, "DATA beyond the stream window"}
+	}
+
+	if !st.discard {
+		st.req.Body = append(st.req.Body, data...)
+		if sc.cfg.maxBodySize > 0 && len(st.req.Body) > sc.cfg.maxBodySize {
+			st.discard = true
+			st.req.Body = nil
+			sc.dispatch(st, request.ErrorBodyTooLarge)
+		}
+	}
+
+	if f.has(flagEndStream) {
+		return sc.endRequest(st)
+	}
+	return sc.updateRecvWindow(st.id, length)
+}
+
+// endRequest marks the request of st complete and hands it to the handler,
+// unless it was refused already.
+func (sc *serverConn) endRequest(st *stream) error {
+	sc.mu.Lock()
+	st.remoteDone = true
+	sc.mu.Unlock()
+
+	if st.discard {
+		return nil
+	}
+
+	contentLength, ok := st.req.Headers.Get(headers.ContentLengthHeader)
+	if ok && contentLength != strconv.Itoa(len(st.req.Body)) {
+		return streamError{st.id, CodeProtocolError, "body length differs from content-length"}
+	}
+
+	sc.dispatch(st, nil)
+	return nil
+}
+
+// checkContentLength refuses a request whose announced body is over the
+// limit before any of it arrives.
+func (sc *serverConn) checkContentLength(st *stream) error {
+	contentLength, ok := st.req.Headers.Get(headers.ContentLengthHeader)
+	if !ok || sc.cfg.maxBodySize == 0 {
+		return nil
+	}
+
+	n, err := strconv.Atoi(contentLength)
+	if err == nil && n > sc.cfg.maxBodySize {
+		return request.ErrorBodyTooLarge
+	}
+	return nil
+}
+
+// dispatch runs the handler for st in its own goroutine.
+func (sc *serverConn) dispatch(st *stream, err error) {
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		defer st.finish()
+		sc.handler(st, st.req, err)
+	}()
+}
+
+func (sc *serverConn) processRSTStream(f *frame) error {
+	if f.streamID == 0 {
+		return connError{CodeProtocolError, "RST_STREAM on stream 0"}
+	}
+	if len(f.payload) != 4 {
+		return connError{CodeFrameSizeError, "RST_STREAM of wrong length"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.streamID > sc.lastStreamID {
+		return connError{CodeProtocolError, "RST_STREAM on an idle stream"}
+	}
+	if st, ok := sc.streams[f.streamID]; ok {
+		st.reset = true
+		delete(sc.streams, f.streamID)
+		sc.cond.Broadcast()
+	}
+	return nil
+}
+
+func (sc *serverConn) processSettings(f *frame) error {
+	if f.streamID != 0 {
+		return connError{CodeProtocolError, "SETTINGS on a stream"}
+	}
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return connError{CodeFrameSizeError, "SETTINGS acknowledgement with a payload"}
+		}
+		return nil
+	}
+
+	settings, err := parseSettings(f.payload)
+	if err != nil {
+		return err
+	}
+	err = sc.applySettings(settings)
+	if err != nil {
+		return err
+	}
+	sc.sawSettings = true
+	return sc.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+func (sc *serverConn) applySettings(settings []setting) error {
+	for _, s := range settings {
+		switch s.id {
+		case settingHeaderTableSize:
+			sc.wmu.Lock()
+			sc.encoder.setMaxTableSize(int(s.value))
+			sc.wmu.Unlock()
+		case settingEnablePush:
+			if s.value > 1 {
+				return connError{CodeProtocolError, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case settingInitialWindowSize:
+			if s.value > maxWindowSize {
+				return connError{CodeFlowControlError, "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+			}
+			err := sc.setPeerInitialWindow(int64(s.value))
+			if err != nil {
+				return err
+			}
+		case settingMaxFrameSize:
+			if s.value < defaultMaxFrameSize || s.value > maxMaxFrameSize {
+				return connError{CodeProtocolError, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			sc.mu.Lock()
+			sc.peerMaxFrameSize = int(s.value)
+			sc.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// setPeerInitialWindow applies a new initial window to every stream, as if
+// it had been in place from the start (RFC 9113 section 6.9.2).
+func (sc *serverConn) setPeerInitialWindow(size int64) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	delta := size - sc.peerInitialWindow
+	sc.peerInitialWindow = size
+	for _, st := range sc.streams {
+		st.sendWindow += delta
+		if st.sendWindow > maxWindowSize {
+			return connError{CodeFlowControlError, "stream window too large"}
+		}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processPing(f *frame) error {
+	if f.streamID != 0 {
+		return connError{CodeProtocolError, "PING on a stream"}
+	}
+	if len(f.payload) != 8 {
+		return connError{CodeFrameSizeError, "PING of wrong length"}
+	}
+	if f.has(flagAck) {
+		return nil
+	}
+	return sc.writeFrame(framePing, flagAck, 0, f.payload)
+}
+
+func (sc *serverConn) processWindowUpdate(f *frame) error {
+	if len(f.payload) != 4 {
+		return connError{CodeFrameSizeError, "WINDOW_UPDATE of wrong length"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.payload) & (1<<31 - 1))
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.streamID == 0 {
+		if increment == 0 {
+			return connError{CodeProtocolError, "WINDOW_UPDATE of zero"}
+		}
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return connError{CodeFlowControlError, "connection window too large"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	st, ok := sc.streams[f.streamID]
+	switch {
+	case f.streamID > sc.lastStreamID:
+		return connError{CodeProtocolError, "WINDOW_UPDATE on an idle stream"}
+	case !ok:
+		// the stream closed while the update was on its way
+		return nil
+	case increment == 0:
+		return streamError{f.streamID, CodeProtocolError, "WINDOW_UPDATE of zero"}
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return streamError{f.streamID, CodeFlowControlError, "stream window too large"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+// updateRecvWindow lets the client send n more bytes on the stream, or on the
+// connection for stream 0.
+func (sc *serverConn) updateRecvWindow(streamID uint32, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if streamID == 0 {
+		sc.recvWindow += n
+	} else if st := sc.stream(streamID); st != nil {
+		st.recvWindow += n
+	}
+	return sc.writeFrame(frameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+func (sc *serverConn) stream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[id]
+}
+
+// reserve waits until st may send DATA and takes up to n bytes off the
+// stream and connection windows.
+func (sc *serverConn) reserve(st *stream, n int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for {
+		switch {
+		case st.reset:
+			return 0, ErrorStreamReset
+		case sc.closed:
+			return 0, ErrorConnClosed
+		}
+
+		allowed := min(int64(n), st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrameSize))
+		if allowed > 0 {
+			st.sendWindow -= allowed
+			sc.sendWindow -= allowed
+			return int(allowed), nil
+		}
+		sc.cond.Wait()
+	}
+}
+
+// resetStream ends the stream with a RST_STREAM carrying code.
+func (sc *serverConn) resetStream(streamID uint32, code ErrorCode) {
+	sc.mu.Lock()
+	if st, ok := sc.streams[streamID]; ok {
+		st.reset = true
+		delete(sc.streams, streamID)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+
+	err := sc.writeFrame(frameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+	if err != nil {
+		log.Printf("Failed to reset stream %d: %v", streamID, err)
+	}
+}
+
+func (sc *serverConn) goAway(code ErrorCode, reason string) {
+	sc.mu.Lock()
+	lastStreamID := sc.lastStreamID
+	sc.mu.Unlock()
+
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, reason...)
+	err := sc.writeFrame(frameGoAway, 0, 0, payload)
+	if err != nil {
+		log.Printf("Failed to send GOAWAY: %v", err)
+	}
+}
+
+func (sc *serverConn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	sc.writeBuf = appendFrame(sc.writeBuf[:0], typ, flags, streamID, payload)
+	_, err := sc.conn.Write(sc.writeBuf)
+	return err
+}
+
+// writeHeaders encodes fields and sends them as a HEADERS frame followed by
+// as many CONTINUATION frames as needed, with nothing in between.
+func (sc *serverConn) writeHeaders(streamID uint32, fields []headerField, endStream bool) error {
+	sc.mu.Lock()
+	maxFrameSize := sc.peerMaxFrameSize
+	sc.mu.Unlock()
+
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	block := sc.encoder.encode(nil, fields)
+	buf := sc.writeBuf[:0]
+	typ := frameHeaders
+	var flags uint8
+	if endStream {
+		flags |= flagEndStream
+	}
+	for {
+		n := min(len(block), maxFrameSize)
+		if n == len(block) {
+			flags |= flagEndHeaders
+		}
+		buf = appendFrame(buf, typ, flags, streamID, block[:n])
+		block = block[n:]
+		if len(block) == 0 {
+			break
+		}
+		typ, flags = frameContinuation, 0
+	}
+	sc.writeBuf = buf
+
+	_, err := sc.conn.Write(buf)
+	return err
+}
+
+// newRequest builds the request of a stream from its header fields (RFC 9113
+// section 8.3.1).
+func (sc *serverConn) newRequest(fields []headerField) (*request.Request, error) {
+	req := &request.Request{
+		RequestLine: request.RequestLine{HTTPVersion: "2"},
+		Headers:     headers.NewHeaders(),
+		Body:        []byte{},
+		Trailers:    headers.NewHeaders(),
+		RemoteAddr:  sc.conn.RemoteAddr().String(),
+		ParserState: request.ParserStateDone,
+	}
+
+	pseudo := map[string]string{}
+	for _, f := range fields {
+		if strings.HasPrefix(f.name, ":") {
+			if len(req.HeaderOrder) > 0 {
+				return nil, errors.New("pseudo-header field after a regular one")
+			}
+			switch f.name {
+			case ":method", ":scheme", ":authority", ":path":
+			default:
+				return nil, errors.New("unknown pseudo-header field " + f.name)
+			}
+			if _, ok := pseudo[f.name]; ok {
+				return nil, errors.New("repeated pseudo-header field " + f.name)
+			}
+			if strings.ContainsAny(f.value, "\r\n\x00") {
+				return nil, errors.New("invalid value for " + f.name)
+			}
+			pseudo[f.name] = f.value
+			continue
+		}
+
+		err := validateField(f)
+		if err != nil {
+			return nil, err
+		}
+		addField(req, f)
+	}
+
+	method, scheme, authority, path := pseudo[":method"], pseudo[":scheme"], pseudo[":authority"], pseudo[":path"]
+	if !headers.IsToken(method) {
+		return nil, errors.New("invalid method " + strconv.Quote(method))
+	}
+	req.RequestLine.Method = method
+	if method == request.MethodConnect {
+		if authority == "" || scheme != "" || path != "" {
+			return nil, errors.New("malformed CONNECT request")
+		}
+		req.RequestLine.RequestTarget = authority
+	} else {
+		if scheme == "" || path == "" {
+			return nil, errors.New("missing pseudo-header fields")
+		}
+		if !validPath(method, path) {
+			return nil, errors.New("invalid path " + strconv.Quote(path))
+		}
+		req.RequestLine.RequestTarget = path
+	}
+
+	if authority != "" {
+		if _, ok := req.Headers.Get(headers.HostHeader); !ok {
+			req.HeaderOrder = append([]string{headers.HostHeader}, req.HeaderOrder...)
+		}
+		req.Headers.Override(headers.HostHeader, authority)
+	}
+	return req, nil
+}
+
+// validPath reports whether path is an origin-form target, or "*" for
+// OPTIONS (RFC 9113 section 8.3.1). It becomes the request target as is, so
+// it may not contain whitespace either.
+func validPath(method, path string) bool {
+	if path == "*" {
+		return method == request.MethodOptions
+	}
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] <= ' ' || path[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// addField adds a regular field to req. Cookie may come split into several
+// fields, which Set joins the way HTTP/1.1 sends them (RFC 9113 section
+// 8.2.3).
+func addField(req *request.Request, f headerField) {
+	if _, ok := req.Headers.Get(f.name); !ok {
+		req.HeaderOrder = append(req.HeaderOrder, f.name)
+	}
+	req.Headers.Set(f.name, f.value)
+}
+
+// connectionFields are only meaningful to HTTP/1.1 connections and make an
+// HTTP/2 message malformed.
+var connectionFields = []string{
+	headers.ConnectionHeader,
+	headers.KeepAliveHeader,
+	headers.ProxyConnectionHeader,
+	headers.TransferEncodingHeader,
+	headers.UpgradeHeader,
+}
+
+// validateField checks a regular field of a request (RFC 9113 section
+// 8.2). Names must be lowercase tokens: anything else could end up in the
+// HTTP/1.1 message the request is relayed as.
+func validateField(f headerField) error {
+	switch {
+	case !headers.IsToken(f.name) || strings.ToLower(f.name) != f.name:
+		return errors.New("invalid field name " + strconv.Quote(f.name))
+	case strings.ContainsAny(f.value, "\r\n\x00"):
+		return errors.New("invalid value for " + f.name)
+	case f.name == headers.TEHeader && f.value != "trailers":
+		return errors.New("te other than trailers")
+	}
+	if slices.Contains(connectionFields, f.name) {
+		return errors.New("connection-specific field " + f.name)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorBadSettings     = errors.New("malformed HTTP2-Settings")
+	ErrorCompression     = errors.New("malformed header block")
+	ErrorStreamReset     = errors.New("stream reset")
+	ErrorConnClosed      = errors.New("connection closed")
+	ErrorResponseEnded   = errors.New("response already ended")
+	ErrorUnsupportedCode = errors.New("status code not supported over HTTP/2")
+)
+
+// ErrorCode is the reason given in RST_STREAM and GOAWAY frames (RFC 9113
+// section 7).
+type ErrorCode uint32
+
+const (
+	CodeNoError            ErrorCode = 0x0
+	CodeProtocolError      ErrorCode = 0x1
+	CodeInternalError      ErrorCode = 0x2
+	CodeFlowControlError   ErrorCode = 0x3
+	CodeSettingsTimeout    ErrorCode = 0x4
+	CodeStreamClosed       ErrorCode = 0x5
+	CodeFrameSizeError     ErrorCode = 0x6
+	CodeRefusedStream      ErrorCode = 0x7
+	CodeCancel             ErrorCode = 0x8
+	CodeCompressionError   ErrorCode = 0x9
+	CodeConnectError       ErrorCode = 0xa
+	CodeEnhanceYourCalm    ErrorCode = 0xb
+	CodeInadequateSecurity ErrorCode = 0xc
+	CodeHTTP11Required     ErrorCode = 0xd
+)
+
+// connError ends the whole connection with a GOAWAY.
+type connError struct {
+	code   ErrorCode
+	reason string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("http2 connection error %d: %s", e.code, e.reason)
+}
+
+// streamError ends a single stream with a RST_STREAM.
+type streamError struct {
+	streamID uint32
+	code     ErrorCode
+	reason   string
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("http2 stream %d error %d: %s", e.streamID, e.code, e.reason)
+}
@@ -0,0 +1,145 @@
+package http2
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+type frameType uint8
+
+// Frame types (RFC 9113 section 6).
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+const frameHeaderLength = 9
+
+// Settings (RFC 9113 section 6.5.2).
+const (
+	settingHeaderTableSize      = 0x1
+	settingEnablePush           = 0x2
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+	settingMaxFrameSize         = 0x5
+	settingMaxHeaderListSize    = 0x6
+)
+
+const (
+	defaultHeaderTableSize   = 4096
+	defaultInitialWindowSize = 65535
+	defaultMaxFrameSize      = 16384
+	maxMaxFrameSize          = 1<<24 - 1
+	maxWindowSize            = 1<<31 - 1
+)
+
+type frame struct {
+	typ      frameType
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func (f *frame) has(flag uint8) bool {
+	return f.flags&flag != 0
+}
+
+// readFrame reads the next frame, whose payload may be at most maxSize bytes.
+func readFrame(r io.Reader, maxSize uint32) (*frame, error) {
+	var header [frameHeaderLength]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	if length > maxSize {
+		return nil, connError{CodeFrameSizeError, "frame exceeds SETTINGS_MAX_FRAME_SIZE"}
+	}
+
+	f := &frame{
+		typ:   frameType(header[3]),
+		flags: header[4],
+		// the reserved bit is ignored
+		streamID: binary.BigEndian.Uint32(header[5:]) & (1<<31 - 1),
+		payload:  make([]byte, length),
+	}
+	_, err = io.ReadFull(r, f.payload)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// appendFrame appends a frame with payload to dst.
+func appendFrame(dst []byte, typ frameType, flags uint8, streamID uint32, payload []byte) []byte {
+	length := len(payload)
+	dst = append(dst, byte(length>>16), byte(length>>8), byte(length), byte(typ), flags)
+	dst = binary.BigEndian.AppendUint32(dst, streamID)
+	return append(dst, payload...)
+}
+
+// content returns the payload of a DATA or HEADERS frame without its padding
+// and, for HEADERS, priority fields.
+func (f *frame) content() ([]byte, error) {
+	p := f.payload
+	padding := 0
+	if f.has(flagPadded) {
+		if len(p) < 1 {
+			return nil, connError{CodeFrameSizeError, "padded frame without pad length"}
+		}
+		padding = int(p[0])
+		p = p[1:]
+	}
+	if f.typ == frameHeaders && f.has(flagPriority) {
+		if len(p) < 5 {
+			return nil, connError{CodeFrameSizeError, "headers frame too short for priority"}
+		}
+		p = p[5:]
+	}
+	if padding > len(p) {
+		return nil, connError{CodeProtocolError, "padding exceeds payload"}
+	}
+	return p[:len(p)-padding], nil
+}
+
+type setting struct {
+	id    uint16
+	value uint32
+}
+
+func parseSettings(payload []byte) ([]setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, connError{CodeFrameSizeError, "settings payload not a multiple of 6"}
+	}
+
+	settings := make([]setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, setting{
+			id:    binary.BigEndian.Uint16(payload[i:]),
+			value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+	return settings, nil
+}
+
+func appendSetting(dst []byte, id uint16, value uint32) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, id)
+	return binary.BigEndian.AppendUint32(dst, value)
+}
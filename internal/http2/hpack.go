@@ -0,0 +1,408 @@
+package http2
+
+import "slices"
+
+// headerField is a name and value as HPACK sees them.
+type headerField struct {
+	name  string
+	value string
+}
+
+// size is the space the field takes in a dynamic table (RFC 7541 section
+// 4.1).
+func (f headerField) size() int {
+	return len(f.name) + len(f.value) + 32
+}
+
+// staticTable is RFC 7541 appendix A; index 1 is its first entry.
+var staticTable = []headerField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// dynamicTable holds the fields most recently added, newest last.
+type dynamicTable struct {
+	fields  []headerField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f headerField) {
+	t.evict(t.maxSize - f.size())
+	if f.size() <= t.maxSize {
+		t.fields = append(t.fields, f)
+		t.size += f.size()
+	}
+}
+
+func (t *dynamicTable) setMaxSize(n int) {
+	t.maxSize = n
+	t.evict(n)
+}
+
+// evict drops the oldest fields until the table takes at most n.
+func (t *dynamicTable) evict(n int) {
+	drop := 0
+	for t.size > n && drop < len(t.fields) {
+		t.size -= t.fields[drop].size()
+		drop++
+	}
+	t.fields = append(t.fields[:0], t.fields[drop:]...)
+}
+
+// get returns the field at index i of the static table followed by the
+// dynamic one, newest first.
+func (t *dynamicTable) get(i uint64) (headerField, bool) {
+	switch {
+	case i == 0:
+		return headerField{}, false
+	case i <= uint64(len(staticTable)):
+		return staticTable[i-1], true
+	}
+
+	i -= uint64(len(staticTable)) + 1
+	if i >= uint64(len(t.fields)) {
+		return headerField{}, false
+	}
+	return t.fields[len(t.fields)-1-int(i)], true
+}
+
+// search returns the index of f, or else of a field with its name, or zero.
+func (t *dynamicTable) search(f headerField) (index uint64, exact bool) {
+	for i, sf := range staticTable {
+		if sf.name != f.name {
+			continue
+		}
+		if sf.value == f.value {
+			return uint64(i + 1), true
+		}
+		if index == 0 {
+			index = uint64(i + 1)
+		}
+	}
+	for i := len(t.fields) - 1; i >= 0; i-- {
+		df := t.fields[i]
+		if df.name != f.name {
+			continue
+		}
+		dynamicIndex := uint64(len(staticTable) + len(t.fields) - i)
+		if df.value == f.value {
+			return dynamicIndex, true
+		}
+		if index == 0 {
+			index = dynamicIndex
+		}
+	}
+	return index, false
+}
+
+// hpackDecoder decodes the header blocks of one direction of a connection.
+type hpackDecoder struct {
+	table dynamicTable
+	// maxTableSize is the SETTINGS_HEADER_TABLE_SIZE we announced, the
+	// largest table the encoder may ask for.
+	maxTableSize int
+	// maxFieldsSize bounds the decoded size of a block, measured like the
+	// dynamic table does.
+	maxFieldsSize int
+}
+
+func newHPACKDecoder(maxTableSize, maxFieldsSize int) *hpackDecoder {
+	return &hpackDecoder{
+		table:         dynamicTable{maxSize: maxTableSize},
+		maxTableSize:  maxTableSize,
+		maxFieldsSize: maxFieldsSize,
+	}
+}
+
+// decode decodes a complete header block (RFC 7541 section 6).
+func (d *hpackDecoder) decode(block []byte) ([]headerField, error) {
+	var fields []headerField
+	total := 0
+	for len(block) > 0 {
+		var (
+			f   headerField
+			err error
+		)
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			// indexed
+			var i uint64
+			i, block, err = readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			f, ok = d.table.get(i)
+			if !ok {
+				return nil, ErrorCompression
+			}
+		case b&0xc0 == 0x40:
+			// literal with incremental indexing
+			f, block, err = d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+		case b&0xe0 == 0x20:
+			// dynamic table size update, only allowed before any field
+			if len(fields) > 0 {
+				return nil, ErrorCompression
+			}
+			var n uint64
+			n, block, err = readInt(block, 5)
+			if err != nil || n > uint64(d.maxTableSize) {
+				return nil, ErrorCompression
+			}
+			d.table.setMaxSize(int(n))
+			continue
+		default:
+			// literal without indexing or never indexed
+			f, block, err = d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		total += f.size()
+		if total > d.maxFieldsSize {
+			return nil, ErrorCompression
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// readLiteral reads a literal field whose name index has an n-bit prefix.
+func (d *hpackDecoder) readLiteral(p []byte, n uint8) (headerField, []byte, error) {
+	i, p, err := readInt(p, n)
+	if err != nil {
+		return headerField{}, nil, err
+	}
+
+	var f headerField
+	if i == 0 {
+		f.name, p, err = readString(p, d.maxFieldsSize)
+		if err != nil {
+			return headerField{}, nil, err
+		}
+	} else {
+		indexed, ok := d.table.get(i)
+		if !ok {
+			return headerField{}, nil, ErrorCompression
+		}
+		f.name = indexed.name
+	}
+
+	f.value, p, err = readString(p, d.maxFieldsSize)
+	if err != nil {
+		return headerField{}, nil, err
+	}
+	return f, p, nil
+}
+
+// hpackEncoder encodes the header blocks of one direction of a connection.
+type hpackEncoder struct {
+	table dynamicTable
+	// minTableSize is the smallest size the table had since the last block,
+	// or -1 if it didn't change: the decoder must hear about both.
+	minTableSize int
+}
+
+func newHPACKEncoder() *hpackEncoder {
+	return &hpackEncoder{
+		table:        dynamicTable{maxSize: defaultHeaderTableSize},
+		minTableSize: -1,
+	}
+}
+
+// setMaxTableSize follows the SETTINGS_HEADER_TABLE_SIZE of the peer, up to
+// the default size.
+func (e *hpackEncoder) setMaxTableSize(n int) {
+	n = min(n, defaultHeaderTableSize)
+	if n == e.table.maxSize {
+		return
+	}
+	if e.minTableSize == -1 || n < e.minTableSize {
+		e.minTableSize = n
+	}
+	e.table.setMaxSize(n)
+}
+
+// sensitiveFields are never added to a table, so that they can't be guessed
+// from how well later blocks compress.
+var sensitiveFields = []string{"authorization", "cookie", "proxy-authorization", "set-cookie"}
+
+// encode appends the header block for fields to dst.
+func (e *hpackEncoder) encode(dst []byte, fields []headerField) []byte {
+	if e.minTableSize != -1 {
+		dst = appendInt(dst, 5, 0x20, uint64(e.minTableSize))
+		if e.minTableSize != e.table.maxSize {
+			dst = appendInt(dst, 5, 0x20, uint64(e.table.maxSize))
+		}
+		e.minTableSize = -1
+	}
+
+	for _, f := range fields {
+		index, exact := e.table.search(f)
+		switch {
+		case exact:
+			dst = appendInt(dst, 7, 0x80, index)
+			continue
+		case slices.Contains(sensitiveFields, f.name):
+			dst = appendInt(dst, 4, 0x10, index)
+		case f.size() > e.table.maxSize/2:
+			// would push out most of the table for a single use
+			dst = appendInt(dst, 4, 0x00, index)
+		default:
+			dst = appendInt(dst, 6, 0x40, index)
+			e.table.add(f)
+		}
+
+		if index == 0 {
+			dst = appendString(dst, f.name)
+		}
+		dst = appendString(dst, f.value)
+	}
+	return dst
+}
+
+// appendInt appends i with an n-bit prefix, the rest of the first byte being
+// the bits of first (RFC 7541 section 5.1).
+func appendInt(dst []byte, n uint8, first byte, i uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if i < limit {
+		return append(dst, first|byte(i))
+	}
+
+	dst = append(dst, first|byte(limit))
+	i -= limit
+	for i >= 0x80 {
+		dst = append(dst, byte(i&0x7f)|0x80)
+		i >>= 7
+	}
+	return append(dst, byte(i))
+}
+
+// readInt reads an integer with an n-bit prefix.
+func readInt(p []byte, n uint8) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, ErrorCompression
+	}
+
+	limit := uint64(1)<<n - 1
+	i := uint64(p[0]) & limit
+	p = p[1:]
+	if i < limit {
+		return i, p, nil
+	}
+
+	for shift := 0; ; shift += 7 {
+		// nothing we decode comes anywhere near 2^32
+		if len(p) == 0 || shift > 28 {
+			return 0, nil, ErrorCompression
+		}
+		b := p[0]
+		p = p[1:]
+		i += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+	}
+}
+
+// appendString appends s as a string literal, Huffman encoded if that is
+// shorter (RFC 7541 section 5.2).
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLength(s); n < len(s) {
+		dst = appendInt(dst, 7, 0x80, uint64(n))
+		return appendHuffman(dst, s)
+	}
+
+	dst = appendInt(dst, 7, 0x00, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// readString reads a string literal of at most maxLength encoded bytes.
+func readString(p []byte, maxLength int) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, ErrorCompression
+	}
+	huffman := p[0]&0x80 != 0
+
+	n, p, err := readInt(p, 7)
+	if err != nil || n > uint64(len(p)) || n > uint64(maxLength) {
+		return "", nil, ErrorCompression
+	}
+	raw := p[:n]
+	p = p[n:]
+
+	if !huffman {
+		return string(raw), p, nil
+	}
+	s, err := huffmanDecode(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return s, p, nil
+}
@@ -0,0 +1,146 @@
+package http2
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func TestHPACKDecode(t *testing.T) {
+	// Test: requests without Huffman coding (RFC 7541 appendix C.3)
+	d := newHPACKDecoder(defaultHeaderTableSize, DefaultMaxHeaderListSize)
+	fields, err := d.decode(decodeHex(t, "8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d"))
+	require.NoError(t, err)
+	assert.Equal(t, []headerField{
+		{":method", "GET"},
+		{":scheme", "http"},
+		{":path", "/"},
+		{":authority", "www.example.com"},
+	}, fields)
+	assert.Equal(t, 57, d.table.size)
+
+	fields, err = d.decode(decodeHex(t, "8286 84be 5808 6e6f 2d63 6163 6865"))
+	require.NoError(t, err)
+	assert.Equal(t, []headerField{
+		{":method", "GET"},
+		{":scheme", "http"},
+		{":path", "/"},
+		{":authority", "www.example.com"},
+		{"cache-control", "no-cache"},
+	}, fields)
+	assert.Equal(t, 110, d.table.size)
+
+	// Test: the same requests with Huffman coding (RFC 7541 appendix C.4)
+	d = newHPACKDecoder(defaultHeaderTableSize, DefaultMaxHeaderListSize)
+	fields, err = d.decode(decodeHex(t, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"))
+	require.NoError(t, err)
+	assert.Equal(t, headerField{":authority", "www.example.com"}, fields[3])
+
+	fields, err = d.decode(decodeHex(t, "8286 84be 5886 a8eb 1064 9cbf"))
+	require.NoError(t, err)
+	assert.Equal(t, headerField{"cache-control", "no-cache"}, fields[4])
+
+	fields, err = d.decode(decodeHex(t, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"))
+	require.NoError(t, err)
+	assert.Equal(t, []headerField{
+		{":method", "GET"},
+		{":scheme", "https"},
+		{":path", "/index.html"},
+		{":authority", "www.example.com"},
+		{"custom-key", "custom-value"},
+	}, fields)
+	assert.Equal(t, 164, d.table.size)
+
+	// Test: an index past the tables is an error
+	d = newHPACKDecoder(defaultHeaderTableSize, DefaultMaxHeaderListSize)
+	_, err = d.decode([]byte{0xbe})
+	assert.ErrorIs(t, err, ErrorCompression)
+
+	// Test: a table size update after a field is an error
+	_, err = d.decode([]byte{0x82, 0x20})
+	assert.ErrorIs(t, err, ErrorCompression)
+
+	// Test: a table larger than announced is an error
+	_, err = d.decode(appendInt(nil, 5, 0x20, defaultHeaderTableSize+1))
+	assert.ErrorIs(t, err, ErrorCompression)
+
+	// Test: a truncated string is an error
+	_, err = d.decode([]byte{0x40, 0x05, 'a'})
+	assert.ErrorIs(t, err, ErrorCompression)
+
+	// Test: a block decoding to more than the limit is an error
+	d = newHPACKDecoder(defaultHeaderTableSize, 64)
+	_, err = d.decode(decodeHex(t, "8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d"))
+	assert.ErrorIs(t, err, ErrorCompression)
+}
+
+func TestHPACKRoundTrip(t *testing.T) {
+	e := newHPACKEncoder()
+	d := newHPACKDecoder(defaultHeaderTableSize, DefaultMaxHeaderListSize)
+
+	blocks := [][]headerField{
+		{{":status", "200"}, {"content-type", "text/html"}, {"x-request-id", "abc"}},
+		{{":status", "200"}, {"content-type", "text/html"}, {"x-request-id", "def"}},
+		{{":status", "404"}, {"set-cookie", "session=secret"}, {"x-large", strings.Repeat("x", 3000)}},
+	}
+	for _, fields := range blocks {
+		block := e.encode(nil, fields)
+		decoded, err := d.decode(block)
+		require.NoError(t, err)
+		assert.Equal(t, fields, decoded)
+	}
+
+	// Test: repeated fields come from the dynamic table
+	block := e.encode(nil, blocks[1])
+	assert.Len(t, block, 3)
+
+	// Test: sensitive and large fields are never indexed
+	_, exact := e.table.search(headerField{"set-cookie", "session=secret"})
+	assert.False(t, exact)
+	_, exact = e.table.search(headerField{"x-large", strings.Repeat("x", 3000)})
+	assert.False(t, exact)
+
+	// Test: a smaller table is announced in the next block
+	e.setMaxTableSize(0)
+	e.setMaxTableSize(100)
+	block = e.encode(nil, blocks[0])
+	assert.Equal(t, byte(0x20), block[0])
+	decoded, err := d.decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, blocks[0], decoded)
+	assert.Equal(t, 100, d.table.maxSize)
+}
+
+func TestHuffman(t *testing.T) {
+	// Test: encoding matches RFC 7541 appendix C.4.1
+	encoded := appendHuffman(nil, "www.example.com")
+	assert.Equal(t, decodeHex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), encoded)
+	assert.Equal(t, len(encoded), huffmanEncodedLength("www.example.com"))
+
+	// Test: every byte value survives a round trip
+	var all strings.Builder
+	for i := range 256 {
+		all.WriteByte(byte(i))
+	}
+	s, err := huffmanDecode(appendHuffman(nil, all.String()))
+	require.NoError(t, err)
+	assert.Equal(t, all.String(), s)
+
+	// Test: padding of zeros is an error
+	_, err = huffmanDecode([]byte{0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0x00})
+	assert.ErrorIs(t, err, ErrorCompression)
+
+	// Test: padding of a whole byte is an error
+	_, err = huffmanDecode([]byte{0xff})
+	assert.ErrorIs(t, err, ErrorCompression)
+}
@@ -0,0 +1,151 @@
+// Package http2 serves HTTP/2 (RFC 9113) to the same handlers as HTTP/1.1.
+// Connections start either with the client preface ("prior knowledge") or as
+// an HTTP/1.1 request asking to upgrade to "h2c"; both run over cleartext.
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// ClientPreface is what every HTTP/2 connection starts with.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	// DefaultMaxConcurrentStreams is how many requests a client may have in
+	// flight on a connection.
+	DefaultMaxConcurrentStreams = 100
+	// DefaultMaxHeaderListSize bounds the decoded header section of a
+	// request, counting 32 bytes of overhead per field.
+	DefaultMaxHeaderListSize = 1 << 20
+	// DefaultIdleTimeout is how long a connection without streams is kept.
+	DefaultIdleTimeout = 5 * time.Minute
+)
+
+// Handler serves a request read from a stream and answers through f. err is
+// set when the request was refused, e.g. with request.ErrorBodyTooLarge; the
+// handler is then expected to answer with an error status.
+type Handler func(f response.Framer, req *request.Request, err error)
+
+type config struct {
+	maxConcurrentStreams int
+	maxHeaderListSize    int
+	maxBodySize          int
+	idleTimeout          time.Duration
+
+	// upgrade is the request that asked for "h2c", answered on stream 1.
+	upgrade         *request.Request
+	upgradeSettings []setting
+}
+
+// Option configures ServeConn.
+type Option func(*config)
+
+// WithMaxConcurrentStreams replaces DefaultMaxConcurrentStreams.
+func WithMaxConcurrentStreams(n int) Option {
+	return func(c *config) {
+		c.maxConcurrentStreams = n
+	}
+}
+
+// WithMaxBodySize refuses request bodies over n bytes with
+// request.ErrorBodyTooLarge.
+func WithMaxBodySize(n int) Option {
+	return func(c *config) {
+		c.maxBodySize = n
+	}
+}
+
+// WithIdleTimeout replaces DefaultIdleTimeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+	}
+}
+
+// IsUpgrade reports whether req asks to continue the connection in HTTP/2
+// (RFC 7540 section 3.2).
+func IsUpgrade(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get(headers.UpgradeHeader)
+	connection, _ := req.Headers.Get(headers.ConnectionHeader)
+	_, hasSettings := req.Headers.Get(headers.HTTP2SettingsHeader)
+
+	return hasSettings &&
+		hasToken(upgrade, "h2c") &&
+		hasToken(connection, "upgrade") &&
+		hasToken(connection, headers.HTTP2SettingsHeader)
+}
+
+// WithUpgrade makes ServeConn answer req, an upgrade request already answered
+// with 101, on stream 1 and apply the settings it carries. It fails with
+// ErrorBadSettings if those can't be decoded, in which case the upgrade should
+// be refused. The fields that only concerned the upgrade are removed from req.
+func WithUpgrade(req *request.Request) (Option, error) {
+	value, _ := req.Headers.Get(headers.HTTP2SettingsHeader)
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, ErrorBadSettings
+	}
+	settings, err := parseSettings(payload)
+	if err != nil {
+		return nil, ErrorBadSettings
+	}
+
+	connection, _ := req.Headers.Get(headers.ConnectionHeader)
+	hopByHop := append(headers.SplitList(connection), headers.ConnectionHeader, headers.UpgradeHeader, headers.HTTP2SettingsHeader)
+	for _, name := range hopByHop {
+		name = strings.ToLower(name)
+		req.Headers.Remove(name)
+		req.HeaderOrder = slices.DeleteFunc(req.HeaderOrder, func(key string) bool {
+			return key == name
+		})
+	}
+	req.RequestLine.HTTPVersion = "2"
+
+	return func(c *config) {
+		c.upgrade = req
+		c.upgradeSettings = settings
+	}, nil
+}
+
+// ServeConn speaks HTTP/2 on conn until the client goes away, then closes
+// it. buffered holds bytes already read from conn, which come first. Unless
+// the connection was upgraded, they start with ClientPreface.
+func ServeConn(conn net.Conn, buffered []byte, handler Handler, opts ...Option) error {
+	cfg := config{
+		maxConcurrentStreams: DefaultMaxConcurrentStreams,
+		maxHeaderListSize:    DefaultMaxHeaderListSize,
+		idleTimeout:          DefaultIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var r io.Reader = conn
+	if len(buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+
+	sc := newServerConn(conn, bufio.NewReader(r), handler, cfg)
+	return sc.serve()
+}
+
+// hasToken reports whether the comma separated list value contains token.
+func hasToken(value, token string) bool {
+	for _, v := range headers.SplitList(value) {
+		if strings.EqualFold(v, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,512 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen serves HTTP/2 with handler on a local port and returns its address
+// along with a count of accepted connections.
+func listen(t *testing.T, handler Handler, opts ...Option) (string, *atomic.Int32) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	accepted := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				_ = ServeConn(conn, nil, handler, opts...)
+			}()
+		}
+	}()
+	return l.Addr().String(), accepted
+}
+
+// writerHandler adapts a handler written against response.Writer.
+func writerHandler(h func(w *response.Writer, req *request.Request)) Handler {
+	return func(f response.Framer, req *request.Request, err error) {
+		w := response.NewFramedWriter(f)
+		if err != nil {
+			_ = w.WriteError(response.StatusCodeContentTooLarge, nil)
+			return
+		}
+		w.SetTrailersAccepted(true)
+		h(w, req)
+		_ = w.Finish()
+	}
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	h := headers.NewHeaders()
+	h.Set(headers.ContentTypeHeader, "text/plain")
+	h.Set("x-method", req.RequestLine.Method)
+	h.Set("x-target", req.RequestLine.RequestTarget)
+	h.Set("x-version", req.RequestLine.HTTPVersion)
+	host, _ := req.Headers.Get(headers.HostHeader)
+	h.Set("x-host", host)
+	cookie, _ := req.Headers.Get(headers.CookieHeader)
+	h.Set("x-cookie", cookie)
+	h.Set(headers.ContentLengthHeader, fmt.Sprint(len(req.Body)))
+	// dropped, HTTP/2 has no use for it
+	h.Set(headers.ConnectionHeader, "close")
+	_ = w.AddSetCookie("a=1")
+	_ = w.AddSetCookie("b=2")
+
+	_ = w.WriteStatusLine(response.StatusCodeOK)
+	_ = w.WriteHeaders(h)
+	_, _ = w.WriteBody(req.Body)
+}
+
+func h2cClient() *http.Client {
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{
+		Transport: &http.Transport{Protocols: protocols},
+		Timeout:   5 * time.Second,
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	addr, accepted := listen(t, writerHandler(echoHandler))
+	client := h2cClient()
+
+	// Test: a GET is served with the request's pseudo-header fields
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/path?q=1", nil)
+	require.NoError(t, err)
+	req.Header.Add("Cookie", "a=1")
+	req.Header.Add("Cookie", "b=2")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "GET", resp.Header.Get("X-Method"))
+	assert.Equal(t, "/path?q=1", resp.Header.Get("X-Target"))
+	assert.Equal(t, "2", resp.Header.Get("X-Version"))
+	assert.Equal(t, addr, resp.Header.Get("X-Host"))
+	assert.Equal(t, "a=1; b=2", resp.Header.Get("X-Cookie"))
+	assert.Empty(t, resp.Header.Get("Connection"))
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+	assert.Empty(t, body)
+
+	// Test: a POST body arrives whole
+	payload := strings.Repeat("abcdefgh", 20000)
+	resp, err = client.Post("http://"+addr+"/", "text/plain", strings.NewReader(payload))
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, payload, string(body))
+
+	// Test: concurrent requests share the connection
+	errs := make(chan error, 20)
+	for range 20 {
+		go func() {
+			resp, err := client.Get("http://" + addr + "/")
+			if err == nil {
+				_, err = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+	for range 20 {
+		assert.NoError(t, <-errs)
+	}
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestServeConnLargeResponse(t *testing.T) {
+	// Test: a body beyond the initial window waits for WINDOW_UPDATE
+	body := strings.Repeat("0123456789", 50000)
+	addr, _ := listen(t, writerHandler(func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	}))
+
+	resp, err := h2cClient().Get("http://" + addr + "/")
+	require.NoError(t, err)
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, body, string(got))
+}
+
+func TestServeConnTrailers(t *testing.T) {
+	addr, _ := listen(t, writerHandler(func(w *response.Writer, _ *request.Request) {
+		_ = w.DeclareTrailers("x-checksum")
+		h := headers.NewHeaders()
+		h.Set(headers.TransferEncodingHeader, "chunked")
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte("hello "))
+		_, _ = w.WriteChunkedBody([]byte("world"))
+		_, _ = w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("x-checksum", "abc")
+		_ = w.WriteTrailers(trailers)
+	}))
+
+	// Test: a chunked body goes out as DATA frames followed by trailers
+	resp, err := h2cClient().Get("http://" + addr + "/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "hello world", string(body))
+	assert.Empty(t, resp.Header.Get("Transfer-Encoding"))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestServeConnBodyTooLarge(t *testing.T) {
+	addr, _ := listen(t, writerHandler(echoHandler), WithMaxBodySize(10))
+	client := h2cClient()
+
+	// Test: a body over the limit is refused
+	resp, err := client.Post("http://"+addr+"/", "text/plain", strings.NewReader(strings.Repeat("x", 100)))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Test: one within it is served
+	resp, err = client.Post("http://"+addr+"/", "text/plain", strings.NewReader("small"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "small", string(body))
+}
+
+// rawClient speaks HTTP/2 frame by frame.
+type rawClient struct {
+	t       *testing.T
+	conn    net.Conn
+	reader  *bufio.Reader
+	encoder *hpackEncoder
+	decoder *hpackDecoder
+}
+
+func dialRaw(t *testing.T, addr string) *rawClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	return &rawClient{
+		t:       t,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		encoder: newHPACKEncoder(),
+		decoder: newHPACKDecoder(defaultHeaderTableSize, DefaultMaxHeaderListSize),
+	}
+}
+
+func (c *rawClient) start() {
+	_, err := io.WriteString(c.conn, ClientPreface)
+	require.NoError(c.t, err)
+	c.write(frameSettings, 0, 0, nil)
+}
+
+func (c *rawClient) write(typ frameType, flags uint8, streamID uint32, payload []byte) {
+	_, err := c.conn.Write(appendFrame(nil, typ, flags, streamID, payload))
+	require.NoError(c.t, err)
+}
+
+func (c *rawClient) writeHeaders(streamID uint32, flags uint8, fields ...headerField) {
+	c.write(frameHeaders, flags|flagEndHeaders, streamID, c.encoder.encode(nil, fields))
+}
+
+// next returns the next frame of type typ, skipping over others.
+func (c *rawClient) next(typ frameType) *frame {
+	for {
+		f, err := readFrame(c.reader, maxMaxFrameSize)
+		require.NoError(c.t, err)
+		if f.typ == typ {
+			return f
+		}
+	}
+}
+
+func (c *rawClient) nextHeaders() (*frame, []headerField) {
+	f := c.next(frameHeaders)
+	fields, err := c.decoder.decode(f.payload)
+	require.NoError(c.t, err)
+	return f, fields
+}
+
+func getFields(path string) []headerField {
+	return []headerField{{":method", "GET"}, {":scheme", "http"}, {":path", path}, {":authority", "localhost"}}
+}
+
+func errorCode(f *frame) ErrorCode {
+	if f.typ == frameGoAway {
+		return ErrorCode(binary.BigEndian.Uint32(f.payload[4:]))
+	}
+	return ErrorCode(binary.BigEndian.Uint32(f.payload))
+}
+
+func TestServeConnProtocol(t *testing.T) {
+	addr, _ := listen(t, writerHandler(echoHandler))
+
+	// Test: the server's SETTINGS come first and PING is answered
+	c := dialRaw(t, addr)
+	c.start()
+	f := c.next(frameSettings)
+	assert.False(t, f.has(flagAck))
+	c.write(framePing, 0, 0, []byte("pingpong"))
+	f = c.next(framePing)
+	assert.True(t, f.has(flagAck))
+	assert.Equal(t, "pingpong", string(f.payload))
+
+	// Test: a request with an uppercase field name is reset
+	c.writeHeaders(1, flagEndStream, append(getFields("/"), headerField{"X-Upper", "1"})...)
+	f = c.next(frameRSTStream)
+	assert.Equal(t, uint32(1), f.streamID)
+	assert.Equal(t, CodeProtocolError, errorCode(f))
+
+	// Test: so is one with a connection-specific field
+	c.writeHeaders(3, flagEndStream, append(getFields("/"), headerField{"connection", "keep-alive"})...)
+	f = c.next(frameRSTStream)
+	assert.Equal(t, uint32(3), f.streamID)
+
+	// Test: the connection still serves valid requests
+	c.writeHeaders(5, flagEndStream, getFields("/ok")...)
+	f, fields := c.nextHeaders()
+	assert.Equal(t, uint32(5), f.streamID)
+	assert.Contains(t, fields, headerField{":status", "200"})
+	assert.Contains(t, fields, headerField{"x-target", "/ok"})
+	assert.True(t, c.next(frameData).has(flagEndStream))
+
+	// Test: a stream ID going backwards ends the connection
+	c.writeHeaders(3, flagEndStream, getFields("/")...)
+	f = c.next(frameGoAway)
+	assert.Equal(t, CodeStreamClosed, errorCode(f))
+	assert.Equal(t, uint32(5), binary.BigEndian.Uint32(f.payload))
+
+	// Test: DATA on a stream never opened ends the connection
+	c = dialRaw(t, addr)
+	c.start()
+	c.write(frameData, flagEndStream, 7, []byte("x"))
+	assert.Equal(t, CodeProtocolError, errorCode(c.next(frameGoAway)))
+
+	// Test: a connection that doesn't start with SETTINGS is refused
+	c = dialRaw(t, addr)
+	_, err := io.WriteString(c.conn, ClientPreface)
+	require.NoError(t, err)
+	c.write(framePing, 0, 0, []byte("pingpong"))
+	assert.Equal(t, CodeProtocolError, errorCode(c.next(frameGoAway)))
+}
+
+func TestServeConnMalformedRequests(t *testing.T) {
+	addr, _ := listen(t, writerHandler(echoHandler))
+	c := dialRaw(t, addr)
+	c.start()
+
+	withField := func(name, value string) []headerField {
+		return append(getFields("/"), headerField{name, value})
+	}
+	withPseudo := func(name, value string) []headerField {
+		fields := getFields("/")
+		for i := range fields {
+			if fields[i].name == name {
+				fields[i].value = value
+			}
+		}
+		return fields
+	}
+	tests := []struct {
+		name   string
+		fields []headerField
+	}{
+		{"field name with CRLF", withField("x-a\r\ncontent-length: 0\r\n\r\nget /smuggled http/1.1\r\nx-b", "1")},
+		{"field name with a space", withField("x a", "1")},
+		{"field name with a colon", withField("x:a", "1")},
+		{"empty field name", withField("", "1")},
+		{"method with CRLF", withPseudo(":method", "GET /b HTTP/1.1\r\nhost: up\r\n\r\nPOST")},
+		{"method with a space", withPseudo(":method", "GET /b")},
+		{"empty method", withPseudo(":method", "")},
+		{"path without a slash", withPseudo(":path", "b")},
+		{"path with a space", withPseudo(":path", "/b HTTP/1.1")},
+		{"asterisk path for GET", withPseudo(":path", "*")},
+		{"authority with CRLF", withPseudo(":authority", "up\r\nx-a: 1")},
+	}
+
+	// Test: each of these requests is reset, and the connection stays up
+	streamID := uint32(1)
+	for _, tt := range tests {
+		c.writeHeaders(streamID, flagEndStream, tt.fields...)
+		f := c.next(frameRSTStream)
+		assert.Equal(t, streamID, f.streamID, tt.name)
+		assert.Equal(t, CodeProtocolError, errorCode(f), tt.name)
+		streamID += 2
+	}
+
+	// Test: "*" is the path of a server-wide OPTIONS
+	c.writeHeaders(streamID, flagEndStream, headerField{":method", "OPTIONS"}, headerField{":scheme", "http"}, headerField{":path", "*"}, headerField{":authority", "localhost"})
+	f, fields := c.nextHeaders()
+	assert.Equal(t, streamID, f.streamID)
+	assert.Contains(t, fields, headerField{":status", "200"})
+	assert.Contains(t, fields, headerField{"x-target", "*"})
+}
+
+func TestServeConnHeaderBlocks(t *testing.T) {
+	addr, _ := listen(t, writerHandler(echoHandler))
+	c := dialRaw(t, addr)
+	c.start()
+
+	// Test: a header block may continue in CONTINUATION frames
+	block := c.encoder.encode(nil, append(getFields("/split"), headerField{"x-long", strings.Repeat("y", 100)}))
+	c.write(frameHeaders, flagEndStream, 1, block[:10])
+	c.write(frameContinuation, 0, 1, block[10:20])
+	c.write(frameContinuation, flagEndHeaders, 1, block[20:])
+	_, fields := c.nextHeaders()
+	assert.Contains(t, fields, headerField{"x-target", "/split"})
+
+	// Test: request trailers end the stream
+	c.writeHeaders(3, 0, append(getFields("/"), headerField{"content-length", "5"})...)
+	c.write(frameData, 0, 3, []byte("hello"))
+	c.writeHeaders(3, flagEndStream, headerField{"x-checksum", "abc"})
+	f, fields := c.nextHeaders()
+	assert.Equal(t, uint32(3), f.streamID)
+	assert.Contains(t, fields, headerField{"content-length", "5"})
+	assert.Equal(t, "hello", string(c.next(frameData).payload))
+
+	// Test: a body not matching content-length resets the stream
+	c.writeHeaders(5, 0, append(getFields("/"), headerField{"content-length", "5"})...)
+	c.write(frameData, flagEndStream, 5, []byte("hi"))
+	f = c.next(frameRSTStream)
+	assert.Equal(t, uint32(5), f.streamID)
+	assert.Equal(t, CodeProtocolError, errorCode(f))
+
+	// Test: another frame interrupting a header block ends the connection
+	c.write(frameHeaders, 0, 7, block[:10])
+	c.write(framePing, 0, 0, []byte("pingpong"))
+	assert.Equal(t, CodeProtocolError, errorCode(c.next(frameGoAway)))
+}
+
+func TestServeConnFlowControl(t *testing.T) {
+	body := strings.Repeat("z", 100)
+	addr, _ := listen(t, writerHandler(func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	}))
+	c := dialRaw(t, addr)
+
+	// Test: DATA is held back by a window of 40 bytes
+	_, err := io.WriteString(c.conn, ClientPreface)
+	require.NoError(t, err)
+	c.write(frameSettings, 0, 0, appendSetting(nil, settingInitialWindowSize, 40))
+	c.writeHeaders(1, flagEndStream, getFields("/")...)
+	c.nextHeaders()
+	f := c.next(frameData)
+	assert.Len(t, f.payload, 40)
+
+	// Test: raising the window lets the rest through
+	c.write(frameWindowUpdate, 0, 1, binary.BigEndian.AppendUint32(nil, 1000))
+	var got []byte
+	for {
+		f = c.next(frameData)
+		got = append(got, f.payload...)
+		if f.has(flagEndStream) {
+			break
+		}
+	}
+	assert.Equal(t, body[40:], string(got))
+
+	// Test: a window overflowing 2^31-1 ends the connection
+	c.write(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, maxWindowSize))
+	assert.Equal(t, CodeFlowControlError, errorCode(c.next(frameGoAway)))
+}
+
+func TestServeConnUpgrade(t *testing.T) {
+	settings := base64.RawURLEncoding.EncodeToString(appendSetting(nil, settingInitialWindowSize, 20))
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "POST", RequestTarget: "/up", HTTPVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		HeaderOrder: []string{"host", "connection", "upgrade", "http2-settings", "content-length"},
+		Body:        []byte("body"),
+	}
+	req.Headers.Set("host", "localhost")
+	req.Headers.Set("connection", "Upgrade, HTTP2-Settings")
+	req.Headers.Set("upgrade", "h2c")
+	req.Headers.Set("http2-settings", settings)
+	req.Headers.Set("content-length", "4")
+
+	// Test: an upgrade request is recognized
+	assert.True(t, IsUpgrade(req))
+
+	// Test: the upgrade fields are dropped and the settings applied
+	upgrade, err := WithUpgrade(req)
+	require.NoError(t, err)
+	assert.Equal(t, "2", req.RequestLine.HTTPVersion)
+	assert.Equal(t, []string{"host", "content-length"}, req.HeaderOrder)
+	_, ok := req.Headers.Get("http2-settings")
+	assert.False(t, ok)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			_ = ServeConn(conn, nil, writerHandler(echoHandler), upgrade)
+		}
+	}()
+
+	// Test: the request is answered on stream 1 with the client's window
+	c := dialRaw(t, l.Addr().String())
+	c.start()
+	f, fields := c.nextHeaders()
+	assert.Equal(t, uint32(1), f.streamID)
+	assert.Contains(t, fields, headerField{"x-target", "/up"})
+	assert.Contains(t, fields, headerField{"x-method", "POST"})
+	f = c.next(frameData)
+	assert.Equal(t, "body", string(f.payload))
+
+	// Test: malformed settings are refused
+	req.Headers.Set("http2-settings", "not base64!")
+	_, err = WithUpgrade(req)
+	assert.ErrorIs(t, err, ErrorBadSettings)
+
+	// Test: a request without HTTP2-Settings is no upgrade
+	req = &request.Request{Headers: headers.NewHeaders()}
+	req.Headers.Set("connection", "upgrade")
+	req.Headers.Set("upgrade", "h2c")
+	assert.False(t, IsUpgrade(req))
+}
@@ -0,0 +1,88 @@
+package http2
+
+import "sync"
+
+type huffmanNode struct {
+	children [2]*huffmanNode
+	leaf     bool
+	sym      byte
+}
+
+// huffmanTree is the decoding tree of the Huffman code, built on first use.
+var huffmanTree = sync.OnceValue(func() *huffmanNode {
+	root := &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		n := root
+		for i := int(huffmanCodeLengths[sym]) - 1; i >= 0; i-- {
+			bit := (code >> i) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &huffmanNode{}
+			}
+			n = n.children[bit]
+		}
+		n.leaf = true
+		n.sym = byte(sym)
+	}
+	return root
+})
+
+// huffmanEncodedLength returns how many bytes s takes Huffman encoded.
+func huffmanEncodedLength(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLengths[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// appendHuffman appends s Huffman encoded to dst, padded with the most
+// significant bits of EOS.
+func appendHuffman(dst []byte, s string) []byte {
+	var acc uint64
+	n := 0
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLengths[s[i]] | uint64(huffmanCodes[s[i]])
+		n += int(huffmanCodeLengths[s[i]])
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
+
+// huffmanDecode decodes p. Padding must be shorter than a byte and made of
+// ones, and no code may be EOS (RFC 7541 section 5.2).
+func huffmanDecode(p []byte) (string, error) {
+	root := huffmanTree()
+	out := make([]byte, 0, len(p)*8/5)
+
+	n := root
+	// bits and ones count the bits read since the last symbol, and how many
+	// of them were set
+	bits, ones := 0, 0
+	for _, b := range p {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> i) & 1
+			n = n.children[bit]
+			if n == nil {
+				return "", ErrorCompression
+			}
+			bits++
+			ones += int(bit)
+			if n.leaf {
+				out = append(out, n.sym)
+				n = root
+				bits, ones = 0, 0
+			}
+		}
+	}
+
+	if bits > 7 || ones != bits {
+		return "", ErrorCompression
+	}
+	return string(out), nil
+}
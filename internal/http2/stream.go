@@ -0,0 +1,193 @@
+package http2
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// stream is a single request and its response. It is the response.Framer
+// the handler answers through.
+type stream struct {
+	sc  *serverConn
+	id  uint32
+	req *request.Request
+
+	// owned by the reading goroutine
+	recvWindow int64
+	// discard is set once the request was refused, its body is dropped
+	discard bool
+
+	// guarded by sc.mu
+	sendWindow int64
+	remoteDone bool
+	reset      bool
+
+	// owned by the handler
+	headersSent bool
+	ended       bool
+}
+
+var _ response.Framer = (*stream)(nil)
+
+func (sc *serverConn) openStream(id uint32, req *request.Request) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	st := &stream{
+		sc:         sc,
+		id:         id,
+		req:        req,
+		recvWindow: defaultInitialWindowSize,
+		sendWindow: sc.peerInitialWindow,
+	}
+	sc.streams[id] = st
+	return st
+}
+
+// startUpgraded opens stream 1 for the request that upgraded the connection,
+// complete already since its body came over HTTP/1.1.
+func (sc *serverConn) startUpgraded() error {
+	err := sc.applySettings(sc.cfg.upgradeSettings)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	sc.lastStreamID = 1
+	sc.mu.Unlock()
+
+	st := sc.openStream(1, sc.cfg.upgrade)
+	return sc.endRequest(st)
+}
+
+func (st *stream) remoteClosed() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	return st.remoteDone
+}
+
+func (st *stream) isReset() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	return st.reset
+}
+
+// WriteHeaders sends a HEADERS frame with :status, the fields of h that
+// HTTP/2 allows, names in lowercase, and setCookies.
+func (st *stream) WriteHeaders(statusCode response.StatusCode, h headers.Headers, setCookies []string) error {
+	switch {
+	case st.ended || st.headersSent:
+		return ErrorResponseEnded
+	case statusCode == response.StatusCodeSwitchingProtocols:
+		return ErrorUnsupportedCode
+	case st.isReset():
+		return ErrorStreamReset
+	}
+
+	fields := []headerField{{":status", strconv.Itoa(int(statusCode))}}
+	fields = appendFields(fields, h)
+	for _, v := range setCookies {
+		fields = append(fields, headerField{headers.SetCookieHeader, v})
+	}
+	err := st.sc.writeHeaders(st.id, fields, false)
+	if err != nil {
+		return err
+	}
+
+	if !statusCode.IsInformational() {
+		st.headersSent = true
+	}
+	return nil
+}
+
+// Write sends p in DATA frames as flow control allows, waiting for the client
+// to open its window when needed.
+func (st *stream) Write(p []byte) (int, error) {
+	if st.ended {
+		return 0, ErrorResponseEnded
+	}
+
+	written := 0
+	for written < len(p) {
+		n, err := st.sc.reserve(st, len(p)-written)
+		if err != nil {
+			return written, err
+		}
+
+		err = st.sc.writeFrame(frameData, 0, st.id, p[written:written+n])
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// End closes the stream, with trailers as a last HEADERS frame unless empty.
+func (st *stream) End(trailers headers.Headers) error {
+	if st.ended {
+		return ErrorResponseEnded
+	}
+	st.ended = true
+	defer st.closeLocal()
+
+	if st.isReset() {
+		return ErrorStreamReset
+	}
+	if len(trailers) > 0 {
+		return st.sc.writeHeaders(st.id, appendFields(nil, trailers), true)
+	}
+	return st.sc.writeFrame(frameData, flagEndStream, st.id, nil)
+}
+
+// closeLocal forgets the stream once its response is sent. If the client is
+// still sending the request, it is told to stop (RFC 9113 section 8.1).
+func (st *stream) closeLocal() {
+	sc := st.sc
+	sc.mu.Lock()
+	stop := !st.remoteDone && !st.reset
+	delete(sc.streams, st.id)
+	sc.mu.Unlock()
+
+	if stop {
+		sc.resetStream(st.id, CodeNoError)
+	}
+}
+
+// finish ends the response once the handler returned: a stream without
+// response is reset, any other completed.
+func (st *stream) finish() {
+	if st.ended {
+		return
+	}
+	if !st.headersSent {
+		st.ended = true
+		st.sc.resetStream(st.id, CodeInternalError)
+		return
+	}
+
+	err := st.End(nil)
+	if err != nil {
+		log.Printf("Failed to end stream %d: %v", st.id, err)
+	}
+}
+
+// appendFields appends the fields of h to dst sorted by name, leaving out
+// the connection-specific ones.
+func appendFields(dst []headerField, h headers.Headers) []headerField {
+	for _, key := range slices.Sorted(maps.Keys(h)) {
+		name := strings.ToLower(key)
+		if slices.Contains(connectionFields, name) {
+			continue
+		}
+		dst = append(dst, headerField{name, h[key]})
+	}
+	return dst
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import "time"
+
+// circuit is the circuit breaker of one upstream.
+//
+// It starts closed, letting every request through. After enough failures in
+// a row it opens and refuses all of them until openUntil. It is then half
+// open: a single trial request is let through, which closes the circuit if
+// it succeeds and opens it again if it fails.
+type circuit struct {
+	failures  int
+	open      bool
+	openUntil time.Time
+	// trial is set while the trial request of a half open circuit is in
+	// flight.
+	trial bool
+}
+
+// available reports whether a request may be sent now.
+func (c *circuit) available(now time.Time) bool {
+	if !c.open {
+		return true
+	}
+	return !now.Before(c.openUntil) && !c.trial
+}
+
+// admit records that a request is being sent, which is the trial if the
+// circuit is half open.
+func (c *circuit) admit() {
+	if c.open {
+		c.trial = true
+	}
+}
+
+// succeeded records a successful request and reports whether it closed the
+// circuit.
+func (c *circuit) succeeded() bool {
+	c.failures = 0
+	if !c.open {
+		return false
+	}
+
+	c.open = false
+	c.trial = false
+	return true
+}
+
+// failed records a failed request and reports whether it opened the circuit,
+// which stays open for openFor. Zero maxFailures never opens it.
+func (c *circuit) failed(now time.Time, maxFailures int, openFor time.Duration) bool {
+	c.failures++
+
+	if c.open {
+		// the trial failed
+		c.trial = false
+		c.openUntil = now.Add(openFor)
+		return true
+	}
+	if maxFailures == 0 || c.failures < maxFailures {
+		return false
+	}
+
+	c.open = true
+	c.openUntil = now.Add(openFor)
+	return true
+}
@@ -0,0 +1,8 @@
+package proxy
+
+import "errors"
+
+var (
+	ErrorInvalidTarget         = errors.New("upstream target must be an absolute http or https URL")
+	ErrorDestinationNotAllowed = errors.New("destination address is not allowed")
+)
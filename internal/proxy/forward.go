@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/client"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// Forward is a forward proxy: clients send it requests with an absolute-form
+// target, like "GET http://example.com/ HTTP/1.1", which it forwards the way
+// Proxy does, and open tunnels with "CONNECT example.com:443 HTTP/1.1", after
+// which it copies bytes both ways until either side is done.
+//
+// Only the destination ports allowed with WithAllowedPorts, 80 and 443 by
+// default, can be reached, and only on public addresses: loopback, private,
+// link-local and unspecified ones are refused after the host is resolved,
+// unless WithInternalDestinations is given. WithAllowedHosts narrows the
+// destinations further.
+type Forward struct {
+	client    *client.Client
+	dialer    *net.Dialer
+	pseudonym string
+
+	internal bool
+
+	realm string
+	// users maps user names to passwords for Proxy-Authorization.
+	users map[string]string
+
+	allowedHosts []string
+	allowedPorts []int
+}
+
+// ForwardOption configures a Forward in NewForward.
+type ForwardOption func(*Forward)
+
+// WithBasicAuth requires clients to authenticate as user with password using
+// the Basic scheme (RFC 7617). It may be given once per user; realm is shown
+// to the client in Proxy-Authenticate.
+func WithBasicAuth(realm, user, password string) ForwardOption {
+	return func(f *Forward) {
+		f.realm = realm
+		f.users[user] = password
+	}
+}
+
+// WithAllowedHosts restricts the destinations to hosts. An entry starting
+// with a dot, like ".example.com", allows that domain and its subdomains.
+func WithAllowedHosts(hosts ...string) ForwardOption {
+	return func(f *Forward) {
+		f.allowedHosts = hosts
+	}
+}
+
+// WithAllowedPorts replaces the destination ports allowed by default, 80 and
+// 443.
+func WithAllowedPorts(ports ...int) ForwardOption {
+	return func(f *Forward) {
+		f.allowedPorts = ports
+	}
+}
+
+// WithInternalDestinations lets clients reach loopback, private, link-local
+// and unspecified addresses, e.g. for a proxy into a private network. Only
+// use it with WithAllowedHosts or WithBasicAuth.
+func WithInternalDestinations() ForwardOption {
+	return func(f *Forward) {
+		f.internal = true
+	}
+}
+
+// NewForward returns a Forward proxy. Connecting to a destination times out
+// after DefaultConnectTimeout and it must start its response within
+// DefaultResponseHeaderTimeout.
+func NewForward(opts ...ForwardOption) *Forward {
+	f := &Forward{
+		pseudonym:    DefaultPseudonym,
+		users:        map[string]string{},
+		allowedPorts: []int{80, 443},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	// both forwarded requests and tunnels dial through f.control, which sees
+	// the address actually connected to
+	f.dialer = &net.Dialer{Timeout: DefaultConnectTimeout, Control: f.control}
+	f.client = newClient(f.dialer, DefaultResponseHeaderTimeout)
+	return f
+}
+
+// Serve has the server.Handler signature.
+func (f *Forward) Serve(w *response.Writer, req *request.Request) {
+	if !f.authorized(req) {
+		extra := headers.NewHeaders()
+		extra.Set(headers.ProxyAuthenticateHeader, "Basic realm="+quotedString(f.realm)+`, charset="UTF-8"`)
+		writeErrorDetail(w, response.StatusCodeProxyAuthRequired, "the proxy requires credentials", extra)
+		return
+	}
+
+	if req.RequestLine.Method == request.MethodConnect {
+		f.tunnel(w, req)
+		return
+	}
+	f.forward(w, req)
+}
+
+// authorized reports whether req carries the credentials of a user, if any
+// are required.
+func (f *Forward) authorized(req *request.Request) bool {
+	if len(f.users) == 0 {
+		return true
+	}
+
+	authorization, _ := req.Headers.Get(headers.ProxyAuthorizationHeader)
+	scheme, credentials, _ := strings.Cut(authorization, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return false
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+
+	want, ok := f.users[user]
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// allowed reports whether the destination host and port may be reached.
+func (f *Forward) allowed(host string, port int) bool {
+	if !slices.Contains(f.allowedPorts, port) {
+		return false
+	}
+	if len(f.allowedHosts) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range f.allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, ".") && (host == allowed[1:] || strings.HasSuffix(host, allowed))) {
+			return true
+		}
+	}
+	return false
+}
+
+// control refuses to connect to internal addresses unless they were opened
+// up with WithInternalDestinations. It runs after name resolution, for every
+// address tried.
+func (f *Forward) control(network, address string, _ syscall.RawConn) error {
+	if f.internal {
+		return nil
+	}
+
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrorDestinationNotAllowed, address)
+	}
+	addr := addrPort.Addr().Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrorDestinationNotAllowed, address)
+	}
+	return nil
+}
+
+// dialStatus maps an error reaching a destination to the response status.
+func dialStatus(err error) (response.StatusCode, string) {
+	if errors.Is(err, ErrorDestinationNotAllowed) {
+		return response.StatusCodeForbidden, "the destination is not allowed"
+	}
+	return gatewayStatus(err)
+}
+
+// forward relays a request with an absolute-form target.
+func (f *Forward) forward(w *response.Writer, req *request.Request) {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || target.Scheme != "http" || target.Host == "" {
+		writeErrorDetail(w, response.StatusCodeBadRequest, "the target must be an absolute http URL", nil)
+		return
+	}
+
+	port := 80
+	if target.Port() != "" {
+		port, err = strconv.Atoi(target.Port())
+	}
+	if err != nil || !f.allowed(target.Hostname(), port) {
+		writeErrorDetail(w, response.StatusCodeForbidden, "the destination is not allowed", nil)
+		return
+	}
+
+	outReq, err := client.NewRequest(req.RequestLine.Method, target.String(), req.Body)
+	if err != nil {
+		log.Printf("Failed to create request for %s: %v", target.Host, err)
+		writeErrorDetail(w, response.StatusCodeBadRequest, "malformed request target", nil)
+		return
+	}
+	forwardRequest(outReq, req, f.pseudonym)
+
+	resp, err := f.client.RoundTrip(outReq)
+	if err != nil {
+		log.Printf("Failed to reach %s: %v", target.Host, err)
+
+		statusCode, detail := dialStatus(err)
+		writeErrorDetail(w, statusCode, detail, nil)
+		return
+	}
+	defer closeBody(resp)
+
+	relay(w, req, resp, f.pseudonym, false)
+}
+
+// tunnel connects to the authority-form target of a CONNECT request and
+// splices the client's connection to it.
+func (f *Forward) tunnel(w *response.Writer, req *request.Request) {
+	target := req.RequestLine.RequestTarget
+	host, portStr, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portStr)
+	if !f.allowed(host, port) {
+		writeErrorDetail(w, response.StatusCodeForbidden, "the destination is not allowed", nil)
+		return
+	}
+
+	upstream, err := f.dialer.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		log.Printf("Failed to connect to %s: %v", target, err)
+
+		statusCode, detail := dialStatus(err)
+		writeErrorDetail(w, statusCode, detail, nil)
+		return
+	}
+
+	// a 2xx answer to CONNECT has no content: the tunnel starts right after
+	// the headers
+	err = w.WriteStatusLine(response.StatusCodeOK)
+	if err == nil {
+		err = w.WriteHeaders(headers.Headers{headers.ViaHeader: "1.1 " + f.pseudonym})
+	}
+	if err != nil {
+		log.Printf("Failed to write tunnel response: %v", err)
+		closeConn(upstream)
+		return
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		log.Printf("Failed to hijack connection: %v", err)
+		closeConn(upstream)
+		return
+	}
+
+	// the client may not have waited for our answer
+	if len(buffered) > 0 {
+		_, err = upstream.Write(buffered)
+		if err != nil {
+			log.Printf("Failed to write buffered tunnel data: %v", err)
+			closeConn(conn)
+			closeConn(upstream)
+			return
+		}
+	}
+
+	splice(conn, upstream)
+}
+
+// splice copies bytes between a and b in both directions until both are
+// done, then closes them.
+func splice(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyHalf(a, b)
+	}()
+	go func() {
+		defer wg.Done()
+		copyHalf(b, a)
+	}()
+	wg.Wait()
+
+	closeConn(a)
+	closeConn(b)
+}
+
+// copyHalf copies src to dst, then tells dst that nothing more is coming.
+// Between two TCP connections the copy is done by the kernel.
+func copyHalf(dst, src net.Conn) {
+	_, err := io.Copy(dst, src)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Failed to copy tunnel data: %v", err)
+	}
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		err = cw.CloseWrite()
+	} else {
+		err = dst.Close()
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Failed to close tunnel half: %v", err)
+	}
+}
+
+func closeConn(conn net.Conn) {
+	err := conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Failed to close connection: %v", err)
+	}
+}
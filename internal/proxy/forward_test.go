@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+)
+
+func testPort(t *testing.T, addr string) int {
+	t.Helper()
+
+	_, portStr, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	return port
+}
+
+func TestForward(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Via", r.Header.Get("Via"))
+		w.Header().Set("X-Seen-Authorization", r.Header.Get("Proxy-Authorization"))
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+r.Host)
+	}))
+	defer upstream.Close()
+	u, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	f := NewForward(WithAllowedPorts(testPort(t, u.Host)), WithInternalDestinations())
+
+	// Test: Absolute-form requests are forwarded to the host they name
+	resp := serve(t, f, "GET "+upstream.URL+"/coffee?roast=dark HTTP/1.1\r\nHost: "+u.Host+"\r\nProxy-Authorization: Basic c2VjcmV0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "x-seen-via: 1.1 httpfromtcp\r\n")
+	assert.Contains(t, resp, "x-seen-authorization: \r\n")
+	assert.True(t, strings.HasSuffix(resp, "GET /coffee?roast=dark "+u.Host))
+
+	// Test: Origin-form requests are not
+	resp = serve(t, f, "GET /coffee HTTP/1.1\r\nHost: "+u.Host+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Nor are other schemes
+	resp = serve(t, f, "GET ftp://"+u.Host+"/coffee HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Ports that aren't allowed are refused
+	resp = serve(t, NewForward(), "GET "+upstream.URL+"/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: So are hosts
+	resp = serve(t, NewForward(WithAllowedPorts(testPort(t, u.Host)), WithInternalDestinations(), WithAllowedHosts("example.com")), "GET "+upstream.URL+"/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Unreachable destinations are a bad gateway
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	resp = serve(t, NewForward(WithAllowedPorts(testPort(t, dead.Listener.Addr().String())), WithInternalDestinations()), "GET "+dead.URL+"/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"))
+}
+
+func TestForwardAuth(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "welcome")
+	}))
+	defer upstream.Close()
+
+	f := NewForward(
+		WithAllowedPorts(testPort(t, upstream.Listener.Addr().String())),
+		WithInternalDestinations(),
+		WithBasicAuth("coffee", "joe", "espresso"),
+	)
+	basic := func(credentials string) string {
+		return "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)) + "\r\n"
+	}
+
+	// Test: Credentials are required
+	resp := serve(t, f, "GET "+upstream.URL+"/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 407 Proxy Authentication Required\r\n"))
+	assert.Contains(t, resp, "proxy-authenticate: Basic realm=\"coffee\", charset=\"UTF-8\"\r\n")
+
+	// Test: Wrong credentials are rejected
+	for _, header := range []string{
+		basic("joe:latte"),
+		basic("jane:espresso"),
+		basic("joe"),
+		"Proxy-Authorization: Bearer joe:espresso\r\n",
+		"Proxy-Authorization: Basic !!!\r\n",
+	} {
+		resp = serve(t, f, "GET "+upstream.URL+"/ HTTP/1.1\r\n"+header+"\r\n")
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 407 Proxy Authentication Required\r\n"), header)
+	}
+
+	// Test: Right ones are accepted
+	resp = serve(t, f, "GET "+upstream.URL+"/ HTTP/1.1\r\n"+basic("joe:espresso")+"\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "welcome"))
+
+	// Test: CONNECT too
+	resp = serve(t, f, "CONNECT "+upstream.Listener.Addr().String()+" HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 407 Proxy Authentication Required\r\n"))
+}
+
+func TestForwardTunnel(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	f := NewForward(WithAllowedPorts(testPort(t, echo.Addr().String())), WithInternalDestinations())
+	srv, err := server.Serve(f.Serve, 0)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: The tunnel carries bytes both ways once established
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "CONNECT "+echo.Addr().String()+" HTTP/1.1\r\nHost: "+echo.Addr().String()+"\r\n\r\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1.1 httpfromtcp", resp.Header.Get("Via"))
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	// Test: Closing our half ends the tunnel
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: Destinations that aren't allowed are refused
+	resp2 := serve(t, NewForward(), "CONNECT "+echo.Addr().String()+" HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp2, "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+func TestForwardInternal(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "internal")
+	}))
+	defer upstream.Close()
+	port := testPort(t, upstream.Listener.Addr().String())
+	f := NewForward(WithAllowedPorts(port))
+
+	// Test: Loopback destinations are refused by default
+	resp := serve(t, f, "GET "+upstream.URL+"/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Whatever name they are reached by, since the resolved address is checked
+	resp = serve(t, f, "GET http://localhost:"+strconv.Itoa(port)+"/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Tunnels too
+	resp = serve(t, f, "CONNECT localhost:"+strconv.Itoa(port)+" HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Unless internal destinations are opened up
+	resp = serve(t, NewForward(WithAllowedPorts(port), WithInternalDestinations()), "GET "+upstream.URL+"/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "internal"))
+}
+
+func TestForwardControl(t *testing.T) {
+	f := NewForward()
+
+	for _, address := range []string{
+		"127.0.0.1:80", "[::1]:80", "10.0.0.1:80", "172.16.0.1:443", "192.168.1.1:443",
+		"169.254.169.254:80", "[fe80::1]:80", "0.0.0.0:80", "[::]:80", "[::ffff:127.0.0.1]:80", "[fd00::1]:443",
+	} {
+		assert.ErrorIs(t, f.control("tcp", address, nil), ErrorDestinationNotAllowed, address)
+	}
+	assert.NoError(t, f.control("tcp", "93.184.215.14:443", nil))
+	assert.NoError(t, f.control("tcp", "[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:443", nil))
+
+	assert.NoError(t, NewForward(WithInternalDestinations()).control("tcp", "127.0.0.1:80", nil))
+}
+
+func TestForwardAllowed(t *testing.T) {
+	f := NewForward(WithAllowedHosts("example.com", ".example.org"))
+
+	assert.True(t, f.allowed("example.com", 443))
+	assert.True(t, f.allowed("EXAMPLE.com.", 80))
+	assert.False(t, f.allowed("example.com", 22))
+	assert.False(t, f.allowed("www.example.com", 443))
+	assert.True(t, f.allowed("example.org", 443))
+	assert.True(t, f.allowed("www.example.org", 443))
+	assert.False(t, f.allowed("badexample.org", 443))
+	assert.False(t, f.allowed("localhost", 443))
+}
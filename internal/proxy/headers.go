@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+// hopByHopHeaders only apply to a single connection and are never forwarded
+// (RFC 9110 section 7.6.1).
+var hopByHopHeaders = []string{
+	headers.ConnectionHeader,
+	headers.KeepAliveHeader,
+	headers.ProxyAuthenticateHeader,
+	headers.ProxyAuthorizationHeader,
+	headers.ProxyConnectionHeader,
+	headers.TEHeader,
+	headers.TrailerHeader,
+	headers.TransferEncodingHeader,
+	headers.UpgradeHeader,
+}
+
+// isHopByHop reports whether key must not be forwarded, either because it is
+// always hop-by-hop or because connection, the Connection header of the same
+// message, lists it.
+func isHopByHop(key, connection string) bool {
+	for _, name := range hopByHopHeaders {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	for _, name := range headers.SplitList(connection) {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardRequestHeaders copies the end-to-end fields of h to out.
+func forwardRequestHeaders(out, h headers.Headers) {
+	connection, _ := h.Get(headers.ConnectionHeader)
+	for k, v := range h {
+		if isHopByHop(k, connection) || k == headers.HostHeader || k == headers.ContentLengthHeader {
+			continue
+		}
+		out.Set(k, v)
+	}
+}
+
+// forwardResponseHeaders copies the end-to-end fields of in to h.
+func forwardResponseHeaders(h, in headers.Headers) {
+	connection, _ := in.Get(headers.ConnectionHeader)
+	for k, v := range in {
+		if isHopByHop(k, connection) || k == headers.ContentLengthHeader {
+			continue
+		}
+		h.Set(k, v)
+	}
+}
+
+// clientIP returns the host part of a "host:port" remote address.
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// forwardedElement returns the Forwarded element (RFC 7239) describing the
+// hop from the client to us.
+func forwardedElement(ip, host string) string {
+	var b strings.Builder
+
+	if ip != "" {
+		b.WriteString("for=")
+		b.WriteString(forwardedNode(ip))
+	}
+	if host != "" {
+		if b.Len() > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString("host=")
+		b.WriteString(quoteForwarded(host))
+	}
+	if b.Len() > 0 {
+		b.WriteByte(';')
+	}
+	b.WriteString("proto=http")
+
+	return b.String()
+}
+
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		// IPv6 addresses are bracketed, and then need quoting
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteForwarded quotes value unless it is a token.
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return quotedString(value)
+		}
+	}
+	return value
+}
+
+// quotedString returns value as an HTTP quoted-string.
+func quotedString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func isTokenChar(c rune) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+	}
+}
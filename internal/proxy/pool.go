@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/client"
+	"github.com/itsjoeoui/httpfromtcp/internal/clock"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+)
+
+const (
+	// DefaultMaxFailures is how many failures in a row open the circuit of
+	// a backend.
+	DefaultMaxFailures = 3
+	// DefaultOpenDuration is how long an open circuit refuses requests
+	// before it lets a trial through.
+	DefaultOpenDuration = 30 * time.Second
+	// DefaultHealthCheckTimeout bounds a single active health check.
+	DefaultHealthCheckTimeout = 5 * time.Second
+)
+
+// maxWeight is the weight of a backend that is fully warmed up.
+const maxWeight = 100
+
+// upstream is one backend server of a Pool.
+type upstream struct {
+	url *url.URL
+
+	// connectTimeout and responseTimeout, if set, replace those of the
+	// Proxy for this backend.
+	connectTimeout  time.Duration
+	responseTimeout time.Duration
+
+	// the fields below are guarded by Pool.mu
+
+	// healthy is cleared by a failed active health check and set again by
+	// a successful one.
+	healthy bool
+	// circuit takes the backend out after requests to it failed.
+	circuit circuit
+	// recoveredAt is when the backend last became available again, for slow
+	// start.
+	recoveredAt time.Time
+	// active counts requests in flight.
+	active int
+	// currentWeight is the smooth weighted round-robin state.
+	currentWeight int
+}
+
+// Pool spreads requests over several backends.
+//
+// Backends leave the rotation when their circuit breaker opens, which it does
+// after DefaultMaxFailures failed requests in a row. After DefaultOpenDuration
+// a single trial request decides whether it closes again. When active health
+// checks are enabled, a backend also sits out from a failed check until one
+// succeeds. With slow start, a backend that comes back gets a growing share of
+// the traffic instead of all of it at once.
+type Pool struct {
+	mu       sync.Mutex
+	backends []*upstream
+	strategy Strategy
+	clock    clock.Clock
+
+	maxFailures int
+	openFor     time.Duration
+	slowStart   time.Duration
+
+	healthPath     string
+	healthInterval time.Duration
+	healthClient   *client.Client
+	stop           chan struct{}
+	stopOnce       sync.Once
+
+	// timeouts holds the WithTargetTimeouts by target.
+	timeouts map[string]timeouts
+}
+
+// timeouts bound connecting to a backend and waiting for its response
+// headers.
+type timeouts struct {
+	connect  time.Duration
+	response time.Duration
+}
+
+// PoolOption configures a Pool in NewPool.
+type PoolOption func(*Pool)
+
+// WithStrategy replaces the default RoundRobin strategy.
+func WithStrategy(s Strategy) PoolOption {
+	return func(p *Pool) {
+		p.strategy = s
+	}
+}
+
+// WithCircuitBreaker opens the circuit of a backend for openFor after
+// maxFailures failed requests in a row. A request fails if the backend can't
+// be reached or answers 502, 503 or 504. Zero maxFailures disables the
+// breaker.
+func WithCircuitBreaker(maxFailures int, openFor time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.maxFailures = maxFailures
+		p.openFor = openFor
+	}
+}
+
+// WithHealthCheck sends GET requests for path to every backend each interval,
+// taking it out of the rotation while the answer isn't 2xx or 3xx.
+func WithHealthCheck(path string, interval time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.healthPath = path
+		p.healthInterval = interval
+	}
+}
+
+// WithTargetTimeouts gives target, one of the targets passed to NewPool, its
+// own connect and response header timeouts instead of those of the Proxy, e.g.
+// for a backend that is known to be slow. A zero duration keeps the Proxy's.
+func WithTargetTimeouts(target string, connect, response time.Duration) PoolOption {
+	return func(p *Pool) {
+		if p.timeouts == nil {
+			p.timeouts = map[string]timeouts{}
+		}
+		p.timeouts[target] = timeouts{connect: connect, response: response}
+	}
+}
+
+// WithSlowStart ramps the traffic share of a recovered backend up linearly
+// over d. Consistent hashing ignores it.
+func WithSlowStart(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.slowStart = d
+	}
+}
+
+// WithPoolClock replaces the system clock used for ejection and slow start.
+func WithPoolClock(c clock.Clock) PoolOption {
+	return func(p *Pool) {
+		p.clock = c
+	}
+}
+
+// NewPool returns a pool of targets, absolute http or https URLs like those
+// New takes. Health checks, if enabled, start right away; Close stops them.
+func NewPool(targets []string, opts ...PoolOption) (*Pool, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w: no targets", ErrorInvalidTarget)
+	}
+
+	p := &Pool{
+		strategy:    RoundRobin(),
+		clock:       clock.System{},
+		maxFailures: DefaultMaxFailures,
+		openFor:     DefaultOpenDuration,
+		healthClient: client.New(
+			client.WithTimeout(DefaultHealthCheckTimeout),
+			client.WithMaxRedirects(0),
+		),
+		stop: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	for _, target := range targets {
+		u, err := parseTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := p.timeouts[target]
+		if ok {
+			delete(p.timeouts, target)
+		}
+		p.backends = append(p.backends, &upstream{
+			url:             u,
+			healthy:         true,
+			connectTimeout:  t.connect,
+			responseTimeout: t.response,
+		})
+	}
+	for target := range p.timeouts {
+		return nil, fmt.Errorf("%w: timeouts for %s, which is not a target", ErrorInvalidTarget, target)
+	}
+
+	if p.healthInterval > 0 {
+		go p.runHealthChecks()
+	}
+	return p, nil
+}
+
+// Close stops the health checks.
+func (p *Pool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		p.healthClient.CloseIdleConnections()
+	})
+}
+
+// acquire picks the backend for req and counts the request as in flight. If
+// no backend is available it returns nil and how long it will probably take
+// until one is.
+func (p *Pool) acquire(req *request.Request) (*upstream, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+
+	candidates := make([]candidate, 0, len(p.backends))
+	for _, b := range p.backends {
+		if !b.healthy || !b.circuit.available(now) {
+			continue
+		}
+		candidates = append(candidates, candidate{backend: b, weight: p.weight(b, now)})
+	}
+	if len(candidates) == 0 {
+		return nil, p.retryAfter(now)
+	}
+
+	b := p.strategy.pick(p.backends, candidates, req)
+	b.circuit.admit()
+	b.active++
+	return b, 0
+}
+
+// retryAfter estimates when the first backend becomes available again: when
+// its circuit half opens, or at the next health check.
+func (p *Pool) retryAfter(now time.Time) time.Duration {
+	wait := p.healthInterval
+	for _, b := range p.backends {
+		if !b.healthy || !b.circuit.open {
+			continue
+		}
+
+		until := max(b.circuit.openUntil.Sub(now), 0)
+		if wait == 0 || until < wait {
+			wait = until
+		}
+	}
+	return wait
+}
+
+// release records the outcome of a request acquire handed to b.
+func (p *Pool) release(b *upstream, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b.active--
+	now := p.clock.Now()
+
+	if !failed {
+		if b.circuit.succeeded() {
+			log.Printf("Closing the circuit of upstream %s", b.url.Host)
+			b.recoveredAt = now
+		}
+		return
+	}
+
+	if b.circuit.failed(now, p.maxFailures, p.openFor) {
+		log.Printf("Opening the circuit of upstream %s for %s", b.url.Host, p.openFor)
+	}
+}
+
+// weight returns how much traffic b should get, from 1 right after it
+// recovered to maxWeight once slow start is over.
+func (p *Pool) weight(b *upstream, now time.Time) int {
+	if p.slowStart <= 0 || b.recoveredAt.IsZero() {
+		return maxWeight
+	}
+
+	elapsed := now.Sub(b.recoveredAt)
+	if elapsed >= p.slowStart {
+		return maxWeight
+	}
+	return max(1, int(maxWeight*elapsed/p.slowStart))
+}
+
+func (p *Pool) runHealthChecks() {
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.checkHealth()
+		}
+	}
+}
+
+// checkHealth checks every backend once, concurrently.
+func (p *Pool) checkHealth() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			healthy := p.probe(b)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			switch {
+			case healthy && !b.healthy:
+				log.Printf("Upstream %s passed its health check", b.url.Host)
+				b.recoveredAt = p.clock.Now()
+			case !healthy && b.healthy:
+				log.Printf("Upstream %s failed its health check", b.url.Host)
+			}
+			b.healthy = healthy
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) probe(b *upstream) bool {
+	u := *b.url
+	u.Path = joinPath(b.url.Path, p.healthPath)
+	u.RawQuery = ""
+
+	resp, err := p.healthClient.Get(u.String())
+	if err != nil {
+		return false
+	}
+
+	statusCode := resp.StatusLine.StatusCode
+	return statusCode >= 200 && statusCode < 400
+}
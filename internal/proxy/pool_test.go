@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/clock"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, n int, opts ...PoolOption) *Pool {
+	t.Helper()
+
+	var targets []string
+	for i := range n {
+		targets = append(targets, "http://backend"+strconv.Itoa(i)+".test")
+	}
+
+	pool, err := NewPool(targets, opts...)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func newPoolRequest(key string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/"},
+		Headers:     headers.NewHeaders(),
+		RemoteAddr:  "192.0.2.1:5000",
+	}
+	if key != "" {
+		req.Headers.Set("x-user", key)
+	}
+	return req
+}
+
+// pick acquires and immediately releases a backend, returning its host.
+func pick(pool *Pool, req *request.Request) string {
+	b, _ := pool.acquire(req)
+	if b == nil {
+		return ""
+	}
+	pool.release(b, false)
+	return b.url.Host
+}
+
+func TestNewPool(t *testing.T) {
+	_, err := NewPool(nil)
+	assert.ErrorIs(t, err, ErrorInvalidTarget)
+
+	_, err = NewPool([]string{"http://ok.test", "nope"})
+	assert.ErrorIs(t, err, ErrorInvalidTarget)
+
+	// Test: Timeouts are only given to targets of the pool
+	_, err = NewPool([]string{"http://ok.test"}, WithTargetTimeouts("http://other.test", time.Second, time.Second))
+	assert.ErrorIs(t, err, ErrorInvalidTarget)
+	pool, err := NewPool([]string{"http://ok.test"}, WithTargetTimeouts("http://ok.test", time.Second, 2*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, pool.backends[0].connectTimeout)
+	assert.Equal(t, 2*time.Second, pool.backends[0].responseTimeout)
+}
+
+func TestRoundRobin(t *testing.T) {
+	pool := newTestPool(t, 3)
+
+	var got []string
+	for range 6 {
+		got = append(got, pick(pool, newPoolRequest("")))
+	}
+	assert.Equal(t, []string{
+		"backend0.test", "backend1.test", "backend2.test",
+		"backend0.test", "backend1.test", "backend2.test",
+	}, got)
+}
+
+func TestLeastConnections(t *testing.T) {
+	pool := newTestPool(t, 3, WithStrategy(LeastConnections()))
+
+	// Test: Busy backends are avoided
+	first, _ := pool.acquire(newPoolRequest(""))
+	second, _ := pool.acquire(newPoolRequest(""))
+	assert.NotEqual(t, first, second)
+
+	third, _ := pool.acquire(newPoolRequest(""))
+	assert.NotEqual(t, first, third)
+	assert.NotEqual(t, second, third)
+
+	// Test: The first one to finish gets the next request
+	pool.release(second, false)
+	assert.Equal(t, second.url.Host, pick(pool, newPoolRequest("")))
+}
+
+func TestConsistentHash(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	pool := newTestPool(t, 4, WithStrategy(ConsistentHash("x-user")), WithPoolClock(fake), WithCircuitBreaker(1, time.Minute))
+
+	// Test: The same key always lands on the same backend
+	owners := map[string]string{}
+	for i := range 200 {
+		key := "user" + strconv.Itoa(i)
+		owners[key] = pick(pool, newPoolRequest(key))
+		assert.Equal(t, owners[key], pick(pool, newPoolRequest(key)))
+	}
+
+	// Test: Keys are spread over all backends
+	counts := map[string]int{}
+	for _, owner := range owners {
+		counts[owner]++
+	}
+	assert.Len(t, counts, 4)
+
+	// Test: Without the header the client address is the key
+	assert.Equal(t, pick(pool, newPoolRequest("")), pick(pool, newPoolRequest("")))
+
+	// Test: Only the keys of a backend with an open circuit move
+	ejected := pool.backends[0]
+	ejected.active++
+	pool.release(ejected, true)
+	for key, owner := range owners {
+		got := pick(pool, newPoolRequest(key))
+		if owner == ejected.url.Host {
+			assert.NotEqual(t, owner, got)
+		} else {
+			assert.Equal(t, owner, got)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	pool := newTestPool(t, 2, WithPoolClock(fake), WithCircuitBreaker(2, time.Minute), WithSlowStart(10*time.Second))
+	bad, good := pool.backends[0], pool.backends[1]
+
+	fail := func() {
+		bad.active++
+		pool.release(bad, true)
+	}
+
+	// Test: A success resets the count
+	fail()
+	bad.active++
+	pool.release(bad, false)
+	fail()
+	assert.Equal(t, 1, bad.circuit.failures)
+
+	// Test: Failures in a row open the circuit
+	fail()
+	for range 4 {
+		assert.Equal(t, "backend1.test", pick(pool, newPoolRequest("")))
+	}
+
+	// Test: Nothing to pick when all are out, with an estimate of when
+	// that changes
+	good.healthy = false
+	fake.Advance(20 * time.Second)
+	b, retryAfter := pool.acquire(newPoolRequest(""))
+	assert.Nil(t, b)
+	assert.Equal(t, 40*time.Second, retryAfter)
+
+	// Test: Once half open, a single trial goes through
+	fake.Advance(40 * time.Second)
+	trial, _ := pool.acquire(newPoolRequest(""))
+	assert.Equal(t, bad, trial)
+	b, _ = pool.acquire(newPoolRequest(""))
+	assert.Nil(t, b)
+
+	// Test: A failed trial opens it again
+	pool.release(trial, true)
+	b, retryAfter = pool.acquire(newPoolRequest(""))
+	assert.Nil(t, b)
+	assert.Equal(t, time.Minute, retryAfter)
+
+	// Test: A successful one closes it
+	fake.Advance(time.Minute)
+	trial, _ = pool.acquire(newPoolRequest(""))
+	require.Equal(t, bad, trial)
+	pool.release(trial, false)
+	assert.Equal(t, "backend0.test", pick(pool, newPoolRequest("")))
+	good.healthy = true
+
+	// Test: The backend comes back with a small share of the traffic
+	fake.Advance(time.Second)
+	counts := map[string]int{}
+	for range 100 {
+		counts[pick(pool, newPoolRequest(""))]++
+	}
+	// a tenth of the weight of its peer after a tenth of slow start
+	assert.Equal(t, 9, counts["backend0.test"])
+	assert.Equal(t, 91, counts["backend1.test"])
+
+	// Test: And a fair one once slow start is over
+	fake.Advance(10 * time.Second)
+	counts = map[string]int{}
+	for range 100 {
+		counts[pick(pool, newPoolRequest(""))]++
+	}
+	assert.Equal(t, 50, counts["backend0.test"])
+}
+
+func TestHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	var paths atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths.Store(r.URL.Path)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+
+	pool, err := NewPool([]string{upstream.URL + "/base"}, WithHealthCheck("/healthz", time.Hour))
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// Test: A failing check takes the backend out
+	pool.checkHealth()
+	assert.Equal(t, "/base/healthz", paths.Load())
+	b, retryAfter := pool.acquire(newPoolRequest(""))
+	assert.Nil(t, b)
+	assert.Equal(t, time.Hour, retryAfter)
+
+	// Test: A passing one brings it back
+	healthy.Store(true)
+	pool.checkHealth()
+	b, _ = pool.acquire(newPoolRequest(""))
+	assert.NotNil(t, b)
+	assert.False(t, pool.backends[0].recoveredAt.IsZero())
+
+	// Test: Checks run on their own
+	healthy.Store(false)
+	ticking, err := NewPool([]string{upstream.URL}, WithHealthCheck("/", 10*time.Millisecond))
+	require.NoError(t, err)
+	defer ticking.Close()
+	require.Eventually(t, func() bool {
+		b, _ := ticking.acquire(newPoolRequest(""))
+		return b == nil
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestProxyPool(t *testing.T) {
+	var hits [2]atomic.Int32
+	var upstreams []string
+	for i := range hits {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			_, _ = w.Write([]byte("backend " + strconv.Itoa(i)))
+		}))
+		defer upstream.Close()
+		upstreams = append(upstreams, upstream.URL)
+	}
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	pool, err := NewPool(append(upstreams, dead.URL), WithCircuitBreaker(1, time.Minute))
+	require.NoError(t, err)
+	defer pool.Close()
+	p := NewFromPool(pool)
+
+	// Test: Requests are spread, and the dead backend is ejected after its
+	// first failure
+	var failures int
+	for range 9 {
+		resp := serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+		if strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n") {
+			failures++
+		}
+	}
+	assert.Equal(t, 1, failures)
+	assert.Equal(t, int32(4), hits[0].Load())
+	assert.Equal(t, int32(4), hits[1].Load())
+
+	// Test: 503 when nothing is left
+	for _, b := range pool.backends {
+		b.healthy = false
+	}
+	resp := serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+}
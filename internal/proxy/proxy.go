@@ -0,0 +1,480 @@
+// Package proxy forwards requests to upstream servers.
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/client"
+	"github.com/itsjoeoui/httpfromtcp/internal/digest"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// DefaultPseudonym identifies the proxy in Via headers.
+const DefaultPseudonym = "httpfromtcp"
+
+const (
+	// DefaultConnectTimeout is how long connecting to an upstream may take.
+	DefaultConnectTimeout = 10 * time.Second
+	// DefaultResponseHeaderTimeout is how long the upstream may take to
+	// start its response before the client gets 504.
+	DefaultResponseHeaderTimeout = 30 * time.Second
+)
+
+// maxBackoff caps the wait between retries.
+const maxBackoff = 2 * time.Second
+
+// Proxy is a reverse proxy: it forwards every request to an upstream and
+// relays the response.
+//
+// The method, end-to-end headers and body are forwarded as is, with
+// X-Forwarded-For, Forwarded and Via describing the extra hop. The upstream
+// status, headers and body come back the same way. When the upstream can't be
+// reached the client gets 502, or 504 if it took too long, and 503 with
+// Retry-After when every backend of the pool is out of rotation.
+type Proxy struct {
+	pool         *Pool
+	stripPrefix  string
+	preserveHost bool
+	pseudonym    string
+	digests      bool
+
+	// client, set with WithClient, reaches every backend. Otherwise clients
+	// holds one per backend, with its timeouts.
+	client          *client.Client
+	clients         map[*upstream]*client.Client
+	connectTimeout  time.Duration
+	responseTimeout time.Duration
+
+	retries int
+	backoff time.Duration
+}
+
+// Option configures a Proxy in New.
+type Option func(*Proxy)
+
+// WithStripPrefix removes prefix from request paths before they are appended
+// to the target's path, for proxies mounted below "/".
+func WithStripPrefix(prefix string) Option {
+	return func(p *Proxy) {
+		p.stripPrefix = prefix
+	}
+}
+
+// WithTimeouts replaces DefaultConnectTimeout and
+// DefaultResponseHeaderTimeout for the upstreams without timeouts of their
+// own, which WithTargetTimeouts gives them. It has no effect together with
+// WithClient.
+func WithTimeouts(connect, response time.Duration) Option {
+	return func(p *Proxy) {
+		p.connectTimeout = connect
+		p.responseTimeout = response
+	}
+}
+
+// WithRetries retries requests with an idempotent method up to n times when
+// the upstream can't be reached or answers 502, 503 or 504, each time with a
+// backend picked anew. The wait before retry i is random, up to backoff
+// doubled i times, so that clients failing together don't retry together.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(p *Proxy) {
+		p.retries = n
+		p.backoff = backoff
+	}
+}
+
+// WithClient replaces the clients used to reach the upstreams, which then
+// share it. Its timeout bounds the wait for the response headers, and its
+// body size limit applies to relayed bodies.
+func WithClient(c *client.Client) Option {
+	return func(p *Proxy) {
+		p.client = c
+	}
+}
+
+// WithPreserveHost forwards the client's Host header instead of the target's.
+func WithPreserveHost() Option {
+	return func(p *Proxy) {
+		p.preserveHost = true
+	}
+}
+
+// WithPseudonym replaces DefaultPseudonym in Via headers.
+func WithPseudonym(name string) Option {
+	return func(p *Proxy) {
+		p.pseudonym = name
+	}
+}
+
+// WithContentDigest adds Content-Digest and X-Content-Length trailers to
+// responses the upstream streams without a length, computed with the
+// algorithm the client prefers in Want-Content-Digest.
+func WithContentDigest() Option {
+	return func(p *Proxy) {
+		p.digests = true
+	}
+}
+
+// New returns a Proxy for target, an absolute http or https URL whose path,
+// if any, is prepended to every request path.
+func New(target string, opts ...Option) (*Proxy, error) {
+	pool, err := NewPool([]string{target})
+	if err != nil {
+		return nil, err
+	}
+	return NewFromPool(pool, opts...), nil
+}
+
+// NewFromPool returns a Proxy that balances requests over the backends of
+// pool.
+func NewFromPool(pool *Pool, opts ...Option) *Proxy {
+	p := &Proxy{
+		pool:            pool,
+		pseudonym:       DefaultPseudonym,
+		connectTimeout:  DefaultConnectTimeout,
+		responseTimeout: DefaultResponseHeaderTimeout,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.client == nil {
+		p.clients = make(map[*upstream]*client.Client, len(pool.backends))
+		for _, b := range pool.backends {
+			connect := cmp.Or(b.connectTimeout, p.connectTimeout)
+			response := cmp.Or(b.responseTimeout, p.responseTimeout)
+			p.clients[b] = newClient(&net.Dialer{Timeout: connect}, response)
+		}
+	}
+	return p
+}
+
+// clientFor returns the client that reaches backend.
+func (p *Proxy) clientFor(backend *upstream) *client.Client {
+	if p.client != nil {
+		return p.client
+	}
+	return p.clients[backend]
+}
+
+// newClient returns a client for relaying: responses can be of any size and
+// are waited for up to responseTimeout.
+func newClient(dialer *net.Dialer, responseTimeout time.Duration) *client.Client {
+	return client.New(
+		client.WithDialer(dialer),
+		client.WithTimeout(responseTimeout),
+		client.WithMaxBodySize(0),
+	)
+}
+
+func parseTarget(target string) (*url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidTarget, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidTarget, target)
+	}
+	return u, nil
+}
+
+// Serve has the server.Handler signature.
+func (p *Proxy) Serve(w *response.Writer, req *request.Request) {
+	ref, err := url.ParseRequestURI(req.RequestLine.RequestTarget)
+	if err != nil {
+		log.Printf("Failed to parse request target: %v", err)
+		writeErrorDetail(w, response.StatusCodeBadRequest, "malformed request target", nil)
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		backend, retryAfter := p.pool.acquire(req)
+		if backend == nil {
+			log.Printf("No upstream available for %s", req.RequestLine.RequestTarget)
+
+			extra := headers.NewHeaders()
+			extra.Set(headers.RetryAfterHeader, strconv.Itoa(retryAfterSeconds(retryAfter)))
+			writeErrorDetail(w, response.StatusCodeServiceUnavailable, "no upstream is available", extra)
+			return
+		}
+
+		resp, err := p.roundTrip(backend, ref, req)
+		failed := err != nil || isGatewayError(resp.StatusLine.StatusCode)
+
+		if failed && attempt < p.retries && isIdempotent(req.RequestLine.Method) {
+			if err != nil {
+				log.Printf("Retrying after upstream %s failed: %v", backend.url.Host, err)
+			} else {
+				log.Printf("Retrying after upstream %s answered %d", backend.url.Host, resp.StatusLine.StatusCode)
+				closeBody(resp)
+			}
+			p.pool.release(backend, true)
+			time.Sleep(p.backoffFor(attempt))
+			continue
+		}
+
+		p.respond(w, req, backend, resp, err)
+		p.pool.release(backend, failed)
+		return
+	}
+}
+
+// respond writes the outcome of the last attempt.
+func (p *Proxy) respond(w *response.Writer, req *request.Request, backend *upstream, resp *client.Stream, err error) {
+	if err != nil {
+		log.Printf("Failed to reach upstream %s: %v", backend.url.Host, err)
+
+		statusCode, detail := gatewayStatus(err)
+		writeErrorDetail(w, statusCode, detail, nil)
+		return
+	}
+	defer closeBody(resp)
+
+	relay(w, req, resp, p.pseudonym, p.digests)
+}
+
+func (p *Proxy) roundTrip(backend *upstream, ref *url.URL, req *request.Request) (*client.Stream, error) {
+	outReq, err := p.newRequest(backend, ref, req)
+	if err != nil {
+		return nil, err
+	}
+	return p.clientFor(backend).RoundTrip(outReq)
+}
+
+// backoffFor returns a random wait before retry attempt+1, with "full
+// jitter": anywhere between zero and the exponential backoff.
+func (p *Proxy) backoffFor(attempt int) time.Duration {
+	ceiling := min(p.backoff<<attempt, maxBackoff)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// isIdempotent reports whether sending a request with method twice has the
+// same effect as sending it once (RFC 9110 section 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case request.MethodGet, request.MethodHead, request.MethodOptions, request.MethodPut, request.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfterSeconds rounds d up to whole seconds, at least one.
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int((d+time.Second-1)/time.Second))
+}
+
+func closeBody(resp *client.Stream) {
+	err := resp.Close()
+	if err != nil {
+		log.Printf("Failed to close upstream response body: %v", err)
+	}
+}
+
+func (p *Proxy) newRequest(backend *upstream, ref *url.URL, req *request.Request) (*request.Request, error) {
+	target := p.upstreamURL(backend.url, ref)
+
+	outReq, err := client.NewRequest(req.RequestLine.Method, target.String(), req.Body)
+	if err != nil {
+		return nil, err
+	}
+	forwardRequest(outReq, req, p.pseudonym)
+
+	host, _ := req.Headers.Get(headers.HostHeader)
+	if p.preserveHost && host != "" {
+		outReq.Headers.Override(headers.HostHeader, host)
+	}
+
+	return outReq, nil
+}
+
+// forwardRequest copies the end-to-end headers of req to outReq and adds the
+// ones describing the hop through the proxy called pseudonym.
+func forwardRequest(outReq *request.Request, req *request.Request, pseudonym string) {
+	forwardRequestHeaders(outReq.Headers, req.Headers)
+
+	host, _ := req.Headers.Get(headers.HostHeader)
+	ip := clientIP(req.RemoteAddr)
+	if ip != "" {
+		outReq.Headers.Set(headers.XForwardedForHeader, ip)
+	}
+	outReq.Headers.Set(headers.ForwardedHeader, forwardedElement(ip, host))
+	outReq.Headers.Set(headers.ViaHeader, "1.1 "+pseudonym)
+}
+
+// upstreamURL maps the parsed request target ref onto the URL of a backend.
+func (p *Proxy) upstreamURL(base, ref *url.URL) *url.URL {
+	u := *base
+	u.Path = joinPath(base.Path, strings.TrimPrefix(ref.Path, p.stripPrefix))
+	u.RawPath = ""
+	switch {
+	case base.RawQuery == "":
+		u.RawQuery = ref.RawQuery
+	case ref.RawQuery != "":
+		u.RawQuery = base.RawQuery + "&" + ref.RawQuery
+	}
+	return &u
+}
+
+// joinPath appends path to the path of a base URL.
+func joinPath(base, path string) string {
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+// relay writes the upstream response to w, with a Via header for the proxy
+// called pseudonym. digests enables WithContentDigest.
+func relay(w *response.Writer, req *request.Request, resp *client.Stream, pseudonym string, digests bool) {
+	statusCode := resp.StatusLine.StatusCode
+
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		log.Printf("Failed to write status line: %v", err)
+	}
+
+	h := headers.NewHeaders()
+	forwardResponseHeaders(h, resp.Headers)
+	for _, v := range resp.SetCookies {
+		err = w.AddSetCookie(v)
+		if err != nil {
+			log.Printf("Failed to relay Set-Cookie: %v", err)
+		}
+	}
+	h.Set(headers.ViaHeader, resp.StatusLine.HTTPVersion+" "+pseudonym)
+	h.Override(headers.ConnectionHeader, "close")
+
+	chunked := false
+	contentLength, hasLength := resp.Headers.Get(headers.ContentLengthHeader)
+	_, hasEncoding := resp.Headers.Get(headers.TransferEncodingHeader)
+	switch {
+	case hasLength && !hasEncoding:
+		h.Override(headers.ContentLengthHeader, contentLength)
+	case statusCode.AllowsBody() && req.RequestLine.Method != request.MethodHead:
+		h.Override(headers.TransferEncodingHeader, "chunked")
+		chunked = true
+	}
+
+	var d *digest.Digester
+	if chunked {
+		d = declareTrailers(w, req, resp, digests)
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Failed to write headers: %v", err)
+		return
+	}
+	if !statusCode.AllowsBody() {
+		return
+	}
+
+	var n int64
+	if d != nil {
+		n, err = io.Copy(io.MultiWriter(w, d), resp)
+	} else {
+		n, err = w.ReadFrom(resp)
+	}
+	if err != nil {
+		log.Printf("Failed to relay upstream response body: %v", err)
+		return
+	}
+	if !chunked {
+		return
+	}
+
+	_, err = w.WriteChunkedBodyDone()
+	if err != nil {
+		log.Printf("Failed to write chunked body done: %v", err)
+	}
+
+	// the upstream trailers are only known once its body was read
+	for k, v := range resp.Trailers {
+		err = w.SetTrailer(k, v)
+		if err != nil && !errors.Is(err, response.ErrorTrailersNotAccepted) {
+			log.Printf("Failed to set trailer: %v", err)
+		}
+	}
+	if d != nil {
+		_ = w.SetTrailer(headers.ContentDigestHeader, d.Value())
+		_ = w.SetTrailer(headers.XContentLengthHeader, strconv.FormatInt(n, 10))
+	}
+
+	err = w.WriteTrailers(nil)
+	if err != nil {
+		log.Printf("Failed to write trailers: %v", err)
+	}
+}
+
+// declareTrailers announces the upstream's trailers and, if digests is set and
+// the upstream doesn't send its own, the digest trailers. It returns the
+// digester for the latter, or nil.
+func declareTrailers(w *response.Writer, req *request.Request, resp *client.Stream, digests bool) *digest.Digester {
+	upstreamDigest := false
+	trailer, _ := resp.Headers.Get(headers.TrailerHeader)
+	for _, k := range headers.SplitList(trailer) {
+		upstreamDigest = upstreamDigest || strings.EqualFold(k, headers.ContentDigestHeader)
+
+		err := w.DeclareTrailers(k)
+		if err != nil && !errors.Is(err, response.ErrorTrailersNotAccepted) {
+			log.Printf("Failed to declare upstream trailer: %v", err)
+		}
+	}
+
+	if !digests || upstreamDigest {
+		return nil
+	}
+
+	want, _ := req.Headers.Get(headers.WantContentDigestHeader)
+	algs := digest.Negotiate(want)
+
+	// digests are only worth computing if the client will receive them
+	if len(algs) == 0 || w.DeclareTrailers(headers.ContentDigestHeader, headers.XContentLengthHeader) != nil {
+		return nil
+	}
+	return digest.New(algs...)
+}
+
+// isGatewayError reports whether an upstream answered with a status code
+// that says it, or something behind it, is in trouble.
+func isGatewayError(statusCode response.StatusCode) bool {
+	switch statusCode {
+	case response.StatusCodeBadGateway, response.StatusCodeServiceUnavailable, response.StatusCodeGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// gatewayStatus picks the status code, and the detail to show, for an
+// upstream that failed with err.
+func gatewayStatus(err error) (response.StatusCode, string) {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return response.StatusCodeGatewayTimeout, "the upstream did not answer in time"
+	}
+	return response.StatusCodeBadGateway, "the upstream could not be reached"
+}
+
+func writeErrorDetail(w *response.Writer, statusCode response.StatusCode, detail string, extra headers.Headers) {
+	err := w.WriteErrorDetail(statusCode, detail, extra)
+	if err != nil {
+		log.Printf("Failed to write %d response: %v", statusCode, err)
+	}
+}
@@ -0,0 +1,340 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/client"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs p for raw, which is parsed as if the server had read it from
+// 192.0.2.1, and returns what was written back.
+func serve(t *testing.T, p interface {
+	Serve(*response.Writer, *request.Request)
+}, raw string) string {
+	t.Helper()
+
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:5000"
+
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	if req.RequestLine.Method == request.MethodHead {
+		w.DiscardBody()
+	}
+	p.Serve(w, req)
+	require.NoError(t, w.Finish())
+
+	return buf.String()
+}
+
+func TestNew(t *testing.T) {
+	for _, target := range []string{"", "/relative", "ftp://example.com", "http://", "://bad"} {
+		_, err := New(target)
+		assert.ErrorIs(t, err, ErrorInvalidTarget, target)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Connection", "X-Secret")
+		w.Header().Set("X-Secret", "hop")
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{"ok":true}`)
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL+"/api?key=1", WithStripPrefix("/proxy"))
+	require.NoError(t, err)
+
+	resp := serve(t, p, "POST /proxy/items?page=2 HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Content-Length: 5\r\n"+
+		"Connection: X-Drop\r\n"+
+		"X-Drop: me\r\n"+
+		"Keep-Alive: timeout=5\r\n"+
+		"X-Forwarded-For: 198.51.100.7\r\n"+
+		"X-Custom: kept\r\n"+
+		"\r\n"+
+		"hello")
+
+	// Test: Method, path, query, headers and body reach the upstream
+	require.NotNil(t, got)
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "/api/items", got.URL.Path)
+	assert.Equal(t, "key=1&page=2", got.URL.RawQuery)
+	assert.Equal(t, "hello", gotBody)
+	assert.Equal(t, "kept", got.Header.Get("X-Custom"))
+	assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://"), got.Host)
+
+	// Test: Hop-by-hop headers don't
+	assert.Empty(t, got.Header.Get("X-Drop"))
+	assert.Empty(t, got.Header.Get("Keep-Alive"))
+
+	// Test: The hop is recorded
+	assert.Equal(t, "198.51.100.7, 192.0.2.1", got.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "for=192.0.2.1;host=example.com;proto=http", got.Header.Get("Forwarded"))
+	assert.Equal(t, "1.1 httpfromtcp", got.Header.Get("Via"))
+
+	// Test: Upstream status, headers and body come back
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 201 Created\r\n"))
+	assert.Contains(t, resp, "content-type: application/json\r\n")
+	assert.Contains(t, resp, "x-upstream: yes\r\n")
+	assert.Contains(t, resp, "content-length: 11\r\n")
+	assert.Contains(t, resp, "via: 1.1 httpfromtcp\r\n")
+	assert.NotContains(t, resp, "x-secret")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"+`{"ok":true}`))
+
+	// Test: Every Set-Cookie keeps a line of its own
+	assert.Contains(t, resp, "set-cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\n")
+	assert.Contains(t, resp, "set-cookie: b=2\r\n")
+}
+
+func TestProxyStreaming(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		for range 3 {
+			_, _ = io.WriteString(w, "chunk")
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, WithContentDigest(), WithPseudonym("edge"))
+	require.NoError(t, err)
+
+	// Test: Chunked bodies stay chunked, with the upstream's trailers and a
+	// digest of our own
+	resp := serve(t, p, "GET / HTTP/1.1\r\nHost: localhost\r\nWant-Content-Digest: sha-256=1\r\n\r\n")
+	assert.Contains(t, resp, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, resp, "trailer: x-checksum, content-digest, x-content-length\r\n")
+	assert.Contains(t, resp, "via: 1.1 edge\r\n")
+	assert.Contains(t, resp, "\r\nchunkchunkchunk\r\n0\r\n")
+	assert.Contains(t, resp, "x-checksum: abc\r\n")
+	assert.Contains(t, resp, "content-digest: sha-256=:")
+	assert.Contains(t, resp, "x-content-length: 15\r\n")
+
+	// Test: HEAD has no body
+	resp = serve(t, p, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+}
+
+func TestProxyRelaysAsItArrives(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "second")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	srv, err := server.Serve(p.Serve, 0)
+	require.NoError(t, err)
+	defer srv.Close()
+	defer close(release)
+
+	// Test: The body reaches the client before the upstream finished it
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	buf := make([]byte, len("first"))
+	_, err = io.ReadFull(resp.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf))
+}
+
+func TestProxyUpstreamFailure(t *testing.T) {
+	// Test: Unreachable upstream
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	p, err := New(closed.URL)
+	require.NoError(t, err)
+	resp := serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Upstream that takes too long
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	p, err = New(slow.URL, WithClient(client.New(client.WithTimeout(20*time.Millisecond))))
+	require.NoError(t, err)
+	resp = serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 504 Gateway Timeout\r\n"))
+}
+
+func TestForwardedElement(t *testing.T) {
+	assert.Equal(t, "for=192.0.2.1;host=example.com;proto=http", forwardedElement("192.0.2.1", "example.com"))
+	assert.Equal(t, `for="[2001:db8::1]";host="example.com:8080";proto=http`, forwardedElement("2001:db8::1", "example.com:8080"))
+	assert.Equal(t, "proto=http", forwardedElement("", ""))
+}
+
+func TestHopByHop(t *testing.T) {
+	assert.True(t, isHopByHop("Transfer-Encoding", ""))
+	assert.True(t, isHopByHop("x-listed", "close, X-Listed"))
+	assert.False(t, isHopByHop("x-other", "close, X-Listed"))
+}
+
+func TestProxyRetries(t *testing.T) {
+	var hits atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "finally")
+	}))
+	defer flaky.Close()
+
+	pool, err := NewPool([]string{flaky.URL}, WithCircuitBreaker(0, 0))
+	require.NoError(t, err)
+	p := NewFromPool(pool, WithRetries(2, time.Millisecond))
+
+	// Test: Idempotent requests are retried
+	resp := serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "finally"))
+	assert.Equal(t, int32(3), hits.Load())
+
+	// Test: Others are not
+	resp = serve(t, p, "POST / HTTP/1.1\r\nContent-Length: 2\r\n\r\nhi")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Equal(t, int32(4), hits.Load())
+
+	// Test: Retries give up eventually, relaying the last answer
+	hits.Store(0)
+	resp = serve(t, NewFromPool(pool, WithRetries(1, time.Millisecond)), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Equal(t, int32(2), hits.Load())
+
+	// Test: A retry may go to another backend
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	pool, err = NewPool([]string{dead.URL, flaky.URL}, WithCircuitBreaker(0, 0))
+	require.NoError(t, err)
+	hits.Store(2)
+	resp = serve(t, NewFromPool(pool, WithRetries(1, time.Millisecond)), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestProxyCircuitOpen(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	p, err := New(dead.URL)
+	require.NoError(t, err)
+
+	// Test: Failures are reported as such
+	for range DefaultMaxFailures {
+		resp := serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"))
+		assert.True(t, strings.HasSuffix(resp, "the upstream could not be reached"))
+	}
+
+	// Test: Until the circuit opens, and clients are told when to come back
+	resp := serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, resp, "retry-after: 30\r\n")
+}
+
+func TestProxyTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	p, err := New(slow.URL, WithTimeouts(time.Second, 20*time.Millisecond))
+	require.NoError(t, err)
+
+	resp := serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 504 Gateway Timeout\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "the upstream did not answer in time"))
+}
+
+func TestProxyTargetTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	pool, err := NewPool([]string{slow.URL, fast.URL},
+		WithTargetTimeouts(slow.URL, 0, 20*time.Millisecond),
+		WithCircuitBreaker(0, 0),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	p := NewFromPool(pool, WithTimeouts(time.Second, time.Minute))
+
+	// Test: The slow upstream times out after its own timeout, not the
+	// proxy's
+	resp := serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 504 Gateway Timeout\r\n"))
+
+	// Test: The other one keeps the proxy's
+	resp = serve(t, p, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "fast"))
+}
+
+func TestBackoff(t *testing.T) {
+	p := &Proxy{backoff: 100 * time.Millisecond}
+	for attempt := range 10 {
+		for range 20 {
+			d := p.backoffFor(attempt)
+			assert.GreaterOrEqual(t, d, time.Duration(0))
+			assert.Less(t, d, min(100*time.Millisecond<<attempt, maxBackoff))
+		}
+	}
+
+	assert.Equal(t, 1, retryAfterSeconds(0))
+	assert.Equal(t, 1, retryAfterSeconds(time.Second))
+	assert.Equal(t, 2, retryAfterSeconds(time.Second+time.Millisecond))
+}
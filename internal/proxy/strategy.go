@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"cmp"
+	"hash/crc32"
+	"slices"
+	"strconv"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+)
+
+// Strategy decides which backend of a Pool serves a request.
+type Strategy interface {
+	// pick chooses among candidates, the available backends with their
+	// current weight, which is never empty. all holds every backend of the
+	// pool in a stable order. It is called with the pool locked.
+	pick(all []*upstream, candidates []candidate, req *request.Request) *upstream
+}
+
+type candidate struct {
+	backend *upstream
+	weight  int
+}
+
+// RoundRobin sends requests to each backend in turn, in proportion to their
+// weight during slow start. It is the smooth weighted round-robin of nginx,
+// which interleaves backends rather than sending bursts to one.
+func RoundRobin() Strategy {
+	return roundRobin{}
+}
+
+type roundRobin struct{}
+
+func (roundRobin) pick(_ []*upstream, candidates []candidate, _ *request.Request) *upstream {
+	var best *upstream
+	total := 0
+	for _, c := range candidates {
+		c.backend.currentWeight += c.weight
+		total += c.weight
+		if best == nil || c.backend.currentWeight > best.currentWeight {
+			best = c.backend
+		}
+	}
+
+	best.currentWeight -= total
+	return best
+}
+
+// LeastConnections sends requests to the backend with the fewest requests in
+// flight relative to its weight. Ties go round-robin.
+func LeastConnections() Strategy {
+	return &leastConnections{}
+}
+
+type leastConnections struct {
+	next int
+}
+
+func (lc *leastConnections) pick(_ []*upstream, candidates []candidate, _ *request.Request) *upstream {
+	lc.next++
+
+	var best candidate
+	for i := range candidates {
+		c := candidates[(lc.next+i)%len(candidates)]
+		// compare active/weight without dividing
+		if best.backend == nil || c.backend.active*best.weight < best.backend.active*c.weight {
+			best = c
+		}
+	}
+	return best.backend
+}
+
+// ringReplicas is how many points each backend gets on the hash ring, which
+// evens out the share of keys each one owns.
+const ringReplicas = 160
+
+// ConsistentHash sends requests with the same value of the header field to
+// the same backend, or by client address when they don't have one. When a
+// backend becomes unavailable only its keys move elsewhere.
+func ConsistentHash(field string) Strategy {
+	return &consistentHash{field: field}
+}
+
+type consistentHash struct {
+	field string
+	ring  []ringPoint
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *upstream
+}
+
+func (ch *consistentHash) pick(all []*upstream, candidates []candidate, req *request.Request) *upstream {
+	if ch.ring == nil {
+		ch.ring = buildRing(all)
+	}
+
+	key, ok := req.Headers.Get(ch.field)
+	if !ok {
+		key = clientIP(req.RemoteAddr)
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+
+	start, _ := slices.BinarySearchFunc(ch.ring, hash, func(p ringPoint, h uint32) int {
+		return cmp.Compare(p.hash, h)
+	})
+
+	for i := range ch.ring {
+		b := ch.ring[(start+i)%len(ch.ring)].backend
+		if slices.ContainsFunc(candidates, func(c candidate) bool { return c.backend == b }) {
+			return b
+		}
+	}
+	return candidates[0].backend
+}
+
+func buildRing(backends []*upstream) []ringPoint {
+	ring := make([]ringPoint, 0, len(backends)*ringReplicas)
+	for _, b := range backends {
+		for i := range ringReplicas {
+			key := b.url.String() + "#" + strconv.Itoa(i)
+			ring = append(ring, ringPoint{hash: crc32.ChecksumIEEE([]byte(key)), backend: b})
+		}
+	}
+
+	slices.SortFunc(ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return ring
+}
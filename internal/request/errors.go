@@ -6,12 +6,17 @@ var (
 	ErrorRequestAlreadyParsed = errors.New("request already fully parsed")
 	ErrorUnknownParserState   = errors.New("unknown/unhandled parser state")
 
-	ErrorRequestLineMalformed = errors.New("request line malformed")
-	ErrorIncompleteRequest    = errors.New("incomplete request, more data needed")
+	ErrorRequestLineMalformed   = errors.New("request line malformed")
+	ErrorRequestTargetMalformed = errors.New("request target malformed")
+	ErrorIncompleteRequest      = errors.New("incomplete request, more data needed")
 
 	ErrorHTTPMethodNotSupported  = errors.New("http method not supported")
 	ErrorHTTPVersionNotSupported = errors.New("http version not supported")
 
+	ErrorFieldValueMalformed        = errors.New("field value malformed")
 	ErrorInvalidContentLengthHeader = errors.New("invalid content-length header")
-	ErrorBodyExceedContentLength    = errors.New("body exceeds content-length")
+	ErrorBodyTooLarge               = errors.New("body exceeds the size limit")
+
+	ErrorUnsupportedTransferEncoding = errors.New("transfer-encoding does not end with chunked")
+	ErrorChunkMalformed              = errors.New("chunk malformed")
 )
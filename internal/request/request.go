@@ -2,18 +2,33 @@
 package request
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"net"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/itsjoeoui/httpfromtcp/internal/common"
+	"github.com/itsjoeoui/httpfromtcp/internal/cookie"
 	"github.com/itsjoeoui/httpfromtcp/internal/headers"
 )
 
+const (
+	MethodGet     = "GET"
+	MethodPost    = "POST"
+	MethodPut     = "PUT"
+	MethodDelete  = "DELETE"
+	MethodHead    = "HEAD"
+	MethodOptions = "OPTIONS"
+	MethodPatch   = "PATCH"
+	MethodConnect = "CONNECT"
+)
+
 var (
-	supportedHTTPMethods  = []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "PATCH"}
+	supportedHTTPMethods  = []string{MethodGet, MethodPost, MethodPut, MethodDelete, MethodHead, MethodOptions, MethodPatch, MethodConnect}
 	supportedHTTPVersions = []string{"1.1"}
 )
 
@@ -24,13 +39,48 @@ const (
 type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
+	// HeaderOrder lists the names in Headers in the order they first
+	// appeared. Write sends them in this order, and any others after them.
+	HeaderOrder []string
 	Body        []byte
+	// Trailers holds the fields sent after a chunked body.
+	Trailers headers.Headers
+
+	// ID identifies the request in logs and responses. The server sets it
+	// from X-Request-Id or generates one.
+	ID string
+	// RemoteAddr is the address of the client, as "host:port", when the
+	// request was read by the server.
+	RemoteAddr string
 
 	ParserState ParserState
+
+	// maxBodySize is the largest Content-Length accepted, zero for no limit.
+	maxBodySize int
+	// remaining counts the body bytes, or bytes of the current chunk, still
+	// to be read.
+	remaining int
+	// buffered holds the bytes read past the end of the request.
+	buffered []byte
+}
+
+// Option configures RequestFromReader.
+type Option func(*Request)
+
+// WithMaxBodySize rejects requests whose Content-Length exceeds n with
+// ErrorBodyTooLarge, before any of the body is read.
+func WithMaxBodySize(n int) Option {
+	return func(r *Request) {
+		r.maxBodySize = n
+	}
 }
 
 type RequestLine struct {
-	HTTPVersion   string
+	HTTPVersion string
+	// RequestTarget is in origin-form ("/path?query") for most requests,
+	// absolute-form ("http://host/path") for requests sent to a proxy,
+	// authority-form ("host:port") for CONNECT and "*" for a server-wide
+	// OPTIONS. Path and RawQuery take it apart in any of these forms.
 	RequestTarget string
 	Method        string
 }
@@ -41,6 +91,10 @@ const (
 	ParserStateRequestLine ParserState = "RequestLine"
 	ParserStateHeaders     ParserState = "Headers"
 	ParserStateBody        ParserState = "Body"
+	ParserStateChunkSize   ParserState = "ChunkSize"
+	ParserStateChunkData   ParserState = "ChunkData"
+	ParserStateChunkEnd    ParserState = "ChunkEnd"
+	ParserStateTrailers    ParserState = "Trailers"
 	ParserStateDone        ParserState = "Done"
 )
 
@@ -82,31 +136,70 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 		if done {
-			r.ParserState = ParserStateBody
+			return bytesParsed, r.endHeaders()
+		}
+		if bytesParsed > 0 {
+			r.recordHeaderName(data[:bytesParsed])
 		}
 		return bytesParsed, nil
 	case ParserStateBody:
-		contentLengthStr, ok := r.Headers.Get(headers.ContentLengthHeader)
-		if !ok {
+		n := min(r.remaining, len(data))
+		r.Body = append(r.Body, data[:n]...)
+		r.remaining -= n
+
+		if r.remaining == 0 {
 			r.ParserState = ParserStateDone
-			return len(data), nil
-		}
-		contentLength, err := strconv.Atoi(contentLengthStr)
-		if err != nil {
-			return 0, ErrorInvalidContentLengthHeader
 		}
 
-		r.Body = append(r.Body, data...)
+		return n, nil
+	case ParserStateChunkSize:
+		idx := bytes.Index(data, []byte(common.CRLF))
+		if idx == -1 {
+			return 0, nil
+		}
 
-		if len(r.Body) > contentLength {
-			return 0, ErrorBodyExceedContentLength
+		size, err := parseChunkSize(string(data[:idx]))
+		if err != nil {
+			return 0, err
+		}
+		if r.maxBodySize > 0 && len(r.Body)+size > r.maxBodySize {
+			return 0, ErrorBodyTooLarge
+		}
+		if size == 0 {
+			r.ParserState = ParserStateTrailers
+		} else {
+			r.remaining = size
+			r.ParserState = ParserStateChunkData
+		}
+		return idx + len(common.CRLF), nil
+	case ParserStateChunkData:
+		n := min(r.remaining, len(data))
+		r.Body = append(r.Body, data[:n]...)
+		r.remaining -= n
+
+		if r.remaining == 0 {
+			r.ParserState = ParserStateChunkEnd
+		}
+		return n, nil
+	case ParserStateChunkEnd:
+		if len(data) < len(common.CRLF) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(common.CRLF)) {
+			return 0, ErrorChunkMalformed
 		}
 
-		if len(r.Body) == contentLength {
+		r.ParserState = ParserStateChunkSize
+		return len(common.CRLF), nil
+	case ParserStateTrailers:
+		bytesParsed, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
 			r.ParserState = ParserStateDone
 		}
-
-		return len(data), nil
+		return bytesParsed, nil
 
 	case ParserStateDone:
 		return 0, ErrorRequestAlreadyParsed
@@ -115,15 +208,77 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+// endHeaders picks the framing of the body (RFC 9112 section 6.3): chunked if
+// Transfer-Encoding says so, which wins over Content-Length, otherwise
+// Content-Length bytes, or none at all.
+func (r *Request) endHeaders() error {
+	if te, ok := r.Headers.Get(headers.TransferEncodingHeader); ok {
+		codings := headers.SplitList(te)
+		if len(codings) == 0 || !strings.EqualFold(codings[len(codings)-1], "chunked") {
+			// the length of the body can't be determined
+			return ErrorUnsupportedTransferEncoding
+		}
+		r.ParserState = ParserStateChunkSize
+		return nil
+	}
+
+	contentLengthStr, ok := r.Headers.Get(headers.ContentLengthHeader)
+	if !ok {
+		// anything after the headers is not ours
+		r.ParserState = ParserStateDone
+		return nil
+	}
+
+	contentLength, err := strconv.Atoi(contentLengthStr)
+	if err != nil || contentLength < 0 {
+		return ErrorInvalidContentLengthHeader
+	}
+	if r.maxBodySize > 0 && contentLength > r.maxBodySize {
+		return ErrorBodyTooLarge
+	}
+
+	r.remaining = contentLength
+	r.ParserState = ParserStateBody
+	if contentLength == 0 {
+		r.ParserState = ParserStateDone
+	}
+	return nil
+}
+
+// recordHeaderName adds the name of the field line to HeaderOrder, unless an
+// earlier line had it already.
+func (r *Request) recordHeaderName(line []byte) {
+	name, _, _ := bytes.Cut(line, []byte(":"))
+	key := strings.ToLower(string(bytes.TrimSpace(name)))
+	if !slices.Contains(r.HeaderOrder, key) {
+		r.HeaderOrder = append(r.HeaderOrder, key)
+	}
+}
+
+// parseChunkSize parses the hexadecimal size of a chunk, ignoring any chunk
+// extensions.
+func parseChunkSize(line string) (int, error) {
+	sizeStr, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseUint(strings.TrimSpace(sizeStr), 16, 31)
+	if err != nil {
+		return 0, ErrorChunkMalformed
+	}
+	return int(size), nil
+}
+
 func (r *Request) done() bool {
 	return r.ParserState == ParserStateDone
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
+func RequestFromReader(reader io.Reader, opts ...Option) (*Request, error) {
 	request := &Request{
 		ParserState: ParserStateRequestLine,
 		Headers:     headers.NewHeaders(),
 		Body:        []byte{},
+		Trailers:    headers.NewHeaders(),
+	}
+	for _, opt := range opts {
+		opt(request)
 	}
 
 	buffer := make([]byte, bufferSize)
@@ -163,9 +318,78 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		}
 	}
 
+	if readToIndex > 0 {
+		request.buffered = buffer[:readToIndex]
+	}
+
 	return request, nil
 }
 
+// Buffered returns the bytes RequestFromReader read past the end of the
+// request, which belong to whatever the client sent next. It is empty unless
+// the client sent more without waiting for a response.
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
+// Path returns the path of the request target, still escaped and without the
+// query. An absolute-form target is reduced to its path, "/" if it has none,
+// so handlers see the same path whether or not the client thought it was
+// talking to a proxy (RFC 9112 section 3.2.2). Authority-form and asterisk-
+// form targets are returned as they are.
+func (r *Request) Path() string {
+	path, _, _ := strings.Cut(r.originForm(), "?")
+	return path
+}
+
+// RawQuery returns the query of the request target, still escaped and
+// without the "?".
+func (r *Request) RawQuery() string {
+	_, query, _ := strings.Cut(r.originForm(), "?")
+	return query
+}
+
+// originForm returns the target with the scheme and authority of an
+// absolute-form target removed.
+func (r *Request) originForm() string {
+	target := r.RequestLine.RequestTarget
+	if strings.HasPrefix(target, "/") {
+		return target
+	}
+	_, rest, ok := strings.Cut(target, "://")
+	if !ok {
+		return target
+	}
+
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		return "/"
+	}
+	if rest[end] == '?' {
+		return "/" + rest[end:]
+	}
+	return rest[end:]
+}
+
+// Cookies returns the cookies sent in the Cookie header, in order.
+func (r *Request) Cookies() []cookie.Cookie {
+	value, ok := r.Headers.Get(headers.CookieHeader)
+	if !ok {
+		return nil
+	}
+	return cookie.Parse(value)
+}
+
+// Cookie returns the value of the first cookie called name.
+func (r *Request) Cookie(name string) (string, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
 func parseRequestLine(req []byte) (*RequestLine, int, error) {
 	splitReq := strings.Split(string(req), common.CRLF)
 	if len(splitReq) <= 1 {
@@ -189,9 +413,41 @@ func parseRequestLine(req []byte) (*RequestLine, int, error) {
 		return nil, 0, ErrorHTTPVersionNotSupported
 	}
 
+	if !isValidTarget(parts[0], parts[1]) {
+		return nil, 0, ErrorRequestTargetMalformed
+	}
+
 	return &RequestLine{
 		HTTPVersion:   httpVersion,
 		RequestTarget: parts[1],
 		Method:        parts[0],
 	}, len(requestLine) + len(common.CRLF), nil
 }
+
+// isValidTarget reports whether target has the form method requires (RFC 9112
+// section 3.2): authority-form for CONNECT and only for CONNECT, asterisk-form
+// only for OPTIONS, and otherwise origin-form or absolute-form.
+func isValidTarget(method, target string) bool {
+	switch {
+	case method == MethodConnect:
+		return isAuthorityForm(target)
+	case target == "*":
+		return method == MethodOptions
+	case strings.HasPrefix(target, "/"):
+		return true
+	default:
+		u, err := url.Parse(target)
+		return err == nil && u.IsAbs() && u.Host != ""
+	}
+}
+
+// isAuthorityForm reports whether target is a "host:port" with a valid port.
+func isAuthorityForm(target string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" {
+		return false
+	}
+
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
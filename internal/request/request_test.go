@@ -2,8 +2,10 @@ package request
 
 import (
 	"io"
+	"strings"
 	"testing"
 
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -87,6 +89,54 @@ func TestRequestLineParse(t *testing.T) {
 	}
 	_, err = RequestFromReader(reader)
 	require.Error(t, err)
+
+	// Test: CONNECT with an authority-form target
+	reader = &chunkReader{
+		data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+
+	// Test: Absolute-form target
+	reader = &chunkReader{
+		data:            "GET http://example.com/coffee?roast=dark HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/coffee?roast=dark", r.RequestLine.RequestTarget)
+
+	// Test: Asterisk-form target
+	reader = &chunkReader{
+		data:            "OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "*", r.RequestLine.RequestTarget)
+
+	// Test: Targets in the wrong form
+	for _, line := range []string{
+		"CONNECT /coffee HTTP/1.1",
+		"CONNECT http://example.com/ HTTP/1.1",
+		"CONNECT example.com HTTP/1.1",
+		"CONNECT example.com:http HTTP/1.1",
+		"CONNECT :443 HTTP/1.1",
+		"GET example.com:443 HTTP/1.1",
+		"GET * HTTP/1.1",
+		"GET coffee HTTP/1.1",
+		"GET http:///coffee HTTP/1.1",
+	} {
+		reader = &chunkReader{
+			data:            line + "\r\nHost: example.com\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		_, err = RequestFromReader(reader)
+		assert.ErrorIs(t, err, ErrorRequestTargetMalformed, line)
+	}
 }
 
 func TestHeadersParse(t *testing.T) {
@@ -150,6 +200,54 @@ func TestHeadersParse(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestRequestPath(t *testing.T) {
+	cases := []struct {
+		target, path, query string
+	}{
+		{"/coffee?roast=dark", "/coffee", "roast=dark"},
+		{"/", "/", ""},
+		{"http://localhost:42069/assets/a%20b.txt?x=1", "/assets/a%20b.txt", "x=1"},
+		{"http://localhost", "/", ""},
+		{"http://localhost?x=1", "/", "x=1"},
+		{"localhost:443", "localhost:443", ""},
+		{"*", "*", ""},
+	}
+
+	for _, c := range cases {
+		r := &Request{RequestLine: RequestLine{RequestTarget: c.target}}
+		assert.Equal(t, c.path, r.Path(), c.target)
+		assert.Equal(t, c.query, r.RawQuery(), c.target)
+	}
+}
+
+func TestRequestCookies(t *testing.T) {
+	// Test: Cookies are parsed from the Cookie header
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: session=abc; theme=\"dark\"\r\nCookie: lang=en\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc; theme=\"dark\"; lang=en", r.Headers["cookie"])
+	assert.Len(t, r.Cookies(), 3)
+
+	value, ok := r.Cookie("theme")
+	assert.True(t, ok)
+	assert.Equal(t, "dark", value)
+	value, ok = r.Cookie("lang")
+	assert.True(t, ok)
+	assert.Equal(t, "en", value)
+
+	// Test: A missing cookie is reported as such
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+
+	// Test: No Cookie header, no cookies
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
+
 func TestBodyParse(t *testing.T) {
 	// Test: Standard Body
 	reader := &chunkReader{
@@ -202,4 +300,239 @@ func TestBodyParse(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
+
+	// Test: Bytes past the end of the request are kept aside
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 100,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	// how much of the rest was read depends on the buffer size
+	assert.NotEmpty(t, r.Buffered())
+	assert.True(t, strings.HasPrefix(" world!\n", string(r.Buffered())))
+
+	// Test: Body within the size limit
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader, WithMaxBodySize(13))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Body over the size limit is refused before it is read
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 1000000000\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader, WithMaxBodySize(13))
+	require.ErrorIs(t, err, ErrorBodyTooLarge)
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Chunks are put back together, trailers kept apart
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6;ext=1\r\nhello \r\n" +
+			"7\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	checksum, _ := r.Trailers.Get("x-checksum")
+	assert.Equal(t, "abc", checksum)
+
+	// Test: Transfer-Encoding wins over Content-Length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+
+	// Test: A body that can't be delimited is refused
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrorUnsupportedTransferEncoding)
+
+	// Test: Malformed chunk
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabcd\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrorChunkMalformed)
+
+	// Test: Chunked body over the size limit
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n8\r\n12345678\r\n8\r\n12345678\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader, WithMaxBodySize(13))
+	require.ErrorIs(t, err, ErrorBodyTooLarge)
+}
+
+func TestRequestWrite(t *testing.T) {
+	// Test: Fields keep the order they were parsed in, Host first
+	raw := "GET /search?q=go HTTP/1.1\r\n" +
+		"User-Agent: curl/7.81.0\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Accept: */*\r\n" +
+		"Accept: text/html\r\n" +
+		"X-Request-Id: 42\r\n" +
+		"\r\n"
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user-agent", "host", "accept", "x-request-id"}, r.HeaderOrder)
+
+	var b strings.Builder
+	require.NoError(t, r.Write(&b))
+	assert.Equal(t, "GET /search?q=go HTTP/1.1\r\n"+
+		"host: localhost:42069\r\n"+
+		"user-agent: curl/7.81.0\r\n"+
+		"accept: */*, text/html\r\n"+
+		"x-request-id: 42\r\n"+
+		"\r\n", b.String())
+
+	// Test: Fields set afterwards follow, sorted
+	r.Headers.Set("via", "1.1 proxy")
+	r.Headers.Set("forwarded", "for=127.0.0.1")
+	b.Reset()
+	require.NoError(t, r.Write(&b))
+	assert.True(t, strings.HasSuffix(b.String(), "x-request-id: 42\r\nforwarded: for=127.0.0.1\r\nvia: 1.1 proxy\r\n\r\n"))
+
+	// Test: Content-Length follows the body
+	r = &Request{
+		RequestLine: RequestLine{Method: MethodPost, RequestTarget: "/submit"},
+		Headers:     headers.Headers{"host": "localhost", "content-length": "999"},
+		Body:        []byte("hello"),
+	}
+	b.Reset()
+	require.NoError(t, r.Write(&b))
+	assert.Equal(t, "POST /submit HTTP/1.1\r\nhost: localhost\r\ncontent-length: 5\r\n\r\nhello", b.String())
+
+	// Test: An empty POST still announces its length, an empty GET doesn't
+	r.Body = nil
+	r.Headers.Remove(headers.ContentLengthHeader)
+	b.Reset()
+	require.NoError(t, r.Write(&b))
+	assert.Equal(t, "POST /submit HTTP/1.1\r\nhost: localhost\r\ncontent-length: 0\r\n\r\n", b.String())
+	r.RequestLine.Method = MethodGet
+	b.Reset()
+	require.NoError(t, r.Write(&b))
+	assert.Equal(t, "GET /submit HTTP/1.1\r\nhost: localhost\r\n\r\n", b.String())
+
+	// Test: Transfer-Encoding means chunked framing, with the trailers
+	r = &Request{
+		RequestLine: RequestLine{Method: MethodPut, RequestTarget: "/upload"},
+		Headers:     headers.Headers{"host": "localhost", "transfer-encoding": "gzip"},
+		Body:        []byte("compressed"),
+		Trailers:    headers.Headers{"x-checksum": "abc"},
+	}
+	b.Reset()
+	require.NoError(t, r.Write(&b))
+	assert.Equal(t, "PUT /upload HTTP/1.1\r\nhost: localhost\r\ntransfer-encoding: gzip, chunked\r\n\r\n"+
+		"a\r\ncompressed\r\n0\r\nx-checksum: abc\r\n\r\n", b.String())
+
+	// Test: Line breaks can't be smuggled into the request
+	r = &Request{
+		RequestLine: RequestLine{Method: MethodGet, RequestTarget: "/"},
+		Headers:     headers.Headers{"x-evil": "a\r\nhost: elsewhere"},
+	}
+	require.ErrorIs(t, r.Write(&b), ErrorFieldValueMalformed)
+	r = &Request{
+		RequestLine: RequestLine{Method: MethodGet, RequestTarget: "/ HTTP/1.1\r\n"},
+		Headers:     headers.NewHeaders(),
+	}
+	require.ErrorIs(t, r.Write(&b), ErrorRequestTargetMalformed)
+	r = &Request{
+		RequestLine: RequestLine{Method: "GET /evil HTTP/1.1\r\nHost: x\r\n\r\nGET", RequestTarget: "/"},
+		Headers:     headers.NewHeaders(),
+	}
+	require.ErrorIs(t, r.Write(&b), ErrorRequestLineMalformed)
+	r = &Request{
+		RequestLine: RequestLine{Method: MethodGet, RequestTarget: "/"},
+		Headers:     headers.Headers{"x-a\r\nhost: elsewhere\r\n\r\nget / http/1.1\r\nx-b": "1"},
+	}
+	require.ErrorIs(t, r.Write(&b), headers.ErrorInvalidFieldNameToken)
+	r = &Request{
+		RequestLine: RequestLine{Method: MethodPost, RequestTarget: "/"},
+		Headers:     headers.Headers{"transfer-encoding": "chunked"},
+		Trailers:    headers.Headers{"x evil": "1"},
+	}
+	require.ErrorIs(t, r.Write(&b), headers.ErrorInvalidFieldNameToken)
+
+	// Test: Only HTTP/1.0 and HTTP/1.1 requests can be written
+	r = &Request{
+		RequestLine: RequestLine{Method: MethodGet, RequestTarget: "/", HTTPVersion: "1.0"},
+		Headers:     headers.Headers{"host": "localhost"},
+	}
+	b.Reset()
+	require.NoError(t, r.Write(&b))
+	assert.Equal(t, "GET / HTTP/1.0\r\nhost: localhost\r\n\r\n", b.String())
+	r.RequestLine.HTTPVersion = "2"
+	require.ErrorIs(t, r.Write(&b), ErrorHTTPVersionNotSupported)
+
+	// Test: And HTTP/1.0 has no chunked framing
+	r.RequestLine.HTTPVersion = "1.0"
+	r.Headers.Set(headers.TransferEncodingHeader, "chunked")
+	require.ErrorIs(t, r.Write(&b), ErrorHTTPVersionNotSupported)
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		"OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nProxy-Authorization: Basic dXNlcjpwYXNz\r\n\r\n",
+		"GET http://example.com/a?b=c HTTP/1.1\r\nHost: example.com\r\nVia: 1.1 a, 1.1 b\r\n\r\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n",
+		"PUT /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n",
+	} {
+		// Test: Parsing what Write sends gives the same request back
+		first, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 7})
+		require.NoError(t, err, raw)
+
+		var b strings.Builder
+		require.NoError(t, first.Write(&b), raw)
+		second, err := RequestFromReader(&chunkReader{data: b.String(), numBytesPerRead: 7})
+		require.NoError(t, err, b.String())
+
+		assert.Equal(t, first.RequestLine, second.RequestLine, raw)
+		assert.Equal(t, first.Headers, second.Headers, raw)
+		assert.Equal(t, first.HeaderOrder, second.HeaderOrder, raw)
+		assert.Equal(t, first.Body, second.Body, raw)
+		assert.Equal(t, first.Trailers, second.Trailers, raw)
+
+		// Test: And writing it again gives the same bytes
+		var again strings.Builder
+		require.NoError(t, second.Write(&again))
+		assert.Equal(t, b.String(), again.String(), raw)
+	}
 }
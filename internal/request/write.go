@@ -0,0 +1,141 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/common"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+// Write sends r to w as an HTTP/1.1 request, target as is. HTTPVersion may be
+// "1.0" or "1.1", or empty for the latter; a request that can't be written
+// well-formed, e.g. one that came in over HTTP/2, is an error.
+//
+// Host goes first, then the fields listed in HeaderOrder and then the rest
+// sorted by name. The body is framed anew: chunked with the Trailers when
+// Transfer-Encoding is set, otherwise with a Content-Length computed from
+// Body, which is left out for an empty body unless the method usually
+// carries one or the request had a Content-Length already.
+func (r *Request) Write(w io.Writer) error {
+	if !headers.IsToken(r.RequestLine.Method) {
+		return ErrorRequestLineMalformed
+	}
+	if r.RequestLine.RequestTarget == "" || strings.ContainsAny(r.RequestLine.RequestTarget, " \r\n") {
+		return ErrorRequestTargetMalformed
+	}
+	version := r.RequestLine.HTTPVersion
+	if version == "" {
+		version = "1.1"
+	}
+	te, chunked := r.Headers.Get(headers.TransferEncodingHeader)
+	switch {
+	case version != "1.0" && version != "1.1":
+		return ErrorHTTPVersionNotSupported
+	case version == "1.0" && chunked:
+		// HTTP/1.0 has no chunked framing to send the body with
+		return fmt.Errorf("%w: transfer-encoding in an HTTP/1.0 request", ErrorHTTPVersionNotSupported)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/%s%s", r.RequestLine.Method, r.RequestLine.RequestTarget, version, common.CRLF)
+
+	for _, key := range r.fieldOrder() {
+		value := r.Headers[key]
+		switch key {
+		case headers.ContentLengthHeader:
+			continue
+		case headers.TransferEncodingHeader:
+			value = withChunked(te)
+		}
+
+		err := writeField(&b, key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, hasLength := r.Headers.Get(headers.ContentLengthHeader)
+	if !chunked && (len(r.Body) > 0 || hasLength || expectsBody(r.RequestLine.Method)) {
+		fmt.Fprintf(&b, "%s: %d%s", headers.ContentLengthHeader, len(r.Body), common.CRLF)
+	}
+	b.WriteString(common.CRLF)
+
+	if !chunked {
+		b.Write(r.Body)
+		_, err := w.Write(b.Bytes())
+		return err
+	}
+
+	if len(r.Body) > 0 {
+		fmt.Fprintf(&b, "%x%s%s%s", len(r.Body), common.CRLF, r.Body, common.CRLF)
+	}
+	fmt.Fprintf(&b, "0%s", common.CRLF)
+	for _, key := range slices.Sorted(maps.Keys(r.Trailers)) {
+		err := writeField(&b, key, r.Trailers[key])
+		if err != nil {
+			return err
+		}
+	}
+	b.WriteString(common.CRLF)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// fieldOrder returns the names in Headers in the order Write sends them.
+func (r *Request) fieldOrder() []string {
+	order := make([]string, 0, len(r.Headers))
+	seen := make(map[string]bool, len(r.Headers))
+	add := func(key string) {
+		if _, ok := r.Headers[key]; ok && !seen[key] {
+			seen[key] = true
+			order = append(order, key)
+		}
+	}
+
+	add(headers.HostHeader)
+	for _, key := range r.HeaderOrder {
+		add(key)
+	}
+	for _, key := range slices.Sorted(maps.Keys(r.Headers)) {
+		add(key)
+	}
+	return order
+}
+
+func writeField(b *bytes.Buffer, key, value string) error {
+	if !headers.IsToken(key) {
+		return fmt.Errorf("%w: %q", headers.ErrorInvalidFieldNameToken, key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%w: %s", ErrorFieldValueMalformed, key)
+	}
+	fmt.Fprintf(b, "%s: %s%s", key, value, common.CRLF)
+	return nil
+}
+
+// withChunked makes chunked the last transfer coding of te, as it must be for
+// the body to be framed.
+func withChunked(te string) string {
+	codings := headers.SplitList(te)
+	if len(codings) > 0 && strings.EqualFold(codings[len(codings)-1], "chunked") {
+		return te
+	}
+	return strings.Join(append(codings, "chunked"), ", ")
+}
+
+// expectsBody reports whether requests with method usually carry content, so
+// that an empty one is announced with "Content-Length: 0".
+func expectsBody(method string) bool {
+	switch method {
+	case MethodPost, MethodPut, MethodPatch:
+		return true
+	default:
+		return false
+	}
+}
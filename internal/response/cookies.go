@@ -0,0 +1,35 @@
+package response
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/cookie"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+// SetCookie adds a Set-Cookie field for c to the final response. It must be
+// called before WriteHeaders, and fails if c can't be formatted.
+func (w *Writer) SetCookie(c *cookie.SetCookie) error {
+	value, err := c.Format()
+	if err != nil {
+		return err
+	}
+	return w.AddSetCookie(value)
+}
+
+// AddSetCookie adds a Set-Cookie field with a value formatted already, e.g.
+// one relayed from an upstream response. Unlike other fields, every
+// Set-Cookie goes out on a line of its own: their values can't be combined
+// into a list (RFC 9110 section 5.3).
+func (w *Writer) AddSetCookie(value string) error {
+	if w.state != WriteStateStatusLine && w.state != WriteStateHeaders {
+		return ErrorInvalidResponseWriterState
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%w: %s", ErrorInvalidFieldValue, headers.SetCookieHeader)
+	}
+
+	w.setCookies = append(w.setCookies, value)
+	return nil
+}
@@ -2,4 +2,25 @@ package response
 
 import "errors"
 
-var ErrorInvalidResponseWriterState = errors.New("invalid response writer state")
+var (
+	ErrorInvalidResponseWriterState = errors.New("invalid response writer state")
+	ErrorBodyNotAllowed             = errors.New("response status does not allow a body")
+	ErrorHijackNotSupported         = errors.New("connection can't be hijacked")
+	ErrorInvalidFieldValue          = errors.New("invalid field value")
+
+	ErrorTrailersNotAccepted = errors.New("client does not accept trailers")
+	ErrorForbiddenTrailer    = errors.New("field is not allowed in trailers")
+	ErrorUndeclaredTrailer   = errors.New("trailer field was not declared")
+
+	ErrorResponseAlreadyParsed = errors.New("response already fully parsed")
+	ErrorUnknownParserState    = errors.New("unknown/unhandled parser state")
+
+	ErrorStatusLineMalformed        = errors.New("status line malformed")
+	ErrorFieldLineMalformed         = errors.New("field line malformed")
+	ErrorChunkMalformed             = errors.New("chunk malformed")
+	ErrorIncompleteResponse         = errors.New("incomplete response, more data needed")
+	ErrorHTTPVersionNotSupported    = errors.New("http version not supported")
+	ErrorInvalidContentLengthHeader = errors.New("invalid content-length header")
+	ErrorBodyTooLarge               = errors.New("body exceeds the size limit")
+	ErrorLineTooLong                = errors.New("line does not fit in the read buffer")
+)
@@ -0,0 +1,25 @@
+package response
+
+import "github.com/itsjoeoui/httpfromtcp/internal/headers"
+
+// Framer carries a response over a protocol that frames messages itself,
+// such as HTTP/2, instead of the HTTP/1.1 syntax. Chunked framing, connection
+// management and Hijack are then up to the protocol: Transfer-Encoding only
+// tells the Writer that trailers may follow the body.
+type Framer interface {
+	// WriteHeaders sends the header section of an interim or final response,
+	// with a separate Set-Cookie field for each of setCookies.
+	WriteHeaders(statusCode StatusCode, h headers.Headers, setCookies []string) error
+	// Write sends body bytes.
+	Write(p []byte) (int, error)
+	// End completes the response, with trailers as its trailer section
+	// unless empty.
+	End(trailers headers.Headers) error
+}
+
+// NewFramedWriter returns a Writer that sends its response through f.
+func NewFramedWriter(f Framer) *Writer {
+	w := NewWriter(f)
+	w.framer = f
+	return w
+}
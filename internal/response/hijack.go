@@ -0,0 +1,46 @@
+package response
+
+import "net"
+
+// Hijacker hands the connection a Writer writes to over to the caller, along
+// with the bytes already read from it that no request consumed.
+type Hijacker func() (net.Conn, []byte, error)
+
+// SetHijacker makes Hijack available. The server sets it for every request.
+func (w *Writer) SetHijacker(h Hijacker) {
+	w.hijacker = h
+}
+
+// Hijack takes over the connection, e.g. to speak another protocol on it
+// after a 101 Switching Protocols or a 2xx answer to CONNECT. It may be
+// called before a status line, or once the headers of a response without
+// chunked framing are out.
+//
+// buffered holds the bytes the client sent that were already read from conn,
+// which come before anything read from conn itself. From then on the server
+// leaves the connection alone: it writes no further response and doesn't
+// close it, so the caller must.
+func (w *Writer) Hijack() (conn net.Conn, buffered []byte, err error) {
+	if w.hijacker == nil {
+		return nil, nil, ErrorHijackNotSupported
+	}
+	switch {
+	case w.state == WriteStateStatusLine:
+	case w.state == WriteStateBody && !w.chunked && w.body == nil:
+	default:
+		// a response in progress would be cut short
+		return nil, nil, ErrorInvalidResponseWriterState
+	}
+
+	conn, buffered, err = w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.state = WriteStateHijacked
+	return conn, buffered, nil
+}
+
+// Hijacked reports whether Hijack handed the connection over.
+func (w *Writer) Hijacked() bool {
+	return w.state == WriteStateHijacked
+}
@@ -0,0 +1,528 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/common"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+)
+
+var supportedHTTPVersions = []string{"1.0", "1.1"}
+
+const (
+	bufferSize = 1024
+)
+
+// Response is a response read by ResponseFromReader, or by
+// ResponseHeadFromReader and then BodyReader.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	// SetCookies holds the values of the Set-Cookie fields, one per field
+	// and in order. Unlike other repeated fields they can't be combined into
+	// a list, so they are kept out of Headers.
+	SetCookies []string
+	// Body is the whole body after ResponseFromReader. After
+	// ResponseHeadFromReader it stays empty: BodyReader returns the body.
+	Body []byte
+	// Trailers holds the fields sent after a chunked body.
+	Trailers headers.Headers
+	// Interim holds the 1xx responses that came before this one, other than
+	// 101 which is returned as the response itself.
+	Interim []Interim
+
+	ParserState ParserState
+
+	// method is the method of the request this response answers.
+	method string
+	// maxBodySize is the largest body accepted, zero for no limit.
+	maxBodySize int
+	// bodySize counts the body bytes parsed so far.
+	bodySize int
+	// closeDelimited is set when the body ends with the connection.
+	closeDelimited bool
+	// remaining counts the body bytes, or bytes of the current chunk, still
+	// to be read.
+	remaining int
+	// buffered holds the bytes read past the end of the response.
+	buffered []byte
+
+	// stream is what ResponseHeadFromReader reads from, and BodyReader
+	// after it.
+	stream *bufio.Reader
+	// head makes parse stop at the end of the headers.
+	head bool
+}
+
+// Interim is an informational response, such as 100 Continue or 103 Early
+// Hints.
+type Interim struct {
+	StatusCode StatusCode
+	Headers    headers.Headers
+}
+
+type StatusLine struct {
+	HTTPVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// Option configures ResponseFromReader.
+type Option func(*Response)
+
+// WithRequestMethod tells ResponseFromReader which method the request had,
+// so that a response to HEAD is read without body.
+func WithRequestMethod(method string) Option {
+	return func(r *Response) {
+		r.method = method
+	}
+}
+
+// WithMaxBodySize makes ResponseFromReader fail with ErrorBodyTooLarge on a
+// body of more than n bytes: right away when Content-Length or a chunk size
+// announces it, or as soon as a body that ends with the connection grows
+// past n.
+func WithMaxBodySize(n int) Option {
+	return func(r *Response) {
+		r.maxBodySize = n
+	}
+}
+
+type ParserState string
+
+const (
+	ParserStateStatusLine ParserState = "StatusLine"
+	ParserStateHeaders    ParserState = "Headers"
+	ParserStateBody       ParserState = "Body"
+	// ParserStateBodyUntilClose reads a body without Content-Length or
+	// chunking, which ends with the connection.
+	ParserStateBodyUntilClose ParserState = "BodyUntilClose"
+	ParserStateChunkSize      ParserState = "ChunkSize"
+	ParserStateChunkData      ParserState = "ChunkData"
+	ParserStateChunkEnd       ParserState = "ChunkEnd"
+	ParserStateTrailers       ParserState = "Trailers"
+	ParserStateDone           ParserState = "Done"
+)
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalBytesParsed := 0
+
+	for r.ParserState != ParserStateDone && !(r.head && r.inBody()) {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytesParsed += n
+		if n == 0 {
+			break
+		}
+	}
+
+	return totalBytesParsed, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.ParserState {
+	case ParserStateStatusLine:
+		statusLine, length, err := parseStatusLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if length == 0 {
+			return 0, nil
+		}
+
+		r.StatusLine = *statusLine
+		r.ParserState = ParserStateHeaders
+		return length, nil
+	case ParserStateHeaders:
+		line := headers.NewHeaders()
+		bytesParsed, done, err := parseFields(line, data)
+		if err != nil {
+			return 0, err
+		}
+		r.addFields(line)
+		if done {
+			return bytesParsed, r.endHeaders()
+		}
+		return bytesParsed, nil
+	case ParserStateBody:
+		n := min(r.remaining, len(data))
+		r.appendBody(data[:n])
+		r.remaining -= n
+
+		if r.remaining == 0 {
+			r.ParserState = ParserStateDone
+		}
+		return n, nil
+	case ParserStateBodyUntilClose:
+		if r.maxBodySize > 0 && r.bodySize+len(data) > r.maxBodySize {
+			return 0, ErrorBodyTooLarge
+		}
+		r.appendBody(data)
+		return len(data), nil
+	case ParserStateChunkSize:
+		idx := bytes.Index(data, []byte(common.CRLF))
+		if idx == -1 {
+			return 0, nil
+		}
+
+		size, err := parseChunkSize(string(data[:idx]))
+		if err != nil {
+			return 0, err
+		}
+		if r.maxBodySize > 0 && r.bodySize+size > r.maxBodySize {
+			return 0, ErrorBodyTooLarge
+		}
+		if size == 0 {
+			r.ParserState = ParserStateTrailers
+		} else {
+			r.remaining = size
+			r.ParserState = ParserStateChunkData
+		}
+		return idx + len(common.CRLF), nil
+	case ParserStateChunkData:
+		n := min(r.remaining, len(data))
+		r.appendBody(data[:n])
+		r.remaining -= n
+
+		if r.remaining == 0 {
+			r.ParserState = ParserStateChunkEnd
+		}
+		return n, nil
+	case ParserStateChunkEnd:
+		if len(data) < len(common.CRLF) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(common.CRLF)) {
+			return 0, ErrorChunkMalformed
+		}
+
+		r.ParserState = ParserStateChunkSize
+		return len(common.CRLF), nil
+	case ParserStateTrailers:
+		bytesParsed, done, err := parseFields(r.Trailers, data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.ParserState = ParserStateDone
+		}
+		return bytesParsed, nil
+	case ParserStateDone:
+		return 0, ErrorResponseAlreadyParsed
+	default:
+		return 0, ErrorUnknownParserState
+	}
+}
+
+// addFields adds the field parsed into line to Headers, or to SetCookies.
+func (r *Response) addFields(line headers.Headers) {
+	for k, v := range line {
+		if k == headers.SetCookieHeader {
+			r.SetCookies = append(r.SetCookies, v)
+			continue
+		}
+		r.Headers.Set(k, v)
+	}
+}
+
+// endHeaders picks what follows the headers: another response after an
+// interim one, or the body in whichever framing the headers announce (RFC
+// 9112 section 6.3).
+func (r *Response) endHeaders() error {
+	statusCode := r.StatusLine.StatusCode
+	if statusCode.IsInformational() && statusCode != StatusCodeSwitchingProtocols {
+		r.Interim = append(r.Interim, Interim{StatusCode: statusCode, Headers: r.Headers})
+		r.StatusLine = StatusLine{}
+		r.Headers = headers.NewHeaders()
+		r.SetCookies = nil
+		r.ParserState = ParserStateStatusLine
+		return nil
+	}
+
+	te, hasEncoding := r.Headers.Get(headers.TransferEncodingHeader)
+	contentLengthStr, hasLength := r.Headers.Get(headers.ContentLengthHeader)
+	switch {
+	case r.method == request.MethodHead || !statusCode.AllowsBody():
+		// after a 101 the connection speaks another protocol
+		r.ParserState = ParserStateDone
+	case hasEncoding:
+		codings := headers.SplitList(te)
+		if len(codings) > 0 && strings.EqualFold(codings[len(codings)-1], "chunked") {
+			r.ParserState = ParserStateChunkSize
+			return nil
+		}
+		r.closeDelimited = true
+		r.ParserState = ParserStateBodyUntilClose
+	case hasLength:
+		contentLength, err := strconv.Atoi(contentLengthStr)
+		if err != nil || contentLength < 0 {
+			return ErrorInvalidContentLengthHeader
+		}
+		if r.maxBodySize > 0 && contentLength > r.maxBodySize {
+			return ErrorBodyTooLarge
+		}
+		r.remaining = contentLength
+		r.ParserState = ParserStateBody
+		if contentLength == 0 {
+			r.ParserState = ParserStateDone
+		}
+	default:
+		r.closeDelimited = true
+		r.ParserState = ParserStateBodyUntilClose
+	}
+	return nil
+}
+
+func (r *Response) done() bool {
+	return r.ParserState == ParserStateDone
+}
+
+// inBody reports whether the status line and headers of the final response
+// were parsed.
+func (r *Response) inBody() bool {
+	return r.ParserState != ParserStateStatusLine && r.ParserState != ParserStateHeaders
+}
+
+func (r *Response) appendBody(data []byte) {
+	r.Body = append(r.Body, data...)
+	r.bodySize += len(data)
+}
+
+func newResponse(opts []Option) *Response {
+	response := &Response{
+		ParserState: ParserStateStatusLine,
+		Headers:     headers.NewHeaders(),
+		Body:        []byte{},
+		Trailers:    headers.NewHeaders(),
+	}
+	for _, opt := range opts {
+		opt(response)
+	}
+	return response
+}
+
+// ResponseFromReader reads a response from reader, skipping over interim
+// responses into Interim. A body that is neither chunked nor sized by
+// Content-Length is read until EOF.
+func ResponseFromReader(reader io.Reader, opts ...Option) (*Response, error) {
+	response := newResponse(opts)
+
+	buffer := make([]byte, bufferSize)
+	readToIndex := 0
+
+	for !response.done() {
+		if readToIndex == len(buffer) {
+			newBuffer := make([]byte, len(buffer)*2)
+			copy(newBuffer, buffer)
+			buffer = newBuffer
+		}
+
+		bytesRead, err := reader.Read(buffer[readToIndex:])
+		readToIndex += bytesRead
+
+		parsedToIndex, parseErr := response.parse(buffer[:readToIndex])
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		if parsedToIndex != 0 {
+			copy(buffer, buffer[parsedToIndex:])
+			readToIndex -= parsedToIndex
+		}
+
+		if err == nil || response.done() {
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if response.ParserState != ParserStateBodyUntilClose {
+			return nil, ErrorIncompleteResponse
+		}
+		response.ParserState = ParserStateDone
+	}
+
+	if readToIndex > 0 {
+		response.buffered = buffer[:readToIndex]
+	}
+
+	return response, nil
+}
+
+// ResponseHeadFromReader reads a response from reader up to the end of its
+// headers, skipping over interim responses into Interim, and leaves the body
+// to BodyReader. It lets proxies relay a body as it arrives instead of
+// holding all of it in Body. Bytes past the end of the response stay in
+// reader.
+//
+// A line of the response must fit in the buffer of reader.
+func ResponseHeadFromReader(reader *bufio.Reader, opts ...Option) (*Response, error) {
+	response := newResponse(opts)
+	response.stream = reader
+	response.head = true
+
+	for !response.inBody() {
+		err := response.parseStream()
+		if err != nil {
+			return nil, err
+		}
+	}
+	response.head = false
+
+	return response, nil
+}
+
+// BodyReader returns the body of a response read by ResponseHeadFromReader,
+// decoded from its framing. Trailers are set once it returned io.EOF.
+func (r *Response) BodyReader() io.Reader {
+	return bodyReader{r}
+}
+
+type bodyReader struct {
+	r *Response
+}
+
+func (b bodyReader) Read(p []byte) (int, error) {
+	r := b.r
+	// Body holds what was parsed but not read yet
+	for len(r.Body) == 0 {
+		if r.done() {
+			return 0, io.EOF
+		}
+		err := r.parseStream()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.Body)
+	r.Body = r.Body[n:]
+	return n, nil
+}
+
+// parseStream parses the bytes buffered in stream, reading more when none
+// can be parsed yet.
+func (r *Response) parseStream() error {
+	want := max(r.stream.Buffered(), 1)
+	for {
+		data, readErr := r.stream.Peek(want)
+
+		n, err := r.parse(data)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			_, err = r.stream.Discard(n)
+			return err
+		}
+
+		switch {
+		case readErr == nil:
+			want = len(data) + 1
+		case errors.Is(readErr, bufio.ErrBufferFull):
+			return ErrorLineTooLong
+		case !errors.Is(readErr, io.EOF):
+			return readErr
+		case r.ParserState == ParserStateBodyUntilClose:
+			r.ParserState = ParserStateDone
+			return nil
+		default:
+			return ErrorIncompleteResponse
+		}
+	}
+}
+
+// Buffered returns the bytes ResponseFromReader read past the end of the
+// response, e.g. the first bytes of the protocol switched to with a 101.
+func (r *Response) Buffered() []byte {
+	return r.buffered
+}
+
+// KeepAlive reports whether the connection the response was read from may
+// carry another request: it must be HTTP/1.1, not ended by closing the
+// connection, and not asked to be closed with "Connection: close".
+func (r *Response) KeepAlive() bool {
+	if r.StatusLine.HTTPVersion != "1.1" || r.closeDelimited || r.StatusLine.StatusCode == StatusCodeSwitchingProtocols {
+		return false
+	}
+
+	connection, _ := r.Headers.Get(headers.ConnectionHeader)
+	for _, option := range headers.SplitList(connection) {
+		if strings.EqualFold(option, "close") {
+			return false
+		}
+	}
+	return true
+}
+
+func parseStatusLine(data []byte) (*StatusLine, int, error) {
+	idx := bytes.Index(data, []byte(common.CRLF))
+	if idx == -1 {
+		// we do not have a complete status line yet
+		return nil, 0, nil
+	}
+
+	statusLine := string(data[:idx])
+
+	// the reason phrase may contain spaces, or be missing altogether
+	parts := strings.SplitN(statusLine, " ", 3)
+	if len(parts) < 2 {
+		return nil, 0, ErrorStatusLineMalformed
+	}
+
+	httpVersion, ok := strings.CutPrefix(parts[0], "HTTP/")
+	if !ok {
+		return nil, 0, ErrorStatusLineMalformed
+	}
+	if !slices.Contains(supportedHTTPVersions, httpVersion) {
+		return nil, 0, ErrorHTTPVersionNotSupported
+	}
+
+	statusCode, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 || statusCode < 100 {
+		return nil, 0, ErrorStatusLineMalformed
+	}
+
+	reasonPhrase := ""
+	if len(parts) == 3 {
+		reasonPhrase = parts[2]
+	}
+
+	return &StatusLine{
+		HTTPVersion:  httpVersion,
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: reasonPhrase,
+	}, idx + len(common.CRLF), nil
+}
+
+// parseFields parses a header or trailer line into h. Unlike h.Parse it
+// rejects a complete line without a colon instead of waiting for more data.
+func parseFields(h headers.Headers, data []byte) (int, bool, error) {
+	n, done, err := h.Parse(data)
+	if err != nil {
+		return 0, false, err
+	}
+	if n == 0 && !done && bytes.Contains(data, []byte(common.CRLF)) {
+		return 0, false, ErrorFieldLineMalformed
+	}
+	return n, done, nil
+}
+
+// parseChunkSize parses the hexadecimal size of a chunk, ignoring any chunk
+// extensions.
+func parseChunkSize(line string) (int, error) {
+	sizeStr, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseUint(strings.TrimSpace(sizeStr), 16, 31)
+	if err != nil {
+		return 0, ErrorChunkMalformed
+	}
+	return int(size), nil
+}
@@ -0,0 +1,374 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call,
+// like a network connection handing out whatever has arrived.
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := min(cr.pos+cr.numBytesPerRead, len(cr.data))
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestStatusLineParse(t *testing.T) {
+	// Test: Good status line
+	r, err := ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.StatusLine.HTTPVersion)
+	assert.Equal(t, StatusCodeNotFound, r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+
+	// Test: The reason phrase may be empty or missing
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.0 299 \r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HTTPVersion)
+	assert.Equal(t, StatusCode(299), r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 204\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeNoContent, r.StatusLine.StatusCode)
+
+	// Test: Malformed status lines
+	for _, line := range []string{"HTTP/1.1\r\n\r\n", "HTTP/1.1 20 OK\r\n\r\n", "HTTP/1.1 abc OK\r\n\r\n", "HTTPS/1.1 200 OK\r\n\r\n"} {
+		_, err = ResponseFromReader(strings.NewReader(line))
+		assert.ErrorIs(t, err, ErrorStatusLineMalformed, line)
+	}
+
+	// Test: Unsupported version
+	_, err = ResponseFromReader(strings.NewReader("HTTP/2 200 OK\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrorHTTPVersionNotSupported)
+}
+
+func TestResponseHeadersParse(t *testing.T) {
+	// Test: Headers are parsed like the request's
+	r, err := ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nVary: Accept\r\nVary: Accept-Encoding\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	require.NoError(t, err)
+	contentType, _ := r.Headers.Get(headers.ContentTypeHeader)
+	assert.Equal(t, "text/plain", contentType)
+	vary, _ := r.Headers.Get(headers.VaryHeader)
+	assert.Equal(t, "Accept, Accept-Encoding", vary)
+
+	// Test: Set-Cookie fields are kept apart, since their values may contain
+	// commas
+	r, err = ResponseFromReader(&chunkReader{
+		data: "HTTP/1.1 103 Early Hints\r\nSet-Cookie: early=1\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\nContent-Length: 0\r\nset-cookie: b=2\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, r.SetCookies)
+	_, ok := r.Headers.Get(headers.SetCookieHeader)
+	assert.False(t, ok)
+	assert.Equal(t, "0", r.Headers["content-length"])
+
+	// Test: A line without a colon is malformed
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nno colon\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrorFieldLineMalformed)
+
+	// Test: Invalid field name
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nBad Name: x\r\n\r\n"))
+	assert.Error(t, err)
+
+	// Test: So is an empty one, in the headers and in the trailers
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\n: x\r\n\r\n"))
+	assert.ErrorIs(t, err, headers.ErrorInvalidFieldNameFormat)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n: x\r\n\r\n"))
+	assert.ErrorIs(t, err, headers.ErrorInvalidFieldNameFormat)
+	_, err = ResponseHeadFromReader(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\n: x\r\n\r\n")))
+	assert.ErrorIs(t, err, headers.ErrorInvalidFieldNameFormat)
+
+	// Test: Headers cut short
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n"))
+	assert.ErrorIs(t, err, ErrorIncompleteResponse)
+}
+
+func TestResponseBodyParse(t *testing.T) {
+	// Test: Content-Length body
+	r, err := ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.True(t, r.KeepAlive())
+
+	// Test: Body shorter than its Content-Length
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\nshort"))
+	assert.ErrorIs(t, err, ErrorIncompleteResponse)
+
+	// Test: Invalid Content-Length
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrorInvalidContentLengthHeader)
+
+	// Test: Bytes past the body are kept aside
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nokHTTP/1.1"))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(r.Body))
+	assert.Equal(t, "HTTP/1.1", string(r.Buffered()))
+
+	// Test: Without Content-Length the body ends with the connection
+	r, err = ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end",
+		numBytesPerRead: 4,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(r.Body))
+	assert.False(t, r.KeepAlive())
+
+	// Test: So does a body with a transfer coding other than chunked last
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\nContent-Length: 2\r\n\r\nnot 2 bytes"))
+	require.NoError(t, err)
+	assert.Equal(t, "not 2 bytes", string(r.Body))
+}
+
+func TestResponseMaxBodySize(t *testing.T) {
+	// Test: A body within the limit is read
+	r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"), WithMaxBodySize(5))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: A larger Content-Length fails before the body is read
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\n"), WithMaxBodySize(5))
+	assert.ErrorIs(t, err, ErrorBodyTooLarge)
+
+	// Test: So do chunks adding up to more
+	_, err = ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	}, WithMaxBodySize(5))
+	assert.ErrorIs(t, err, ErrorBodyTooLarge)
+
+	// Test: And a body that ends with the connection, as soon as it grows
+	// past the limit
+	_, err = ResponseFromReader(io.MultiReader(
+		strings.NewReader("HTTP/1.1 200 OK\r\n\r\n"),
+		strings.NewReader("more than five bytes"),
+	), WithMaxBodySize(5))
+	assert.ErrorIs(t, err, ErrorBodyTooLarge)
+}
+
+func TestResponseHeadFromReader(t *testing.T) {
+	// Test: The headers are read first, then the body as it is read
+	reader := bufio.NewReaderSize(&chunkReader{
+		data: "HTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: x-count\r\n\r\n" +
+			"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Count: 2\r\n\r\nHTTP/1.1",
+		numBytesPerRead: 3,
+	}, 32)
+	r, err := ResponseHeadFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeOK, r.StatusLine.StatusCode)
+	require.Len(t, r.Interim, 1)
+	te, _ := r.Headers.Get(headers.TransferEncodingHeader)
+	assert.Equal(t, "chunked", te)
+	assert.Empty(t, r.Body)
+	assert.Empty(t, r.Trailers)
+
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	count, _ := r.Trailers.Get("x-count")
+	assert.Equal(t, "2", count)
+	assert.True(t, r.KeepAlive())
+
+	// Test: What follows the response is left in the reader
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1", string(rest))
+
+	// Test: A body that ends with the connection
+	r, err = ResponseHeadFromReader(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\n\r\nuntil the end")))
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+	assert.False(t, r.KeepAlive())
+
+	// Test: A body cut short
+	r, err = ResponseHeadFromReader(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.ErrorIs(t, err, ErrorIncompleteResponse)
+
+	// Test: The size limit applies to the body read so far
+	r, err = ResponseHeadFromReader(bufio.NewReaderSize(strings.NewReader("HTTP/1.1 200 OK\r\n\r\n"+strings.Repeat("x", 100)), 32), WithMaxBodySize(50))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.ErrorIs(t, err, ErrorBodyTooLarge)
+
+	// Test: A response without body
+	r, err = ResponseHeadFromReader(bufio.NewReader(strings.NewReader("HTTP/1.1 204 No Content\r\n\r\n")))
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// Test: A line longer than the buffer
+	_, err = ResponseHeadFromReader(bufio.NewReaderSize(strings.NewReader("HTTP/1.1 200 OK\r\nX-Long: "+strings.Repeat("x", 64)+"\r\n\r\n"), 16))
+	assert.ErrorIs(t, err, ErrorLineTooLong)
+}
+
+func TestResponseChunkedParse(t *testing.T) {
+	// Test: Chunks are put back together, extensions ignored
+	r, err := ResponseFromReader(&chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			"6\r\nhello \r\n" +
+			"5;name=value\r\nworld\r\n" +
+			"0\r\nX-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(r.Body))
+	checksum, ok := r.Trailers.Get("x-checksum")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", checksum)
+	assert.True(t, r.KeepAlive())
+
+	// Test: Transfer-Encoding wins over Content-Length
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\nContent-Length: 1\r\n\r\n3\r\nabc\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	assert.Empty(t, r.Trailers)
+
+	// Test: Invalid chunk size
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	assert.ErrorIs(t, err, ErrorChunkMalformed)
+
+	// Test: Chunk longer than its size
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabcd\r\n0\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrorChunkMalformed)
+
+	// Test: Missing last chunk
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n"))
+	assert.ErrorIs(t, err, ErrorIncompleteResponse)
+}
+
+func TestResponseInterimParse(t *testing.T) {
+	// Test: Interim responses are collected before the final one
+	r, err := ResponseFromReader(&chunkReader{
+		data: "HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 201 Created\r\nContent-Length: 4\r\n\r\ndone",
+		numBytesPerRead: 7,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeCreated, r.StatusLine.StatusCode)
+	assert.Equal(t, "done", string(r.Body))
+	_, ok := r.Headers.Get("link")
+	assert.False(t, ok)
+	require.Len(t, r.Interim, 2)
+	assert.Equal(t, StatusCodeContinue, r.Interim[0].StatusCode)
+	assert.Equal(t, StatusCodeEarlyHints, r.Interim[1].StatusCode)
+	link, _ := r.Interim[1].Headers.Get("link")
+	assert.Equal(t, "</style.css>; rel=preload", link)
+
+	// Test: 101 is final, what follows belongs to the new protocol
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n\x81\x02hi"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeSwitchingProtocols, r.StatusLine.StatusCode)
+	assert.Empty(t, r.Body)
+	assert.Equal(t, "\x81\x02hi", string(r.Buffered()))
+	assert.False(t, r.KeepAlive())
+}
+
+func TestResponseBodilessParse(t *testing.T) {
+	// Test: A response to HEAD has no body, whatever its headers say
+	r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\n"), WithRequestMethod(request.MethodHead))
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	assert.True(t, r.KeepAlive())
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"), WithRequestMethod(request.MethodHead))
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	// Test: Nor do 204 and 304
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 204 No Content\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 304 Not Modified\r\nContent-Length: 11\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+}
+
+func TestResponseKeepAlive(t *testing.T) {
+	// Test: Connection: close is honoured
+	r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nConnection: keep-alive, Close\r\nContent-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 connections are not reused
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+}
+
+func TestResponseFromWriter(t *testing.T) {
+	// Test: What the writer sends reads back the same
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	h := GetDefaultHeaders(0)
+	h.Remove(headers.ContentLengthHeader)
+	h.Override(headers.TransferEncodingHeader, "chunked")
+	require.NoError(t, w.DeclareTrailers("x-count"))
+	require.NoError(t, w.WriteStatusLine(StatusCodeEarlyHints))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"link": "</a.css>"}))
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"x-count": "2"}))
+
+	r, err := ResponseFromReader(buf)
+	require.NoError(t, err)
+	require.Len(t, r.Interim, 1)
+	assert.Equal(t, StatusCodeEarlyHints, r.Interim[0].StatusCode)
+	assert.Equal(t, StatusCodeOK, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	trailer, _ := r.Headers.Get(headers.TrailerHeader)
+	assert.Equal(t, "x-count", trailer)
+	assert.Equal(t, "hello world", string(r.Body))
+	count, _ := r.Trailers.Get("x-count")
+	assert.Equal(t, "2", count)
+	assert.Empty(t, r.Buffered())
+
+	// Test: So does an error response
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteErrorDetail(StatusCodeBadRequest, "missing host", nil))
+	r, err = ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeBadRequest, r.StatusLine.StatusCode)
+	assert.Equal(t, "missing host", string(r.Body))
+}
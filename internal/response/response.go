@@ -4,23 +4,57 @@ package response
 import (
 	"fmt"
 	"io"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/itsjoeoui/httpfromtcp/internal/common"
 	"github.com/itsjoeoui/httpfromtcp/internal/headers"
 )
 
-type StatusCode int
-
-const (
-	StatusCodeOK                  StatusCode = 200
-	StatusCodeBadRequest          StatusCode = 400
-	StatusCodeInternalServerError StatusCode = 500
-)
-
 type Writer struct {
 	writer io.Writer
 	state  WriterState
+	status StatusCode
+
+	// discardBody drops every body byte while still writing the status line,
+	// headers and chunk framing bookkeeping, e.g. for responses to HEAD.
+	discardBody bool
+
+	// defaultHeaders are added to the final response unless the handler set
+	// them itself.
+	defaultHeaders headers.Headers
+
+	// chunked is set once the final headers announced chunked framing.
+	chunked bool
+
+	// trailersRefused is set when the client did not announce "TE: trailers".
+	trailersRefused bool
+	// trailerNames are the declared trailer fields, in declaration order, and
+	// trailerValues the values set for them so far.
+	trailerNames  []string
+	trailerValues headers.Headers
+
+	// transform, if set, may rewrite the final response. body is the encoder
+	// it returned, which every body byte goes through until it is closed.
+	transform Transform
+	body      io.WriteCloser
+
+	// setCookies are the values of the Set-Cookie fields of the final
+	// response, each written on its own line.
+	setCookies []string
+
+	// errorRenderer, if set, produces the bodies of WriteError.
+	errorRenderer ErrorRenderer
+
+	// hijacker, if set, hands out the connection in Hijack.
+	hijacker Hijacker
+
+	// framer, if set, carries the response instead of the HTTP/1.1 syntax.
+	// writer is the framer too, so body bytes go through it unframed.
+	framer Framer
 }
+
 type WriterState string
 
 const (
@@ -28,15 +62,33 @@ const (
 	WriteStateHeaders    WriterState = "Headers"
 	WriteStateBody       WriterState = "Body"
 	WriteStateTrailer    WriterState = "Trailer"
+	WriteStateDone       WriterState = "Done"
+	WriteStateHijacked   WriterState = "Hijacked"
 )
 
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		writer: w,
-		state:  WriteStateStatusLine,
+		writer:         w,
+		state:          WriteStateStatusLine,
+		defaultHeaders: headers.NewHeaders(),
+		trailerValues:  headers.NewHeaders(),
 	}
 }
 
+// SetDefaultHeader registers a header that WriteHeaders adds to the final
+// response when the handler's headers don't already contain it. The server
+// uses it for Date and Server.
+func (w *Writer) SetDefaultHeader(key, value string) {
+	w.defaultHeaders.Override(key, value)
+}
+
+// DiscardBody makes the writer drop all body bytes and trailers, as required
+// for responses to HEAD requests. Headers are written as given, so a handler
+// that reports the Content-Length of its full body still advertises it.
+func (w *Writer) DiscardBody() {
+	w.discardBody = true
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if w.state != WriteStateStatusLine {
 		return ErrorInvalidResponseWriterState
@@ -45,31 +97,109 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 		w.state = WriteStateHeaders
 	}()
 
-	reasonPhrase, ok := statusCodeToReasonPhrase[statusCode]
-	if !ok {
-		reasonPhrase = "" // just leave it blank if unknown
+	w.status = statusCode
+	if w.framer != nil {
+		// sent along with the headers
+		return nil
 	}
 
-	_, err := fmt.Fprintf(w.writer, "HTTP/1.1 %d %s%s", statusCode, reasonPhrase, common.CRLF)
+	// the reason phrase is just left blank if unknown
+	_, err := fmt.Fprintf(w.writer, "HTTP/1.1 %d %s%s", statusCode, statusCode.ReasonPhrase(), common.CRLF)
 	return err
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+// Status returns the status code of the last status line written, or zero if
+// none was written yet.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+func (w *Writer) WriteHeaders(h headers.Headers) error {
 	if w.state != WriteStateHeaders {
 		return ErrorInvalidResponseWriterState
 	}
+	err := w.declareHeaderTrailers(h)
+	if err != nil {
+		return err
+	}
 	defer func() {
+		// an interim response is followed by another status line, except 101
+		// after which the connection no longer speaks HTTP/1.1
+		if w.status.IsInformational() && w.status != StatusCodeSwitchingProtocols {
+			w.state = WriteStateStatusLine
+			return
+		}
 		w.state = WriteStateBody
 	}()
 
-	for k, v := range headers {
-		_, err := fmt.Fprintf(w.writer, "%s: %s%s", k, v, common.CRLF)
+	if w.transform != nil && !w.status.IsInformational() {
+		h = w.applyTransform(h)
+	}
+
+	if te, ok := h.Get(headers.TransferEncodingHeader); ok && w.status.AllowsBody() {
+		w.chunked = strings.Contains(strings.ToLower(te), "chunked")
+	}
+
+	fields := headers.Headers{}
+	var setCookies []string
+	for k, v := range h {
+		if !w.status.allowsFraming() && isFramingHeader(k) {
+			continue
+		}
+		if k == headers.TrailerHeader {
+			// added below, merged with the declared trailers
+			continue
+		}
+		if strings.EqualFold(k, headers.SetCookieHeader) {
+			// on a line of its own, like those added with AddSetCookie
+			setCookies = append(setCookies, v)
+			continue
+		}
+		fields[k] = v
+	}
+
+	if len(w.trailerNames) > 0 && w.status.AllowsBody() {
+		fields[headers.TrailerHeader] = strings.Join(w.trailerNames, ", ")
+	}
+
+	if !w.status.IsInformational() {
+		for k, v := range w.defaultHeaders {
+			if _, ok := h.Get(k); ok {
+				continue
+			}
+			fields[k] = v
+		}
+	}
+
+	if w.status.IsInformational() {
+		setCookies = nil
+	} else {
+		setCookies = append(setCookies, w.setCookies...)
+	}
+
+	if w.framer != nil {
+		return w.framer.WriteHeaders(w.status, fields, setCookies)
+	}
+	return writeFields(w.writer, fields, setCookies)
+}
+
+// writeFields writes a header or trailer section, ending with the empty line.
+// setCookies are written after fields, one line each.
+func writeFields(dst io.Writer, fields headers.Headers, setCookies []string) error {
+	for k, v := range fields {
+		_, err := fmt.Fprintf(dst, "%s: %s%s", k, v, common.CRLF)
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range setCookies {
+		_, err := fmt.Fprintf(dst, "%s: %s%s", headers.SetCookieHeader, v, common.CRLF)
 		if err != nil {
 			return err
 		}
 	}
 
-	_, err := fmt.Fprintf(w.writer, common.CRLF)
+	_, err := fmt.Fprintf(dst, common.CRLF)
 	return err
 }
 
@@ -77,8 +207,17 @@ func (w *Writer) WriteBody(body []byte) (int, error) {
 	if w.state != WriteStateBody {
 		return 0, ErrorInvalidResponseWriterState
 	}
+	if !w.status.AllowsBody() {
+		return 0, ErrorBodyNotAllowed
+	}
+	if w.discardBody {
+		return len(body), nil
+	}
+	if w.body != nil {
+		return w.body.Write(body)
+	}
 
-	bytesWritten, err := fmt.Fprintf(w.writer, "%s", body)
+	bytesWritten, err := w.writer.Write(body)
 	if err != nil {
 		return 0, err
 	}
@@ -86,11 +225,85 @@ func (w *Writer) WriteBody(body []byte) (int, error) {
 	return bytesWritten, nil
 }
 
+// ReadFrom copies r to the body until EOF, using the framing announced in the
+// headers. For a fixed-length body without a transform the copy is handed to
+// the underlying connection, so a *net.TCPConn can move an *os.File (or an
+// io.LimitedReader around one) with sendfile or splice instead of copying
+// through user space.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.state != WriteStateBody {
+		return 0, ErrorInvalidResponseWriterState
+	}
+	if !w.status.AllowsBody() {
+		return 0, ErrorBodyNotAllowed
+	}
+	if w.discardBody {
+		return io.Copy(io.Discard, r)
+	}
+	if w.chunked || w.body != nil {
+		// hide ReadFrom so io.Copy doesn't call straight back into it
+		return io.Copy(writerOnly{w}, r)
+	}
+
+	return io.Copy(w.writer, r)
+}
+
+// WriteFile sends n bytes of f starting at offset as body content. See
+// ReadFrom for when the kernel can do the copy.
+func (w *Writer) WriteFile(f *os.File, offset, n int64) (int64, error) {
+	_, err := f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.ReadFrom(io.LimitReader(f, n))
+}
+
+type writerOnly struct {
+	io.Writer
+}
+
+// Write writes p as body content using the framing announced in the headers:
+// a chunk when Transfer-Encoding is chunked, the raw bytes otherwise. Unlike
+// WriteChunkedBody it counts only bytes of p, so a Writer can be handed to
+// anything that expects an io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	if !w.chunked || w.body != nil {
+		return w.WriteBody(p)
+	}
+	if len(p) == 0 {
+		// an empty chunk would end the body
+		return 0, nil
+	}
+
+	_, err := w.WriteChunkedBody(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.state != WriteStateBody {
 		return 0, ErrorInvalidResponseWriterState
 	}
+	if !w.status.AllowsBody() {
+		return 0, ErrorBodyNotAllowed
+	}
+	if w.discardBody {
+		return len(p), nil
+	}
+	if w.body != nil {
+		return w.body.Write(p)
+	}
+
+	return w.writeChunk(p)
+}
 
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if w.framer != nil {
+		return w.writer.Write(p)
+	}
 	return fmt.Fprintf(w.writer, "%x%s%s%s", len(p), common.CRLF, p, common.CRLF)
 }
 
@@ -98,33 +311,117 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.state != WriteStateBody {
 		return 0, ErrorInvalidResponseWriterState
 	}
+	if !w.status.AllowsBody() {
+		return 0, ErrorBodyNotAllowed
+	}
 	defer func() {
 		w.state = WriteStateTrailer
 	}()
+	if w.discardBody {
+		return 0, nil
+	}
+
+	err := w.closeTransform()
+	if err != nil {
+		return 0, err
+	}
+	if w.framer != nil {
+		return 0, nil
+	}
 
 	return fmt.Fprintf(w.writer, "0%s", common.CRLF)
 }
 
+// WriteTrailers ends a chunked body with the trailer section: the values set
+// with SetTrailer plus h, which may be nil. Fields that were not declared, or
+// any field at all if the client does not accept trailers, are left out and
+// reported in the returned error; the message is terminated either way.
 func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.state != WriteStateTrailer {
 		return ErrorInvalidResponseWriterState
 	}
-
-	for k, v := range h {
-		_, err := fmt.Fprintf(w.writer, "%s: %s%s", k, v, common.CRLF)
-		if err != nil {
-			return err
+	defer func() {
+		w.state = WriteStateDone
+	}()
+	if w.discardBody {
+		if w.framer != nil {
+			return w.framer.End(nil)
 		}
+		return nil
+	}
+
+	trailers, trailerErr := w.pendingTrailers(h)
+
+	var err error
+	if w.framer != nil {
+		err = w.framer.End(trailers)
+	} else {
+		err = writeFields(w.writer, trailers, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return trailerErr
+}
+
+// ErrorRenderer produces the body of an error response for statusCode. detail
+// explains what went wrong, or is empty. It sets Content-Type in h, along with
+// any other header the body depends on, such as Vary.
+type ErrorRenderer func(statusCode StatusCode, detail string, h headers.Headers) []byte
+
+// SetErrorRenderer makes WriteError and WriteErrorDetail render their bodies
+// with r instead of sending the reason phrase as plain text.
+func (w *Writer) SetErrorRenderer(r ErrorRenderer) {
+	w.errorRenderer = r
+}
+
+// WriteError writes a complete response for statusCode whose body is its
+// reason phrase, or whatever the error renderer makes of it. extra headers,
+// which may be nil, are added on top of the defaults.
+func (w *Writer) WriteError(statusCode StatusCode, extra headers.Headers) error {
+	return w.WriteErrorDetail(statusCode, "", extra)
+}
+
+// WriteErrorDetail is like WriteError, but explains what went wrong with
+// detail. Without an error renderer, detail replaces the reason phrase.
+func (w *Writer) WriteErrorDetail(statusCode StatusCode, detail string, extra headers.Headers) error {
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+
+	h := GetDefaultHeaders(0)
+	var body []byte
+	switch {
+	case !statusCode.AllowsBody():
+		// a 304 may only repeat the length of the content it stands for
+		h.Remove(headers.ContentLengthHeader)
+		h.Remove(headers.ContentTypeHeader)
+	case w.errorRenderer != nil:
+		body = w.errorRenderer(statusCode, detail, h)
+	case detail != "":
+		body = []byte(detail)
+	default:
+		body = []byte(strings.ToLower(statusCode.ReasonPhrase()))
+	}
+	if statusCode.AllowsBody() {
+		h.Override(headers.ContentLengthHeader, strconv.Itoa(len(body)))
+	}
+	for k, v := range extra {
+		h.Override(k, v)
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil || len(body) == 0 {
+		return err
 	}
 
-	_, err := fmt.Fprintf(w.writer, common.CRLF)
+	_, err = w.WriteBody(body)
 	return err
 }
 
-var statusCodeToReasonPhrase map[StatusCode]string = map[StatusCode]string{
-	StatusCodeOK:                  "OK",
-	StatusCodeBadRequest:          "Bad Request",
-	StatusCodeInternalServerError: "Internal Server Error",
+func isFramingHeader(key string) bool {
+	return key == headers.ContentLengthHeader || key == headers.TransferEncodingHeader
 }
 
 func GetDefaultHeaders(contentLength int) headers.Headers {
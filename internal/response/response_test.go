@@ -0,0 +1,314 @@
+package response
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/cookie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterDiscardBody(t *testing.T) {
+	// Test: Fixed length body is dropped, headers are kept
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.DiscardBody()
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Contains(t, buf.String(), "content-length: 5\r\n")
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n")))
+
+	// Test: Chunked body, terminator and trailers are dropped
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.DiscardBody()
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	headerLen := buf.Len()
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(map[string]string{"x-test": "1"}))
+	assert.Equal(t, headerLen, buf.Len())
+}
+
+func TestStatusCode(t *testing.T) {
+	assert.Equal(t, "Partial Content", StatusCodePartialContent.ReasonPhrase())
+	assert.Equal(t, "Too Many Requests", StatusCodeTooManyRequests.ReasonPhrase())
+	assert.Equal(t, "", StatusCode(599).ReasonPhrase())
+
+	assert.True(t, StatusCodeEarlyHints.IsInformational())
+	assert.True(t, StatusCodeNoContent.IsSuccess())
+	assert.True(t, StatusCodeNotModified.IsRedirection())
+	assert.True(t, StatusCodeMethodNotAllowed.IsClientError())
+	assert.True(t, StatusCodeServiceUnavailable.IsServerError())
+	assert.False(t, StatusCodeOK.IsClientError())
+
+	assert.True(t, StatusCodeOK.AllowsBody())
+	assert.False(t, StatusCodeContinue.AllowsBody())
+	assert.False(t, StatusCodeNoContent.AllowsBody())
+	assert.False(t, StatusCodeNotModified.AllowsBody())
+}
+
+func TestWriterBodylessStatus(t *testing.T) {
+	// Test: Unknown status codes get a blank reason phrase
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCode(299)))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: 204 drops framing headers and refuses a body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeNoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.NotContains(t, buf.String(), "content-length")
+	_, err := w.WriteBody([]byte("nope"))
+	require.ErrorIs(t, err, ErrorBodyNotAllowed)
+
+	// Test: 304 keeps Content-Length but refuses a body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotModified))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(42)))
+	assert.Contains(t, buf.String(), "content-length: 42\r\n")
+	_, err = w.WriteChunkedBody([]byte("nope"))
+	require.ErrorIs(t, err, ErrorBodyNotAllowed)
+
+	// Test: Interim responses are followed by the final one
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeContinue))
+	require.NoError(t, w.WriteHeaders(map[string]string{}))
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n")))
+	assert.Equal(t, StatusCodeOK, w.Status())
+}
+
+func TestWriterTrailers(t *testing.T) {
+	// Test: Declared trailers are announced and written
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.DeclareTrailers("X-Checksum"))
+	require.NoError(t, w.WriteHeaders(map[string]string{"transfer-encoding": "chunked"}))
+	assert.Contains(t, buf.String(), "trailer: x-checksum\r\n")
+	_, err := w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.WriteTrailers(nil))
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("2\r\nhi\r\n0\r\nx-checksum: abc\r\n\r\n")))
+
+	// Test: A Trailer header set by the handler declares its fields
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"trailer": "x-a, x-b"}))
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("trailer: x-a, x-b\r\n")))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(map[string]string{"x-a": "1", "x-b": "2"}))
+
+	// Test: Forbidden fields are rejected up front
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.ErrorIs(t, w.DeclareTrailers("Content-Length"), ErrorForbiddenTrailer)
+	require.ErrorIs(t, w.WriteHeaders(map[string]string{"trailer": "host"}), ErrorForbiddenTrailer)
+
+	// Test: Undeclared fields are dropped but the message is terminated
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.DeclareTrailers("x-a"))
+	require.ErrorIs(t, w.SetTrailer("x-b", "2"), ErrorUndeclaredTrailer)
+	require.NoError(t, w.WriteHeaders(map[string]string{}))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	err = w.WriteTrailers(map[string]string{"x-a": "1", "x-b": "2"})
+	require.ErrorIs(t, err, ErrorUndeclaredTrailer)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("0\r\nx-a: 1\r\n\r\n")))
+
+	// Test: Clients without "TE: trailers" get none
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetTrailersAccepted(false)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.ErrorIs(t, w.DeclareTrailers("x-a"), ErrorTrailersNotAccepted)
+	require.NoError(t, w.WriteHeaders(map[string]string{"trailer": "x-a"}))
+	assert.NotContains(t, buf.String(), "trailer:")
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.ErrorIs(t, w.WriteTrailers(map[string]string{"x-a": "1"}), ErrorTrailersNotAccepted)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("0\r\n\r\n")))
+}
+
+func TestWriterWrite(t *testing.T) {
+	// Test: Fixed length framing writes raw bytes
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello")))
+
+	// Test: Chunked framing writes chunks and skips empty writes
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"transfer-encoding": "chunked"}))
+	n, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, err = w.Write(nil)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n5\r\nhello\r\n")))
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "body")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+	_, err = f.WriteString("0123456789")
+	require.NoError(t, err)
+
+	// Test: Fixed length body from a file section
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(4)))
+	n, err := w.WriteFile(f, 3, 4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n3456")))
+
+	// Test: Chunked body is framed
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"transfer-encoding": "chunked"}))
+	n, err = w.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n5\r\nhello\r\n")))
+
+	// Test: Not before the headers
+	w = NewWriter(&bytes.Buffer{})
+	_, err = w.ReadFrom(strings.NewReader("hello"))
+	require.ErrorIs(t, err, ErrorInvalidResponseWriterState)
+}
+
+func TestWriterHijack(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	hijacker := func() (net.Conn, []byte, error) {
+		return server, []byte("early"), nil
+	}
+
+	// Test: Without a hijacker there's nothing to take over
+	w := NewWriter(&bytes.Buffer{})
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, ErrorHijackNotSupported)
+
+	// Test: The connection and buffered bytes are handed over
+	w = NewWriter(&bytes.Buffer{})
+	w.SetHijacker(hijacker)
+	conn, buffered, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.Equal(t, "early", string(buffered))
+	assert.True(t, w.Hijacked())
+
+	// Test: The writer is done with afterwards
+	assert.ErrorIs(t, w.WriteStatusLine(StatusCodeOK), ErrorInvalidResponseWriterState)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrorInvalidResponseWriterState)
+	require.NoError(t, w.Finish())
+
+	// Test: After the headers of a protocol switch
+	w = NewWriter(&bytes.Buffer{})
+	w.SetHijacker(hijacker)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSwitchingProtocols))
+	require.NoError(t, w.WriteHeaders(nil))
+	_, _, err = w.Hijack()
+	require.NoError(t, err)
+
+	// Test: Not halfway through a response
+	w = NewWriter(&bytes.Buffer{})
+	w.SetHijacker(hijacker)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrorInvalidResponseWriterState)
+
+	h := GetDefaultHeaders(0)
+	h.Override("transfer-encoding", "chunked")
+	h.Remove("content-length")
+	require.NoError(t, w.WriteHeaders(h))
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrorInvalidResponseWriterState)
+	assert.False(t, w.Hijacked())
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	// Test: Every Set-Cookie goes on a line of its own
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.SetCookie(&cookie.SetCookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true}))
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.AddSetCookie("theme=dark; Expires=Wed, 21 Oct 2026 07:28:00 GMT"))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.Contains(t, buf.String(), "\r\nset-cookie: session=abc; Path=/; HttpOnly\r\n")
+	assert.Contains(t, buf.String(), "\r\nset-cookie: theme=dark; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\n")
+
+	// Test: So does one set in the headers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.AddSetCookie("a=1"))
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	h := GetDefaultHeaders(0)
+	h.Set("Set-Cookie", "replaced=1")
+	h.Set("Set-Cookie", "b=2")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, 2, strings.Count(buf.String(), "set-cookie: "))
+	assert.Contains(t, buf.String(), "\r\nset-cookie: a=1\r\n")
+	assert.Contains(t, buf.String(), "\r\nset-cookie: b=2\r\n")
+	assert.NotContains(t, buf.String(), "replaced=1")
+
+	// Test: Not once the headers are out
+	err := w.SetCookie(&cookie.SetCookie{Name: "late", Value: "1"})
+	assert.ErrorIs(t, err, ErrorInvalidResponseWriterState)
+
+	// Test: Invalid cookies are refused
+	w = NewWriter(&bytes.Buffer{})
+	err = w.SetCookie(&cookie.SetCookie{Name: "bad name"})
+	assert.ErrorIs(t, err, cookie.ErrorInvalidName)
+	err = w.AddSetCookie("a=1\r\nx-injected: 1")
+	assert.ErrorIs(t, err, ErrorInvalidFieldValue)
+
+	// Test: Interim responses don't carry them
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.AddSetCookie("a=1"))
+	require.NoError(t, w.WriteStatusLine(StatusCodeEarlyHints))
+	require.NoError(t, w.WriteHeaders(nil))
+	assert.NotContains(t, buf.String(), "set-cookie")
+	require.NoError(t, w.WriteStatusLine(StatusCodeNoContent))
+	require.NoError(t, w.WriteHeaders(nil))
+	assert.Contains(t, buf.String(), "set-cookie: a=1\r\n")
+}
@@ -0,0 +1,158 @@
+package response
+
+type StatusCode int
+
+// Status codes registered by RFC 9110, plus the handful of widely deployed
+// ones defined elsewhere (RFC 8297, RFC 6585, RFC 4918).
+const (
+	StatusCodeContinue           StatusCode = 100
+	StatusCodeSwitchingProtocols StatusCode = 101
+	StatusCodeProcessing         StatusCode = 102
+	StatusCodeEarlyHints         StatusCode = 103
+
+	StatusCodeOK                   StatusCode = 200
+	StatusCodeCreated              StatusCode = 201
+	StatusCodeAccepted             StatusCode = 202
+	StatusCodeNonAuthoritativeInfo StatusCode = 203
+	StatusCodeNoContent            StatusCode = 204
+	StatusCodeResetContent         StatusCode = 205
+	StatusCodePartialContent       StatusCode = 206
+
+	StatusCodeMultipleChoices   StatusCode = 300
+	StatusCodeMovedPermanently  StatusCode = 301
+	StatusCodeFound             StatusCode = 302
+	StatusCodeSeeOther          StatusCode = 303
+	StatusCodeNotModified       StatusCode = 304
+	StatusCodeUseProxy          StatusCode = 305
+	StatusCodeTemporaryRedirect StatusCode = 307
+	StatusCodePermanentRedirect StatusCode = 308
+
+	StatusCodeBadRequest                  StatusCode = 400
+	StatusCodeUnauthorized                StatusCode = 401
+	StatusCodePaymentRequired             StatusCode = 402
+	StatusCodeForbidden                   StatusCode = 403
+	StatusCodeNotFound                    StatusCode = 404
+	StatusCodeMethodNotAllowed            StatusCode = 405
+	StatusCodeNotAcceptable               StatusCode = 406
+	StatusCodeProxyAuthRequired           StatusCode = 407
+	StatusCodeRequestTimeout              StatusCode = 408
+	StatusCodeConflict                    StatusCode = 409
+	StatusCodeGone                        StatusCode = 410
+	StatusCodeLengthRequired              StatusCode = 411
+	StatusCodePreconditionFailed          StatusCode = 412
+	StatusCodeContentTooLarge             StatusCode = 413
+	StatusCodeURITooLong                  StatusCode = 414
+	StatusCodeUnsupportedMediaType        StatusCode = 415
+	StatusCodeRangeNotSatisfiable         StatusCode = 416
+	StatusCodeExpectationFailed           StatusCode = 417
+	StatusCodeMisdirectedRequest          StatusCode = 421
+	StatusCodeUnprocessableContent        StatusCode = 422
+	StatusCodeUpgradeRequired             StatusCode = 426
+	StatusCodePreconditionRequired        StatusCode = 428
+	StatusCodeTooManyRequests             StatusCode = 429
+	StatusCodeRequestHeaderFieldsTooLarge StatusCode = 431
+
+	StatusCodeInternalServerError           StatusCode = 500
+	StatusCodeNotImplemented                StatusCode = 501
+	StatusCodeBadGateway                    StatusCode = 502
+	StatusCodeServiceUnavailable            StatusCode = 503
+	StatusCodeGatewayTimeout                StatusCode = 504
+	StatusCodeHTTPVersionNotSupported       StatusCode = 505
+	StatusCodeNetworkAuthenticationRequired StatusCode = 511
+)
+
+var statusCodeToReasonPhrase = map[StatusCode]string{
+	StatusCodeContinue:           "Continue",
+	StatusCodeSwitchingProtocols: "Switching Protocols",
+	StatusCodeProcessing:         "Processing",
+	StatusCodeEarlyHints:         "Early Hints",
+
+	StatusCodeOK:                   "OK",
+	StatusCodeCreated:              "Created",
+	StatusCodeAccepted:             "Accepted",
+	StatusCodeNonAuthoritativeInfo: "Non-Authoritative Information",
+	StatusCodeNoContent:            "No Content",
+	StatusCodeResetContent:         "Reset Content",
+	StatusCodePartialContent:       "Partial Content",
+
+	StatusCodeMultipleChoices:   "Multiple Choices",
+	StatusCodeMovedPermanently:  "Moved Permanently",
+	StatusCodeFound:             "Found",
+	StatusCodeSeeOther:          "See Other",
+	StatusCodeNotModified:       "Not Modified",
+	StatusCodeUseProxy:          "Use Proxy",
+	StatusCodeTemporaryRedirect: "Temporary Redirect",
+	StatusCodePermanentRedirect: "Permanent Redirect",
+
+	StatusCodeBadRequest:                  "Bad Request",
+	StatusCodeUnauthorized:                "Unauthorized",
+	StatusCodePaymentRequired:             "Payment Required",
+	StatusCodeForbidden:                   "Forbidden",
+	StatusCodeNotFound:                    "Not Found",
+	StatusCodeMethodNotAllowed:            "Method Not Allowed",
+	StatusCodeNotAcceptable:               "Not Acceptable",
+	StatusCodeProxyAuthRequired:           "Proxy Authentication Required",
+	StatusCodeRequestTimeout:              "Request Timeout",
+	StatusCodeConflict:                    "Conflict",
+	StatusCodeGone:                        "Gone",
+	StatusCodeLengthRequired:              "Length Required",
+	StatusCodePreconditionFailed:          "Precondition Failed",
+	StatusCodeContentTooLarge:             "Content Too Large",
+	StatusCodeURITooLong:                  "URI Too Long",
+	StatusCodeUnsupportedMediaType:        "Unsupported Media Type",
+	StatusCodeRangeNotSatisfiable:         "Range Not Satisfiable",
+	StatusCodeExpectationFailed:           "Expectation Failed",
+	StatusCodeMisdirectedRequest:          "Misdirected Request",
+	StatusCodeUnprocessableContent:        "Unprocessable Content",
+	StatusCodeUpgradeRequired:             "Upgrade Required",
+	StatusCodePreconditionRequired:        "Precondition Required",
+	StatusCodeTooManyRequests:             "Too Many Requests",
+	StatusCodeRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+
+	StatusCodeInternalServerError:           "Internal Server Error",
+	StatusCodeNotImplemented:                "Not Implemented",
+	StatusCodeBadGateway:                    "Bad Gateway",
+	StatusCodeServiceUnavailable:            "Service Unavailable",
+	StatusCodeGatewayTimeout:                "Gateway Timeout",
+	StatusCodeHTTPVersionNotSupported:       "HTTP Version Not Supported",
+	StatusCodeNetworkAuthenticationRequired: "Network Authentication Required",
+}
+
+// ReasonPhrase returns the registered reason phrase for c, or an empty string
+// for codes outside the registry.
+func (c StatusCode) ReasonPhrase() string {
+	return statusCodeToReasonPhrase[c]
+}
+
+func (c StatusCode) IsInformational() bool {
+	return c >= 100 && c < 200
+}
+
+func (c StatusCode) IsSuccess() bool {
+	return c >= 200 && c < 300
+}
+
+func (c StatusCode) IsRedirection() bool {
+	return c >= 300 && c < 400
+}
+
+func (c StatusCode) IsClientError() bool {
+	return c >= 400 && c < 500
+}
+
+func (c StatusCode) IsServerError() bool {
+	return c >= 500 && c < 600
+}
+
+// AllowsBody reports whether a response with this status may carry content.
+// RFC 9110 forbids it for 1xx, 204 and 304.
+func (c StatusCode) AllowsBody() bool {
+	return !c.IsInformational() && c != StatusCodeNoContent && c != StatusCodeNotModified
+}
+
+// allowsFraming reports whether Content-Length and Transfer-Encoding may be
+// sent with this status. A 304 may still announce the selected
+// representation's length, so only 1xx and 204 are stripped.
+func (c StatusCode) allowsFraming() bool {
+	return !c.IsInformational() && c != StatusCodeNoContent
+}
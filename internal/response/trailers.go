@@ -0,0 +1,119 @@
+package response
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+// SetTrailersAccepted records whether the client announced "TE: trailers".
+// Writers accept trailers until told otherwise; the server calls this for
+// every request.
+func (w *Writer) SetTrailersAccepted(accepted bool) {
+	w.trailersRefused = !accepted
+}
+
+// DeclareTrailers announces fields that will be sent after a chunked body.
+// It must be called before WriteHeaders, which adds the matching Trailer
+// header. Values are provided later with SetTrailer or WriteTrailers.
+func (w *Writer) DeclareTrailers(names ...string) error {
+	if w.state != WriteStateStatusLine && w.state != WriteStateHeaders {
+		return ErrorInvalidResponseWriterState
+	}
+	if w.trailersRefused {
+		return ErrorTrailersNotAccepted
+	}
+
+	for _, name := range names {
+		if headers.IsForbiddenTrailer(name) {
+			return fmt.Errorf("%w: %s", ErrorForbiddenTrailer, name)
+		}
+	}
+
+	for _, name := range names {
+		w.declareTrailer(name)
+	}
+	return nil
+}
+
+// SetTrailer sets the value of a declared trailer field. It is written by
+// WriteTrailers once the chunked body is done.
+func (w *Writer) SetTrailer(key, value string) error {
+	if w.trailersRefused {
+		return ErrorTrailersNotAccepted
+	}
+	if !w.isTrailerDeclared(key) {
+		return fmt.Errorf("%w: %s", ErrorUndeclaredTrailer, key)
+	}
+
+	w.trailerValues.Override(key, value)
+	return nil
+}
+
+func (w *Writer) declareTrailer(name string) {
+	name = strings.ToLower(name)
+	if !slices.Contains(w.trailerNames, name) {
+		w.trailerNames = append(w.trailerNames, name)
+	}
+}
+
+func (w *Writer) isTrailerDeclared(name string) bool {
+	return slices.Contains(w.trailerNames, strings.ToLower(name))
+}
+
+// declareHeaderTrailers moves the fields listed in a handler supplied Trailer
+// header into the declared set, so that WriteHeaders can announce the full
+// set in a single field.
+func (w *Writer) declareHeaderTrailers(h headers.Headers) error {
+	value, ok := h.Get(headers.TrailerHeader)
+	if !ok {
+		return nil
+	}
+
+	names := headers.SplitList(value)
+	for _, name := range names {
+		if headers.IsForbiddenTrailer(name) {
+			return fmt.Errorf("%w: %s", ErrorForbiddenTrailer, name)
+		}
+	}
+
+	if w.trailersRefused {
+		return nil
+	}
+	for _, name := range names {
+		w.declareTrailer(name)
+	}
+	return nil
+}
+
+// pendingTrailers merges h into the values set with SetTrailer and splits the
+// result into the fields that may be sent and an error for those that may not.
+func (w *Writer) pendingTrailers(h headers.Headers) (headers.Headers, error) {
+	merged := headers.NewHeaders()
+	for k, v := range w.trailerValues {
+		merged.Override(k, v)
+	}
+	for k, v := range h {
+		merged.Override(k, v)
+	}
+
+	if len(merged) > 0 && w.trailersRefused {
+		return headers.NewHeaders(), ErrorTrailersNotAccepted
+	}
+
+	var rejected []string
+	for k := range merged {
+		if !w.isTrailerDeclared(k) {
+			rejected = append(rejected, k)
+			merged.Remove(k)
+		}
+	}
+	if len(rejected) > 0 {
+		slices.Sort(rejected)
+		return merged, fmt.Errorf("%w: %s", ErrorUndeclaredTrailer, strings.Join(rejected, ", "))
+	}
+
+	return merged, nil
+}
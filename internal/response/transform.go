@@ -0,0 +1,126 @@
+package response
+
+import (
+	"io"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+// Transform rewrites the final response on its way to the client, e.g. to
+// compress its body.
+type Transform interface {
+	// Wrap is called with the final headers just before they are written. It
+	// may modify h and returns an encoder that writes the transformed body to
+	// dst, or nil to leave the body alone. The encoder is closed once the
+	// body is complete; anything it writes then still ends up in the body.
+	//
+	// If the encoder changes the length of the body, Wrap must remove
+	// Content-Length; the writer then switches to chunked framing.
+	Wrap(statusCode StatusCode, h headers.Headers, dst io.Writer) io.WriteCloser
+}
+
+// SetTransform installs t for the final response. It must be called before
+// WriteHeaders and replaces any previous transform.
+func (w *Writer) SetTransform(t Transform) {
+	w.transform = t
+}
+
+// applyTransform consults the transform with a copy of h and returns the
+// headers to send.
+func (w *Writer) applyTransform(h headers.Headers) headers.Headers {
+	transformed := headers.NewHeaders()
+	for k, v := range h {
+		transformed.Override(k, v)
+	}
+
+	w.body = w.transform.Wrap(w.status, transformed, bodyFramer{w})
+	if w.body == nil {
+		return transformed
+	}
+
+	_, hasLength := transformed.Get(headers.ContentLengthHeader)
+	_, hasEncoding := transformed.Get(headers.TransferEncodingHeader)
+	if !hasLength && !hasEncoding {
+		transformed.Override(headers.TransferEncodingHeader, "chunked")
+	}
+
+	return transformed
+}
+
+// closeTransform flushes and closes the active encoder, if any.
+func (w *Writer) closeTransform() error {
+	if w.body == nil {
+		return nil
+	}
+
+	body := w.body
+	w.body = nil
+	return body.Close()
+}
+
+// Flush pushes body bytes buffered by a transform to the connection, e.g.
+// after every event of a stream. Without a transform there's nothing to do.
+func (w *Writer) Flush() error {
+	if w.discardBody {
+		return nil
+	}
+
+	flusher, ok := w.body.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	return flusher.Flush()
+}
+
+// Finish completes the response once the handler is done: it closes an
+// active transform and, for a chunked body left open, writes the last chunk
+// and an empty trailer section. With a framer it ends any body too. Complete
+// responses are left alone.
+func (w *Writer) Finish() error {
+	switch w.state {
+	case WriteStateBody:
+		if !w.chunked || !w.status.AllowsBody() {
+			err := w.closeTransform()
+			if err != nil || w.framer == nil {
+				return err
+			}
+
+			w.state = WriteStateDone
+			return w.framer.End(nil)
+		}
+
+		_, err := w.WriteChunkedBodyDone()
+		if err != nil {
+			return err
+		}
+		return w.WriteTrailers(nil)
+	case WriteStateTrailer:
+		return w.WriteTrailers(nil)
+	default:
+		return nil
+	}
+}
+
+// bodyFramer is the destination of a transform: it frames the encoded bytes
+// the way the headers announced.
+type bodyFramer struct {
+	w *Writer
+}
+
+func (f bodyFramer) Write(p []byte) (int, error) {
+	if f.w.discardBody {
+		return len(p), nil
+	}
+	if !f.w.chunked || f.w.framer != nil {
+		return f.w.writer.Write(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	_, err := f.w.writeChunk(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
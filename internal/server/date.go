@@ -0,0 +1,36 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/clock"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+// dateCache formats the Date header at most once per second, no matter how
+// many responses are written in that second.
+type dateCache struct {
+	clock clock.Clock
+
+	mu        sync.Mutex
+	unixSec   int64
+	formatted string
+}
+
+func newDateCache(c clock.Clock) *dateCache {
+	return &dateCache{clock: c}
+}
+
+func (d *dateCache) get() string {
+	now := d.clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.formatted == "" || now.Unix() != d.unixSec {
+		d.unixSec = now.Unix()
+		d.formatted = headers.FormatTime(now)
+	}
+
+	return d.formatted
+}
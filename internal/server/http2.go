@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bufio"
+	"log"
+	"net"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/http2"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// WithHTTP2 serves HTTP/2 over cleartext too, to clients that start with the
+// connection preface or upgrade an HTTP/1.1 request to "h2c". Handlers see
+// those requests with HTTPVersion "2".
+func WithHTTP2(opts ...http2.Option) Option {
+	return func(s *Server) {
+		s.http2 = true
+		s.http2Options = opts
+	}
+}
+
+// hasHTTP2Preface reports whether the connection starts with the HTTP/2
+// client preface. It reads no further than the first byte that differs, so
+// that a short HTTP/1.1 request isn't waited on.
+func hasHTTP2Preface(br *bufio.Reader) bool {
+	for i := 1; i <= len(http2.ClientPreface); i++ {
+		b, err := br.Peek(i)
+		if err != nil || b[i-1] != http2.ClientPreface[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// serveHTTP2 speaks HTTP/2 on conn, which it closes once done. buffered holds
+// the bytes already read from conn.
+func (s *Server) serveHTTP2(conn net.Conn, buffered []byte, opts ...http2.Option) {
+	if s.maxBodySize > 0 {
+		opts = append(opts, http2.WithMaxBodySize(s.maxBodySize))
+	}
+	opts = append(opts, s.http2Options...)
+
+	err := http2.ServeConn(conn, buffered, s.handleStream, opts...)
+	if err != nil {
+		log.Printf("Failed to serve HTTP/2: %v", err)
+	}
+}
+
+// upgradeHTTP2 answers an "h2c" upgrade request with 101 and continues the
+// connection in HTTP/2, serving req on its first stream. A request with
+// malformed settings is refused with 400.
+func (s *Server) upgradeHTTP2(writer *response.Writer, req *request.Request) {
+	upgrade, err := http2.WithUpgrade(req)
+	if err != nil {
+		writeError(writer, response.StatusCodeBadRequest, nil)
+		return
+	}
+
+	h := headers.NewHeaders()
+	h.Set(headers.ConnectionHeader, "Upgrade")
+	h.Set(headers.UpgradeHeader, "h2c")
+	err = writer.WriteStatusLine(response.StatusCodeSwitchingProtocols)
+	if err != nil {
+		log.Printf("Failed to write 101 response: %v", err)
+		return
+	}
+	err = writer.WriteHeaders(h)
+	if err != nil {
+		log.Printf("Failed to write 101 response: %v", err)
+		return
+	}
+
+	conn, buffered, err := writer.Hijack()
+	if err != nil {
+		log.Printf("Failed to take over connection: %v", err)
+		return
+	}
+	s.serveHTTP2(conn, buffered, upgrade)
+}
+
+// handleStream serves a request read from an HTTP/2 stream, or answers with
+// an error status if it was refused.
+func (s *Server) handleStream(f response.Framer, req *request.Request, parseErr error) {
+	if parseErr != nil {
+		log.Printf("Failed to parse request: %v", parseErr)
+
+		writer := s.configureWriter(response.NewFramedWriter(f), nil)
+		statusCode, detail := parseErrorStatus(parseErr)
+		err := writer.WriteErrorDetail(statusCode, detail, nil)
+		if err != nil {
+			log.Printf("Failed to write %d response: %v", statusCode, err)
+		}
+		return
+	}
+
+	writer := s.configureWriter(response.NewFramedWriter(f), req)
+	// HTTP/2 clients take trailers whether or not they asked for them
+	writer.SetTrailersAccepted(true)
+	s.serve(writer, req)
+}
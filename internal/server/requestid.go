@@ -0,0 +1,39 @@
+package server
+
+import (
+	"crypto/rand"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+)
+
+// maxRequestIDLength bounds the X-Request-Id we take from clients, since it
+// ends up in logs and pages.
+const maxRequestIDLength = 128
+
+// requestID returns the X-Request-Id sent by the client, typically set by a
+// proxy in front of us so both logs can be correlated, or a new random one if
+// it is missing or doesn't look like an ID.
+func requestID(req *request.Request) string {
+	id, ok := req.Headers.Get(headers.XRequestIDHeader)
+	if ok && isValidRequestID(id) {
+		return id
+	}
+	return rand.Text()
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// Router dispatches requests to handlers by path prefix and method.
+//
+// The longest matching prefix wins. Routes registered with Handle accept any
+// method. HEAD requests run the GET route of a prefix unless a HEAD route was
+// registered for it explicitly; the server discards the body either way. A
+// path that only has routes for other methods is answered with 405.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	prefix  string
+	method  string
+	handler Handler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers h for every method under prefix.
+func (rt *Router) Handle(prefix string, h Handler) {
+	rt.HandleMethod("", prefix, h)
+}
+
+// HandleMethod registers h for a single method under prefix.
+func (rt *Router) HandleMethod(method, prefix string, h Handler) {
+	rt.routes = append(rt.routes, route{
+		prefix:  prefix,
+		method:  method,
+		handler: h,
+	})
+}
+
+// Route runs the handler that best matches req. It has the Handler signature
+// so a Router can be passed straight to Serve.
+func (rt *Router) Route(w *response.Writer, req *request.Request) {
+	path := req.Path()
+
+	h := rt.match(req.RequestLine.Method, path)
+	if h != nil {
+		h(w, req)
+		return
+	}
+
+	allowed := rt.allowedMethods(path)
+	if len(allowed) == 0 {
+		writeError(w, response.StatusCodeNotFound, nil)
+		return
+	}
+
+	extra := headers.NewHeaders()
+	extra.Set(headers.AllowHeader, strings.Join(allowed, ", "))
+	writeError(w, response.StatusCodeMethodNotAllowed, extra)
+}
+
+func (rt *Router) match(method, path string) Handler {
+	var best *route
+	bestRank := 0
+
+	for i := range rt.routes {
+		r := &rt.routes[i]
+		if !strings.HasPrefix(path, r.prefix) {
+			continue
+		}
+
+		rank := methodRank(r.method, method)
+		if rank == 0 {
+			continue
+		}
+
+		if best == nil ||
+			len(r.prefix) > len(best.prefix) ||
+			(len(r.prefix) == len(best.prefix) && rank > bestRank) {
+			best = r
+			bestRank = rank
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	return best.handler
+}
+
+// allowedMethods lists the methods registered for the longest prefix that
+// matches path.
+func (rt *Router) allowedMethods(path string) []string {
+	longest := -1
+	for _, r := range rt.routes {
+		if strings.HasPrefix(path, r.prefix) && len(r.prefix) > longest {
+			longest = len(r.prefix)
+		}
+	}
+
+	var allowed []string
+	for _, r := range rt.routes {
+		if len(r.prefix) != longest || !strings.HasPrefix(path, r.prefix) {
+			continue
+		}
+		if !slices.Contains(allowed, r.method) {
+			allowed = append(allowed, r.method)
+		}
+		if r.method == request.MethodGet && !slices.Contains(allowed, request.MethodHead) {
+			allowed = append(allowed, request.MethodHead)
+		}
+	}
+
+	slices.Sort(allowed)
+	return allowed
+}
+
+// methodRank scores how well a route registered for routeMethod serves a
+// request for method. Zero means it does not serve it at all.
+func methodRank(routeMethod, method string) int {
+	switch {
+	case routeMethod == method:
+		return 3
+	case method == request.MethodHead && routeMethod == request.MethodGet:
+		return 2
+	case routeMethod == "":
+		return 1
+	default:
+		return 0
+	}
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, extra headers.Headers) {
+	err := w.WriteError(statusCode, extra)
+	if err != nil {
+		log.Printf("Failed to write %d response: %v", statusCode, err)
+	}
+}
@@ -2,11 +2,19 @@
 package server
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"runtime/debug"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/itsjoeoui/httpfromtcp/internal/clock"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/http2"
 	"github.com/itsjoeoui/httpfromtcp/internal/request"
 	"github.com/itsjoeoui/httpfromtcp/internal/response"
 )
@@ -15,11 +23,80 @@ type Server struct {
 	listener       net.Listener
 	isServerClosed atomic.Bool
 	handler        Handler
+
+	clock      clock.Clock
+	dates      *dateCache
+	serverName string
+
+	errorRenderer ErrorRenderer
+	readTimeout   time.Duration
+	maxBodySize   int
+	// conns holds a token for every connection being served when the number
+	// of connections is limited.
+	conns chan struct{}
+
+	http2        bool
+	http2Options []http2.Option
 }
 
 type Handler func(w *response.Writer, req *request.Request)
 
-func Serve(handler Handler, port int) (*Server, error) {
+// ErrorRenderer produces the body of an error response, both for errors the
+// server runs into itself and for handlers calling WriteError. req is nil
+// when the request could not be parsed. See response.ErrorRenderer for the
+// other arguments.
+type ErrorRenderer func(req *request.Request, statusCode response.StatusCode, detail string, h headers.Headers) []byte
+
+// Option configures a Server in Serve.
+type Option func(*Server)
+
+// WithServerName makes every response carry a Server header with name, unless
+// the handler sets one itself.
+func WithServerName(name string) Option {
+	return func(s *Server) {
+		s.serverName = name
+	}
+}
+
+// WithClock replaces the system clock used for the Date header.
+func WithClock(c clock.Clock) Option {
+	return func(s *Server) {
+		s.clock = c
+	}
+}
+
+// WithErrorRenderer renders the bodies of error responses with r instead of
+// sending their reason phrase as plain text.
+func WithErrorRenderer(r ErrorRenderer) Option {
+	return func(s *Server) {
+		s.errorRenderer = r
+	}
+}
+
+// WithReadTimeout answers 408 when a request hasn't been read completely
+// within d of accepting the connection.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readTimeout = d
+	}
+}
+
+// WithMaxBodySize answers 413 to requests with a Content-Length over n bytes.
+func WithMaxBodySize(n int) Option {
+	return func(s *Server) {
+		s.maxBodySize = n
+	}
+}
+
+// WithMaxConnections answers 503 to connections beyond the first n served at
+// the same time.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		s.conns = make(chan struct{}, n)
+	}
+}
+
+func Serve(handler Handler, port int, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
@@ -28,7 +105,12 @@ func Serve(handler Handler, port int) (*Server, error) {
 	server := &Server{
 		listener: listener,
 		handler:  handler,
+		clock:    clock.System{},
+	}
+	for _, opt := range opts {
+		opt(server)
 	}
+	server.dates = newDateCache(server.clock)
 
 	go server.listen()
 
@@ -46,45 +128,224 @@ func (s *Server) listen() {
 			continue
 		}
 
-		go s.handle(conn)
+		if !s.acquire() {
+			go s.reject(conn)
+			continue
+		}
+
+		go func() {
+			defer s.release()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) acquire() bool {
+	if s.conns == nil {
+		return true
+	}
+
+	select {
+	case s.conns <- struct{}{}:
+		return true
+	default:
+		return false
 	}
 }
 
+func (s *Server) release() {
+	if s.conns != nil {
+		<-s.conns
+	}
+}
+
+// reject answers a connection over the limit with 503 without reading its
+// request.
+func (s *Server) reject(conn net.Conn) {
+	defer closeConn(conn)
+
+	writer := s.newWriter(conn, nil)
+	extra := headers.NewHeaders()
+	extra.Set(headers.RetryAfterHeader, "1")
+	writeError(writer, response.StatusCodeServiceUnavailable, extra)
+}
+
+// newWriter returns a writer with the server's default headers and error
+// renderer. req is nil until the request has been parsed.
+func (s *Server) newWriter(conn net.Conn, req *request.Request) *response.Writer {
+	return s.configureWriter(response.NewWriter(conn), req)
+}
+
+func (s *Server) configureWriter(writer *response.Writer, req *request.Request) *response.Writer {
+	writer.SetDefaultHeader(headers.DateHeader, s.dates.get())
+	if s.serverName != "" {
+		writer.SetDefaultHeader(headers.ServerHeader, s.serverName)
+	}
+	if s.errorRenderer != nil {
+		writer.SetErrorRenderer(func(statusCode response.StatusCode, detail string, h headers.Headers) []byte {
+			return s.errorRenderer(req, statusCode, detail, h)
+		})
+	}
+	return writer
+}
+
 func (s *Server) handle(conn net.Conn) {
+	hijacked := false
 	defer func() {
-		err := conn.Close()
-		if err != nil {
-			log.Printf("Failed to close connection: %v", err)
+		// a hijacked connection belongs to the handler
+		if !hijacked {
+			closeConn(conn)
 		}
 	}()
 
-	writer := response.NewWriter(conn)
-
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		log.Printf("Failed to parse request: %v", err)
-
-		err := writer.WriteStatusLine(response.StatusCodeBadRequest)
+	if s.readTimeout > 0 {
+		err := conn.SetReadDeadline(time.Now().Add(s.readTimeout))
 		if err != nil {
-			log.Printf("Failed to write status line: %v", err)
+			log.Printf("Failed to set read deadline: %v", err)
 		}
+	}
 
-		body := []byte(err.Error())
+	br := bufio.NewReader(conn)
+	if s.http2 && hasHTTP2Preface(br) {
+		// the HTTP/2 connection closes itself
+		hijacked = true
+		s.clearReadDeadline(conn)
+		s.serveHTTP2(conn, unread(br, nil))
+		return
+	}
 
-		headers := response.GetDefaultHeaders(len(body))
-		err = writer.WriteHeaders(headers)
-		if err != nil {
-			log.Printf("Failed to write headers: %v", err)
-		}
+	var opts []request.Option
+	if s.maxBodySize > 0 {
+		opts = append(opts, request.WithMaxBodySize(s.maxBodySize))
+	}
 
-		_, err = writer.WriteBody(body)
+	req, parseErr := request.RequestFromReader(br, opts...)
+	if parseErr != nil {
+		log.Printf("Failed to parse request: %v", parseErr)
+
+		writer := s.newWriter(conn, nil)
+		statusCode, detail := parseErrorStatus(parseErr)
+		err := writer.WriteErrorDetail(statusCode, detail, nil)
 		if err != nil {
-			log.Printf("Failed to write body: %v", err)
+			log.Printf("Failed to write %d response: %v", statusCode, err)
 		}
 		return
 	}
 
+	// the handler decides how long it waits for anything else
+	s.clearReadDeadline(conn)
+
+	req.RemoteAddr = conn.RemoteAddr().String()
+	writer := s.newWriter(conn, req)
+	writer.SetTrailersAccepted(acceptsTrailers(req))
+	writer.SetHijacker(func() (net.Conn, []byte, error) {
+		hijacked = true
+		return conn, unread(br, req.Buffered()), nil
+	})
+
+	if s.http2 && http2.IsUpgrade(req) {
+		s.upgradeHTTP2(writer, req)
+		return
+	}
+	s.serve(writer, req)
+}
+
+// serve runs the handler for req and completes whatever response it left
+// open.
+func (s *Server) serve(writer *response.Writer, req *request.Request) {
+	req.ID = requestID(req)
+	writer.SetDefaultHeader(headers.XRequestIDHeader, req.ID)
+
+	if req.RequestLine.Method == request.MethodHead {
+		writer.DiscardBody()
+	}
+
+	defer recoverHandler(writer, req)
 	s.handler(writer, req)
+
+	err := writer.Finish()
+	if err != nil {
+		log.Printf("Failed to finish response: %v", err)
+	}
+}
+
+func (s *Server) clearReadDeadline(conn net.Conn) {
+	if s.readTimeout == 0 {
+		return
+	}
+
+	err := conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("Failed to clear read deadline: %v", err)
+	}
+}
+
+// unread returns the bytes the client sent that weren't consumed yet:
+// buffered, read past the request, followed by what br still holds.
+func unread(br *bufio.Reader, buffered []byte) []byte {
+	rest, _ := br.Peek(br.Buffered())
+	if len(rest) == 0 {
+		return buffered
+	}
+	return append(buffered[:len(buffered):len(buffered)], rest...)
+}
+
+// parseErrorStatus picks the status code, and the detail to show, for a
+// request that could not be read.
+func parseErrorStatus(err error) (response.StatusCode, string) {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return response.StatusCodeRequestTimeout, ""
+	case errors.Is(err, request.ErrorBodyTooLarge):
+		return response.StatusCodeContentTooLarge, err.Error()
+	default:
+		return response.StatusCodeBadRequest, err.Error()
+	}
+}
+
+// recoverHandler turns a panicking handler into a 500, or just a closed
+// connection if the handler already started its response. A hijacked
+// connection is the handler's problem.
+func recoverHandler(w *response.Writer, req *request.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	log.Printf("Handler panicked serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+
+	if w.Hijacked() || (w.Status() != 0 && !w.Status().IsInformational()) {
+		return
+	}
+	writeError(w, response.StatusCodeInternalServerError, nil)
+}
+
+func closeConn(conn net.Conn) {
+	err := conn.Close()
+	if err != nil {
+		log.Printf("Failed to close connection: %v", err)
+	}
+}
+
+// acceptsTrailers reports whether the client sent "TE: trailers".
+func acceptsTrailers(req *request.Request) bool {
+	te, ok := req.Headers.Get(headers.TEHeader)
+	if !ok {
+		return false
+	}
+
+	for _, coding := range headers.SplitList(te) {
+		name, _, _ := strings.Cut(coding, ";")
+		if strings.EqualFold(strings.TrimSpace(name), "trailers") {
+			return true
+		}
+	}
+	return false
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 func (s *Server) Close() error {
@@ -0,0 +1,441 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/clock"
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip starts a server for handler, sends raw over a new connection and
+// returns everything the server wrote back before closing it.
+func roundTrip(t *testing.T, handler Handler, raw string, opts ...Option) string {
+	t.Helper()
+
+	s, err := Serve(handler, 0, opts...)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(resp)
+}
+
+func textHandler(body string) Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	}
+}
+
+func TestServerHead(t *testing.T) {
+	// Test: HEAD runs the GET handler without sending its body
+	resp := roundTrip(t, textHandler("hello world"), "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, resp, "content-length: 11\r\n")
+	assert.NotContains(t, resp, "hello world")
+
+	// Test: GET still sends the body
+	resp = roundTrip(t, textHandler("hello world"), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "\r\n\r\nhello world")
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	router.Handle("/", textHandler("root"))
+	router.Handle("/video", textHandler("video"))
+	router.HandleMethod(request.MethodGet, "/files", textHandler("files get"))
+	router.HandleMethod(request.MethodHead, "/files", textHandler("files head"))
+	router.HandleMethod(request.MethodGet, "/only-get", textHandler("only get"))
+
+	cases := []struct {
+		method, target, want string
+	}{
+		{"GET", "/", "root"},
+		{"GET", "/video/clip?t=10", "video"},
+		{"POST", "/video", "video"},
+		{"GET", "/files/a.txt", "files get"},
+		{"HEAD", "/files/a.txt", "files head"},
+		{"HEAD", "/only-get", "only get"},
+		{"POST", "/only-get", "root"},
+		{"GET", "http://localhost/video/clip?t=10", "video"},
+		{"GET", "http://localhost?t=10", "root"},
+	}
+
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		router.Route(response.NewWriter(buf), &request.Request{
+			RequestLine: request.RequestLine{Method: c.method, RequestTarget: c.target},
+		})
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"+c.want), "%s %s", c.method, c.target)
+	}
+
+	// Test: Only other methods are registered for the path
+	buf := &bytes.Buffer{}
+	onlyPost := NewRouter()
+	onlyPost.HandleMethod(request.MethodPost, "/submit", textHandler("submitted"))
+	onlyPost.HandleMethod(request.MethodGet, "/submit", textHandler("form"))
+	onlyPost.Route(response.NewWriter(buf), &request.Request{
+		RequestLine: request.RequestLine{Method: request.MethodDelete, RequestTarget: "/submit"},
+	})
+	assert.Contains(t, buf.String(), "HTTP/1.1 405 Method Not Allowed\r\n")
+	assert.Contains(t, buf.String(), "allow: GET, HEAD, POST\r\n")
+
+	// Test: No route at all
+	resp := roundTrip(t, NewRouter().Route, "GET / HTTP/1.1\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 404 Not Found\r\n")
+
+	// Test: Absolute-form targets are routed by their path
+	resp = roundTrip(t, router.Route, "GET http://localhost/video/clip HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nvideo"))
+}
+
+func TestServerDefaultHeaders(t *testing.T) {
+	fake := clock.NewFake(time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC))
+
+	// Test: Date and Server are added
+	resp := roundTrip(t, textHandler("hi"), "GET / HTTP/1.1\r\n\r\n",
+		WithClock(fake), WithServerName("httpfromtcp"))
+	assert.Contains(t, resp, "date: Sun, 06 Nov 1994 08:49:37 GMT\r\n")
+	assert.Contains(t, resp, "server: httpfromtcp\r\n")
+
+	// Test: No Server header unless configured
+	resp = roundTrip(t, textHandler("hi"), "GET / HTTP/1.1\r\n\r\n", WithClock(fake))
+	assert.NotContains(t, resp, "server:")
+
+	// Test: Headers set by the handler win
+	handler := func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		h := response.GetDefaultHeaders(0)
+		h.Set(headers.DateHeader, "Thu, 01 Jan 1970 00:00:00 GMT")
+		h.Set(headers.ServerHeader, "custom")
+		_ = w.WriteHeaders(h)
+	}
+	resp = roundTrip(t, handler, "GET / HTTP/1.1\r\n\r\n", WithClock(fake), WithServerName("httpfromtcp"))
+	assert.Contains(t, resp, "date: Thu, 01 Jan 1970 00:00:00 GMT\r\n")
+	assert.Contains(t, resp, "server: custom\r\n")
+	assert.Equal(t, 1, strings.Count(resp, "date:"))
+	assert.Equal(t, 1, strings.Count(resp, "server:"))
+}
+
+func TestServerRequestID(t *testing.T) {
+	var seen string
+	handler := func(w *response.Writer, r *request.Request) {
+		seen = r.ID
+		textHandler("hi")(w, r)
+	}
+
+	// Test: The client's ID is kept
+	resp := roundTrip(t, handler, "GET / HTTP/1.1\r\nX-Request-Id: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", seen)
+	assert.Contains(t, resp, "x-request-id: abc-123\r\n")
+
+	// Test: One is generated otherwise
+	resp = roundTrip(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Len(t, seen, 26)
+	assert.Contains(t, resp, "x-request-id: "+seen+"\r\n")
+	first := seen
+
+	roundTrip(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.NotEqual(t, first, seen)
+
+	// Test: IDs that don't look like one are replaced
+	roundTrip(t, handler, "GET / HTTP/1.1\r\nX-Request-Id: <script>\r\n\r\n")
+	assert.Len(t, seen, 26)
+}
+
+func TestDateCache(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	dates := newDateCache(fake)
+
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", dates.get())
+
+	// Test: Sub-second changes reuse the cached value
+	fake.Advance(999 * time.Millisecond)
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", dates.get())
+
+	// Test: A new second refreshes it
+	fake.Advance(time.Millisecond)
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:01 GMT", dates.get())
+
+	// Test: Non-UTC clocks are formatted in GMT
+	fake.Set(time.Date(2024, time.March, 1, 7, 0, 5, 0, time.FixedZone("EST", -5*60*60)))
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:05 GMT", dates.get())
+}
+
+func TestServerTrailers(t *testing.T) {
+	handler := func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		declared := w.DeclareTrailers("x-done") == nil
+		_ = w.WriteHeaders(map[string]string{headers.TransferEncodingHeader: "chunked"})
+		_, _ = w.WriteChunkedBodyDone()
+		if declared {
+			_ = w.SetTrailer("x-done", "yes")
+		}
+		_ = w.WriteTrailers(nil)
+	}
+
+	// Test: Trailers are sent when the client asks for them
+	resp := roundTrip(t, handler, "GET / HTTP/1.1\r\nTE: deflate;q=0.5, trailers\r\n\r\n")
+	assert.Contains(t, resp, "trailer: x-done\r\n")
+	assert.True(t, strings.HasSuffix(resp, "0\r\nx-done: yes\r\n\r\n"))
+
+	// Test: And withheld otherwise
+	resp = roundTrip(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.NotContains(t, resp, "x-done")
+	assert.True(t, strings.HasSuffix(resp, "0\r\n\r\n"))
+}
+
+func TestServerChunkedRequest(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		checksum, _ := req.Trailers.Get("x-checksum")
+		body := string(req.Body) + " " + checksum
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	}
+
+	// Test: Chunked uploads reach the handler whole, with their trailers
+	resp := roundTrip(t, echo, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello world abc"))
+}
+
+func TestServerErrors(t *testing.T) {
+	renderer := func(req *request.Request, statusCode response.StatusCode, detail string, h headers.Headers) []byte {
+		h.Override(headers.ContentTypeHeader, "text/html")
+		path := "?"
+		if req != nil {
+			path = req.RequestLine.RequestTarget
+		}
+		return []byte("<p>" + statusCode.ReasonPhrase() + " " + path + " " + detail + "</p>")
+	}
+
+	// Test: Unparsable requests keep their plain text detail by default
+	resp := roundTrip(t, textHandler("hi"), "NOPE\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nrequest line malformed"))
+
+	// Test: And are rendered without a request otherwise
+	resp = roundTrip(t, textHandler("hi"), "NOPE\r\n\r\n", WithErrorRenderer(renderer))
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+	assert.Contains(t, resp, "content-type: text/html\r\n")
+	assert.True(t, strings.HasSuffix(resp, "<p>Bad Request ? request line malformed</p>"))
+
+	// Test: WriteError in handlers uses the renderer too
+	resp = roundTrip(t, NewRouter().Route, "GET /missing HTTP/1.1\r\n\r\n", WithErrorRenderer(renderer))
+	assert.Contains(t, resp, "HTTP/1.1 404 Not Found\r\n")
+	assert.Contains(t, resp, "content-length: 26\r\n")
+	assert.True(t, strings.HasSuffix(resp, "<p>Not Found /missing </p>"))
+
+	// Test: Panics become 500
+	panicky := func(*response.Writer, *request.Request) {
+		panic("boom")
+	}
+	resp = roundTrip(t, panicky, "GET / HTTP/1.1\r\n\r\n", WithErrorRenderer(renderer))
+	assert.Contains(t, resp, "HTTP/1.1 500 Internal Server Error\r\n")
+	assert.True(t, strings.HasSuffix(resp, "<p>Internal Server Error / </p>"))
+
+	// Test: Unless the response had already started
+	halfway := func(w *response.Writer, _ *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		panic("boom")
+	}
+	resp = roundTrip(t, halfway, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+
+	// Test: Bodies over the limit
+	resp = roundTrip(t, textHandler("hi"), "POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\n", WithMaxBodySize(10))
+	assert.Contains(t, resp, "HTTP/1.1 413 Content Too Large\r\n")
+	resp = roundTrip(t, textHandler("hi"), "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n20\r\n", WithMaxBodySize(10))
+	assert.Contains(t, resp, "HTTP/1.1 413 Content Too Large\r\n")
+
+	// Test: Bodies whose end can't be told
+	resp = roundTrip(t, textHandler("hi"), "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+
+	// Test: Requests that take too long
+	resp = roundTrip(t, textHandler("hi"), "GET / HTTP/1.1\r\n", WithReadTimeout(50*time.Millisecond))
+	assert.Contains(t, resp, "HTTP/1.1 408 Request Timeout\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nrequest timeout"))
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	release := make(chan struct{})
+	handler := func(w *response.Writer, r *request.Request) {
+		<-release
+		textHandler("hi")(w, r)
+	}
+
+	s, err := Serve(handler, 0, WithMaxConnections(1))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	first, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = first.Close()
+	}()
+	_, err = io.WriteString(first, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+
+	// Test: A second connection is turned away while the first is served
+	require.Eventually(t, func() bool {
+		return len(s.conns) == 1
+	}, time.Second, time.Millisecond)
+
+	second, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = second.Close()
+	}()
+	resp, err := io.ReadAll(second)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 503 Service Unavailable\r\n")
+	assert.Contains(t, string(resp), "retry-after: 1\r\n")
+
+	// Test: The first one still completes
+	close(release)
+	resp, err = io.ReadAll(first)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 200 OK\r\n")
+}
+
+func TestServerHijack(t *testing.T) {
+	conns := make(chan net.Conn, 1)
+	handler := func(w *response.Writer, r *request.Request) {
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+
+		// the handler may leave the connection to someone else
+		go func() {
+			greeting := make([]byte, len("hello"))
+			_, err := io.ReadFull(io.MultiReader(bytes.NewReader(buffered), conn), greeting)
+			if err != nil {
+				t.Errorf("Failed to read from hijacked connection: %v", err)
+			}
+			_, _ = io.WriteString(conn, "raw "+string(greeting))
+			conns <- conn
+		}()
+	}
+
+	s, err := Serve(handler, 0)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// Test: Bytes sent along with the request reach the new owner
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nhel")
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "lo")
+	require.NoError(t, err)
+
+	got := make([]byte, len("raw hello"))
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	assert.Equal(t, "raw hello", string(got))
+
+	// Test: The server neither answers nor closes the connection itself
+	hijacked := <-conns
+	_, err = io.WriteString(hijacked, "still here")
+	require.NoError(t, err)
+	require.NoError(t, hijacked.Close())
+
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "still here", string(rest))
+}
+
+func TestServerHTTP2(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		body := req.RequestLine.HTTPVersion + " " + req.ID
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody([]byte(body))
+	}
+	s, err := Serve(handler, 0, WithHTTP2(), WithServerName("test"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	// Test: a client with prior knowledge is served over HTTP/2
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}, Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + s.Addr().String() + "/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, "test", resp.Header.Get("Server"))
+	assert.NotEmpty(t, resp.Header.Get("Date"))
+	assert.Equal(t, "2 "+resp.Header.Get("X-Request-Id"), string(body))
+
+	// Test: HTTP/1.1 requests are still served as before
+	resp1 := roundTrip(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", WithHTTP2())
+	assert.Contains(t, resp1, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, resp1, "\r\n\r\n1.1 ")
+
+	// Test: an h2c upgrade is answered with 101 and the server's SETTINGS
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	upgraded, err := response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeSwitchingProtocols, upgraded.StatusLine.StatusCode)
+	upgrade, _ := upgraded.Headers.Get(headers.UpgradeHeader)
+	assert.Equal(t, "h2c", upgrade)
+	frameHeader := make([]byte, 9)
+	_, err = io.ReadFull(io.MultiReader(bytes.NewReader(upgraded.Buffered()), reader), frameHeader)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x4), frameHeader[3])
+
+	// Test: an upgrade with malformed settings is refused
+	resp1 = roundTrip(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: ???\r\n\r\n", WithHTTP2())
+	assert.Contains(t, resp1, "HTTP/1.1 400 Bad Request\r\n")
+}
@@ -0,0 +1,8 @@
+package sse
+
+import "errors"
+
+var (
+	ErrorInvalidField = errors.New("event field contains a line break")
+	ErrorStreamClosed = errors.New("event stream already closed")
+)
@@ -0,0 +1,174 @@
+package sse
+
+import (
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+const (
+	// DefaultHistory is how many recent events a Hub keeps for clients that
+	// reconnect.
+	DefaultHistory = 100
+	// DefaultBuffer is how many events may queue up for a subscriber before
+	// it is dropped.
+	DefaultBuffer = 16
+)
+
+// Hub fans events out to every subscribed client.
+//
+// It keeps the most recent events so that a client reconnecting with
+// Last-Event-ID gets the ones it missed. A subscriber that can't keep up is
+// dropped rather than holding everyone else back; its client reconnects and
+// catches up from the history.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	history     []Event
+	nextID      uint64
+	closed      bool
+
+	maxHistory int
+	buffer     int
+	heartbeat  time.Duration
+}
+
+// HubOption configures a Hub in NewHub.
+type HubOption func(*Hub)
+
+// WithHistory replaces DefaultHistory.
+func WithHistory(n int) HubOption {
+	return func(h *Hub) {
+		h.maxHistory = n
+	}
+}
+
+// WithBuffer replaces DefaultBuffer.
+func WithBuffer(n int) HubOption {
+	return func(h *Hub) {
+		h.buffer = n
+	}
+}
+
+// WithHeartbeat replaces DefaultHeartbeat for the streams of Serve.
+func WithHeartbeat(d time.Duration) HubOption {
+	return func(h *Hub) {
+		h.heartbeat = d
+	}
+}
+
+func NewHub(opts ...HubOption) *Hub {
+	h := &Hub{
+		subscribers: map[chan Event]struct{}{},
+		maxHistory:  DefaultHistory,
+		buffer:      DefaultBuffer,
+		heartbeat:   DefaultHeartbeat,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Publish sends e to every subscriber. Events without an ID get the next
+// number of a sequence, so that clients can resume after them.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	h.nextID++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(h.nextID, 10)
+	}
+
+	if h.maxHistory > 0 {
+		if len(h.history) == h.maxHistory {
+			h.history = slices.Delete(h.history, 0, 1)
+		}
+		h.history = append(h.history, e)
+	}
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// too slow, it catches up from the history after reconnecting
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel of the events published from now on, preceded
+// by those in the history after lastEventID. All of the history is replayed
+// for an ID it doesn't contain (anymore), none of it for "". The channel is
+// closed by unsubscribe, when the subscriber falls behind or when the hub is
+// closed.
+func (h *Hub) Subscribe(lastEventID string) (events <-chan Event, unsubscribe func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	if lastEventID != "" {
+		i := slices.IndexFunc(h.history, func(e Event) bool {
+			return e.ID == lastEventID
+		})
+		missed = h.history[i+1:]
+	}
+
+	ch := make(chan Event, len(missed)+h.buffer)
+	for _, e := range missed {
+		ch <- e
+	}
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Serve has the server.Handler signature: it streams the hub's events to the
+// client until it disconnects or the hub is closed.
+func (h *Hub) Serve(w *response.Writer, req *request.Request) {
+	stream, err := NewStream(w, req)
+	if err != nil {
+		log.Printf("Failed to start event stream: %v", err)
+		return
+	}
+
+	events, unsubscribe := h.Subscribe(stream.LastEventID())
+	defer unsubscribe()
+
+	// a write error just means the client is gone
+	_ = stream.Serve(events, h.heartbeat)
+}
+
+// Close ends every subscription and drops events published afterwards.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
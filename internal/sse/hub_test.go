@@ -0,0 +1,134 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+)
+
+func drain(events <-chan Event) []string {
+	var ids []string
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return ids
+			}
+			ids = append(ids, e.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func subscribers(h *Hub) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+func TestHub(t *testing.T) {
+	h := NewHub(WithHistory(3), WithBuffer(2))
+
+	// Test: Subscribers get what is published from then on, with IDs
+	first, unsubscribe := h.Subscribe("")
+	h.Publish(Event{Data: "a"})
+	h.Publish(Event{ID: "custom", Data: "b"})
+	assert.Equal(t, []string{"1", "custom"}, drain(first))
+
+	// Test: Reconnecting clients get the events they missed
+	for _, data := range []string{"c", "d", "e"} {
+		h.Publish(Event{Data: data})
+		<-first
+	}
+	resumed, unsubscribeResumed := h.Subscribe("3")
+	assert.Equal(t, []string{"4", "5"}, drain(resumed))
+	unsubscribeResumed()
+
+	// Test: All of the history when theirs is too old
+	resumed, unsubscribeResumed = h.Subscribe("1")
+	assert.Equal(t, []string{"3", "4", "5"}, drain(resumed))
+	unsubscribeResumed()
+
+	// Test: Subscribers that fall behind are dropped
+	h.Publish(Event{Data: "f"})
+	h.Publish(Event{Data: "g"})
+	h.Publish(Event{Data: "h"})
+	assert.Equal(t, []string{"6", "7"}, drain(first))
+	_, ok := <-first
+	assert.False(t, ok)
+	assert.Equal(t, 0, subscribers(h))
+	unsubscribe()
+
+	// Test: Closing the hub ends every subscription
+	last, _ := h.Subscribe("")
+	h.Close()
+	_, ok = <-last
+	assert.False(t, ok)
+	late, _ := h.Subscribe("")
+	_, ok = <-late
+	assert.False(t, ok)
+}
+
+func TestHubServe(t *testing.T) {
+	h := NewHub(WithHeartbeat(10 * time.Millisecond))
+	defer h.Close()
+
+	s, err := server.Serve(h.Serve, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	connect := func(lastEventID string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+
+		_, err = io.WriteString(conn, "GET /events HTTP/1.1\r\nLast-Event-ID: "+lastEventID+"\r\n\r\n")
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return conn, bufio.NewReader(resp.Body)
+	}
+	// next returns the next non-comment line
+	next := func(r *bufio.Reader) string {
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			if line != "\n" && line[0] != ':' {
+				return line
+			}
+		}
+	}
+
+	// Test: Published events reach the client
+	conn, r := connect("")
+	require.Eventually(t, func() bool {
+		return subscribers(h) == 1
+	}, time.Second, time.Millisecond)
+	h.Publish(Event{Data: "hello"})
+	assert.Equal(t, "id: 1\n", next(r))
+	assert.Equal(t, "data: hello\n", next(r))
+
+	// Test: The subscription ends once the client is gone
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		return subscribers(h) == 0
+	}, time.Second, time.Millisecond)
+
+	// Test: Reconnecting with Last-Event-ID replays what was missed
+	h.Publish(Event{Data: "missed"})
+	conn, r = connect("1")
+	defer conn.Close()
+	assert.Equal(t, "id: 2\n", next(r))
+	assert.Equal(t, "data: missed\n", next(r))
+}
@@ -0,0 +1,181 @@
+// Package sse streams Server-Sent Events (the text/event-stream format of the
+// HTML standard) to clients, one at a time or through a Hub.
+package sse
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+const ContentType = "text/event-stream"
+
+// DefaultHeartbeat is how long a stream may stay silent before a comment is
+// sent, which keeps intermediaries from dropping the connection and tells us
+// when the client is gone.
+const DefaultHeartbeat = 15 * time.Second
+
+// Event is a single event. Every field is optional.
+type Event struct {
+	// ID is what the client sends back in Last-Event-ID when it reconnects.
+	ID string
+	// Event is the event type, "message" when empty.
+	Event string
+	// Data may span several lines.
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// writeTo formats e as it is sent: a line per field followed by an empty
+// line.
+func (e Event) writeTo(b *strings.Builder) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return ErrorInvalidField
+	}
+
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	// an event without data lines isn't dispatched, which is what an ID or
+	// Retry on its own is for
+	if e.Data != "" || e.Event != "" {
+		for _, line := range splitLines(e.Data) {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return nil
+}
+
+// splitLines splits s at CRLF, CR or LF, the line breaks the client
+// recognizes.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+// Stream sends events to a single client over a chunked response.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+	// head is set for HEAD requests, which only get the headers.
+	head   bool
+	closed bool
+}
+
+// NewStream starts the event stream response to req.
+func NewStream(w *response.Writer, req *request.Request) (*Stream, error) {
+	err := w.WriteStatusLine(response.StatusCodeOK)
+	if err != nil {
+		return nil, err
+	}
+
+	h := response.GetDefaultHeaders(0)
+	h.Remove(headers.ContentLengthHeader)
+	h.Override(headers.ContentTypeHeader, ContentType)
+	h.Override(headers.CacheControlHeader, "no-cache")
+	h.Override(headers.TransferEncodingHeader, "chunked")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get(headers.LastEventIDHeader)
+	return &Stream{
+		w:           w,
+		lastEventID: lastEventID,
+		head:        req.RequestLine.Method == request.MethodHead,
+	}, nil
+}
+
+// LastEventID returns the ID of the last event the client saw before it
+// reconnected, or "" on its first connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send writes e and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	var b strings.Builder
+	err := e.writeTo(&b)
+	if err != nil {
+		return err
+	}
+	return s.write(b.String())
+}
+
+// Comment writes a comment, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+func (s *Stream) write(p string) error {
+	if s.closed {
+		return ErrorStreamClosed
+	}
+
+	_, err := s.w.Write([]byte(p))
+	if err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// Serve sends the events received from events until the channel is closed or
+// the client goes away, and a comment whenever heartbeat passes without one.
+// It returns nil once events is closed, or the error that cut the client off.
+// For HEAD requests it returns right away.
+func (s *Stream) Serve(events <-chan Event, heartbeat time.Duration) error {
+	if s.head {
+		return nil
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			err := s.Send(e)
+			if err != nil {
+				return err
+			}
+			ticker.Reset(heartbeat)
+		case <-ticker.C:
+			err := s.Comment("heartbeat")
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Close ends the response. Clients reconnect after a closed stream unless
+// told otherwise, e.g. with a 204 on their next request.
+func (s *Stream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.w.Finish()
+}
@@ -0,0 +1,109 @@
+package sse
+
+import (
+	"bytes"
+	"io"
+	"net/http/httputil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// newTestStream starts a stream for the request raw, written to the returned
+// buffer.
+func newTestStream(t *testing.T, raw string) (*Stream, *bytes.Buffer) {
+	t.Helper()
+
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	if req.RequestLine.Method == request.MethodHead {
+		w.DiscardBody()
+	}
+	s, err := NewStream(w, req)
+	require.NoError(t, err)
+	return s, buf
+}
+
+// body splits the response in buf into its head, every line of which ends
+// with CRLF, and dechunked body.
+func body(t *testing.T, buf *bytes.Buffer) (string, string) {
+	t.Helper()
+
+	head, chunked, ok := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, ok)
+	head += "\r\n"
+	b, err := io.ReadAll(httputil.NewChunkedReader(strings.NewReader(chunked)))
+	require.NoError(t, err)
+	return head, string(b)
+}
+
+func TestStream(t *testing.T) {
+	s, buf := newTestStream(t, "GET /events HTTP/1.1\r\nLast-Event-ID: 41\r\n\r\n")
+	assert.Equal(t, "41", s.LastEventID())
+
+	// Test: Every field, with data over several lines
+	require.NoError(t, s.Send(Event{ID: "42", Event: "update", Data: "one\ntwo\r\nthree\rfour", Retry: 2500 * time.Millisecond}))
+	// Test: Data on its own, empty lines included
+	require.NoError(t, s.Send(Event{Data: "\nhi\n"}))
+	// Test: An ID on its own isn't dispatched
+	require.NoError(t, s.Send(Event{ID: "43"}))
+	// Test: An event type with empty data still is
+	require.NoError(t, s.Send(Event{Event: "ping"}))
+	require.NoError(t, s.Comment("still\nhere"))
+
+	// Test: Line breaks can't sneak in new fields
+	assert.ErrorIs(t, s.Send(Event{ID: "1\ndata: injected"}), ErrorInvalidField)
+	assert.ErrorIs(t, s.Send(Event{Event: "a\rb"}), ErrorInvalidField)
+
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, s.Send(Event{Data: "late"}), ErrorStreamClosed)
+
+	head, b := body(t, buf)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head, "content-type: text/event-stream\r\n")
+	assert.Contains(t, head, "cache-control: no-cache\r\n")
+	assert.Contains(t, head, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, head, "content-length")
+	assert.Equal(t, "retry: 2500\nid: 42\nevent: update\ndata: one\ndata: two\ndata: three\ndata: four\n\n"+
+		"data: \ndata: hi\ndata: \n\n"+
+		"id: 43\n\n"+
+		"event: ping\ndata: \n\n"+
+		": still\n: here\n\n", b)
+}
+
+func TestStreamServe(t *testing.T) {
+	// Test: Events are sent until the channel is closed, with heartbeats
+	// while it is quiet
+	s, buf := newTestStream(t, "GET /events HTTP/1.1\r\n\r\n")
+
+	events := make(chan Event)
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(events, 10*time.Millisecond)
+	}()
+	events <- Event{Data: "first"}
+	time.Sleep(35 * time.Millisecond)
+	events <- Event{Data: "second"}
+	close(events)
+	require.NoError(t, <-done)
+	require.NoError(t, s.Close())
+
+	_, b := body(t, buf)
+	assert.True(t, strings.HasPrefix(b, "data: first\n\n: heartbeat\n\n"))
+	assert.True(t, strings.HasSuffix(b, ": heartbeat\n\ndata: second\n\n"))
+
+	// Test: HEAD only gets the headers
+	s, buf = newTestStream(t, "HEAD /events HTTP/1.1\r\n\r\n")
+	require.NoError(t, s.Serve(make(chan Event), time.Hour))
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.Contains(t, buf.String(), "content-type: text/event-stream\r\n")
+}
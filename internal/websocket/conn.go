@@ -0,0 +1,276 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a complete message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = MessageType(opText)
+	BinaryMessage MessageType = MessageType(opBinary)
+)
+
+// Close status codes (RFC 6455 section 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	// CloseNoStatus is reported when a close frame carries no code; it is
+	// never sent.
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// Conn is the server side of a WebSocket connection.
+//
+// One goroutine may read messages while others write them: writes are
+// serialized, including the pongs and close frames ReadMessage sends on its
+// own.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	maxMessageSize int
+	// compress is set when permessage-deflate was negotiated, level is the
+	// compression level of outgoing messages.
+	compress bool
+	level    int
+
+	mu        sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, buffered []byte, cfg config, compress bool) *Conn {
+	var r io.Reader = conn
+	if len(buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+
+	return &Conn{
+		conn:           conn,
+		reader:         bufio.NewReader(r),
+		maxMessageSize: cfg.maxMessageSize,
+		compress:       compress,
+		level:          cfg.level,
+	}
+}
+
+// ReadMessage returns the next complete message, reassembled from its
+// fragments and decompressed. It answers pings on the way.
+//
+// Once the peer closes the connection, ReadMessage answers its close frame,
+// closes the connection and returns a *CloseError. If the peer breaks the
+// protocol, sends invalid UTF-8 as text or a message over the size limit, it
+// sends a close frame with the matching status, closes the connection and
+// returns ErrorProtocol, ErrorInvalidPayload or ErrorMessageTooBig.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		typ        MessageType
+		message    []byte
+		started    bool
+		compressed bool
+	)
+
+	for {
+		f, err := readFrame(c.reader, c.maxMessageSize-len(message))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		if !f.masked {
+			// clients must mask every frame (RFC 6455 section 5.1)
+			return 0, nil, c.fail(ErrorProtocol)
+		}
+		if f.rsv1 && (!c.compress || f.op != opText && f.op != opBinary) {
+			return 0, nil, c.fail(ErrorProtocol)
+		}
+
+		switch f.op {
+		case opPing:
+			err = c.writeControl(opPong, f.payload)
+			if err != nil && !errors.Is(err, ErrorClosed) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if started {
+				// the previous message isn't finished
+				return 0, nil, c.fail(ErrorProtocol)
+			}
+			typ, message, started, compressed = MessageType(f.op), f.payload, true, f.rsv1
+		case opContinuation:
+			if !started {
+				return 0, nil, c.fail(ErrorProtocol)
+			}
+			message = append(message, f.payload...)
+		default:
+			return 0, nil, c.fail(ErrorProtocol)
+		}
+
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = decompressMessage(message, c.maxMessageSize)
+			if err != nil {
+				return 0, nil, c.fail(err)
+			}
+		}
+		if typ == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(ErrorInvalidPayload)
+		}
+		return typ, message, nil
+	}
+}
+
+// WriteMessage sends data as a single, unfragmented message.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	f := frame{fin: true, op: opcode(typ), payload: data}
+	if c.compress {
+		compressed, err := compressMessage(data, c.level)
+		if err != nil {
+			return err
+		}
+		f.rsv1, f.payload = true, compressed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return ErrorClosed
+	}
+	return writeFrame(c.conn, f)
+}
+
+// Ping sends a ping with up to 125 bytes of data, e.g. to keep intermediaries
+// from dropping an idle connection. The client's pong is dropped.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// WriteClose starts the close handshake with code and a reason of up to 123
+// bytes. Nothing can be written afterwards; keep reading until ReadMessage
+// returns the client's answer as a *CloseError.
+func (c *Conn) WriteClose(code int, reason string) error {
+	return c.writeControl(opClose, closePayload(code, reason))
+}
+
+// Close closes the connection without a close handshake. It is safe to call
+// after ReadMessage already closed it.
+func (c *Conn) Close() error {
+	err := c.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) writeControl(op opcode, payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return ErrorControlTooLong
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return ErrorClosed
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+	return writeFrame(c.conn, frame{fin: true, op: op, payload: payload})
+}
+
+// handleClose answers a close frame from the client, unless it is the answer
+// to ours, and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(ErrorProtocol)
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !isValidCloseCode(closeErr.Code) {
+			return c.fail(ErrorProtocol)
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(ErrorInvalidPayload)
+		}
+	}
+
+	var answer []byte
+	if closeErr.Code != CloseNoStatus {
+		answer = closePayload(closeErr.Code, "")
+	}
+	err := c.writeControl(opClose, answer)
+	if err != nil && !errors.Is(err, ErrorClosed) {
+		log.Printf("Failed to answer close frame: %v", err)
+	}
+
+	_ = c.Close()
+	return closeErr
+}
+
+// fail closes the connection after telling the client why, if err is a
+// violation on its part. Other errors are returned as is.
+func (c *Conn) fail(err error) error {
+	code := 0
+	switch {
+	case errors.Is(err, ErrorProtocol):
+		code = CloseProtocolError
+	case errors.Is(err, ErrorInvalidPayload):
+		code = CloseInvalidPayload
+	case errors.Is(err, ErrorMessageTooBig):
+		code = CloseMessageTooBig
+	default:
+		return err
+	}
+
+	writeErr := c.WriteClose(code, "")
+	if writeErr != nil && !errors.Is(writeErr, ErrorClosed) {
+		log.Printf("Failed to send close frame: %v", writeErr)
+	}
+	_ = c.Close()
+	return err
+}
+
+// closePayload encodes the body of a close frame.
+func closePayload(code int, reason string) []byte {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+// isValidCloseCode reports whether a client may send code in a close frame:
+// the codes RFC 6455 and IANA define for use on the wire, and the ranges for
+// libraries and applications.
+func isValidCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
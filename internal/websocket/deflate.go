@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+)
+
+const deflateExtension = "permessage-deflate"
+
+// deflateResponse accepts permessage-deflate (RFC 7692) without context
+// takeover: every message is compressed on its own, so neither side keeps a
+// window around between messages.
+const deflateResponse = deflateExtension + "; server_no_context_takeover; client_no_context_takeover"
+
+var (
+	// deflateTail ends the output of a flush. It is left out of compressed
+	// messages and added back before decompressing them.
+	deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+	// finalBlock is an empty final stored block, so the decompressor stops
+	// at the end of a message.
+	finalBlock = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+// acceptsDeflate reports whether an offer in the Sec-WebSocket-Extensions
+// value of a handshake is satisfied by deflateResponse. Offers that limit
+// the window of our compressor are declined, since flate always uses the
+// full 32 KiB.
+func acceptsDeflate(extensions string) bool {
+	for _, offer := range headers.SplitList(extensions) {
+		name, params, _ := strings.Cut(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), deflateExtension) {
+			continue
+		}
+		if acceptsDeflateParams(params) {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsDeflateParams(params string) bool {
+	for param := range strings.SplitSeq(params, ";") {
+		key, _, _ := strings.Cut(param, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "", "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// compressMessage deflates p as a message of its own.
+func compressMessage(p []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fw.Write(p)
+	if err != nil {
+		return nil, err
+	}
+	err = fw.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// decompressMessage inflates a compressed message, failing with
+// ErrorMessageTooBig once it grows past limit.
+func decompressMessage(p []byte, limit int) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail), bytes.NewReader(finalBlock)))
+	defer fr.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fr, int64(limit)+1))
+	if err != nil {
+		return nil, ErrorInvalidPayload
+	}
+	if len(data) > limit {
+		return nil, ErrorMessageTooBig
+	}
+	return data, nil
+}
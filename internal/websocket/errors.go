@@ -0,0 +1,32 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorUpgradeRequired    = errors.New("no upgrade to websocket requested")
+	ErrorBadHandshake       = errors.New("invalid websocket handshake")
+	ErrorUnsupportedVersion = errors.New("unsupported websocket version")
+
+	ErrorProtocol       = errors.New("websocket protocol violation")
+	ErrorInvalidPayload = errors.New("invalid message payload")
+	ErrorMessageTooBig  = errors.New("message exceeds the size limit")
+
+	ErrorControlTooLong = errors.New("control frame payload exceeds 125 bytes")
+	ErrorClosed         = errors.New("close frame already sent")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed with status %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed with status %d: %s", e.Code, e.Reason)
+}
@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xa
+)
+
+// isControl reports whether op is a control frame: close, ping or pong.
+func (op opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// maxControlPayload is the largest payload of a control frame.
+const maxControlPayload = 125
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	maskBit = 0x80
+)
+
+// frame is a single WebSocket frame (RFC 6455 section 5.2).
+type frame struct {
+	fin bool
+	// rsv1 marks the first frame of a compressed message.
+	rsv1    bool
+	op      opcode
+	masked  bool
+	maskKey [4]byte
+	payload []byte
+}
+
+// readFrame reads a frame from r and unmasks its payload. Data frames longer
+// than maxPayload are refused before their payload is read.
+func readFrame(r io.Reader, maxPayload int) (frame, error) {
+	var f frame
+
+	var head [2]byte
+	_, err := io.ReadFull(r, head[:])
+	if err != nil {
+		return f, err
+	}
+	if head[0]&0x30 != 0 {
+		// RSV2 and RSV3 belong to extensions we never negotiate
+		return f, ErrorProtocol
+	}
+	f.fin = head[0]&finBit != 0
+	f.rsv1 = head[0]&rsv1Bit != 0
+	f.op = opcode(head[0] & 0x0f)
+	f.masked = head[1]&maskBit != 0
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return f, err
+	}
+
+	if f.op.isControl() && (length > maxControlPayload || !f.fin) {
+		return f, ErrorProtocol
+	}
+	if !f.op.isControl() && length > uint64(maxPayload) {
+		return f, ErrorMessageTooBig
+	}
+
+	if f.masked {
+		_, err = io.ReadFull(r, f.maskKey[:])
+		if err != nil {
+			return f, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(r, f.payload)
+	if err != nil {
+		return f, err
+	}
+	if f.masked {
+		mask(f.maskKey, f.payload)
+	}
+
+	return f, nil
+}
+
+// writeFrame writes f to w in a single call, masking a copy of its payload
+// if f.masked is set.
+func writeFrame(w io.Writer, f frame) error {
+	buf := make([]byte, 0, 14+len(f.payload))
+
+	b := byte(f.op)
+	if f.fin {
+		b |= finBit
+	}
+	if f.rsv1 {
+		b |= rsv1Bit
+	}
+	buf = append(buf, b)
+
+	var maskFlag byte
+	if f.masked {
+		maskFlag = maskBit
+	}
+	switch length := len(f.payload); {
+	case length < 126:
+		buf = append(buf, maskFlag|byte(length))
+	case length <= 0xffff:
+		buf = append(buf, maskFlag|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, maskFlag|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
+	}
+
+	if f.masked {
+		buf = append(buf, f.maskKey[:]...)
+		start := len(buf)
+		buf = append(buf, f.payload...)
+		mask(f.maskKey, buf[start:])
+	} else {
+		buf = append(buf, f.payload...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// mask applies key to b in place. Masking twice restores the original.
+func mask(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
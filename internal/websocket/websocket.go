@@ -0,0 +1,159 @@
+// Package websocket upgrades requests to WebSocket connections (RFC 6455)
+// and exchanges messages over them.
+package websocket
+
+import (
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/headers"
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+)
+
+// version is the only protocol version clients may ask for.
+const version = "13"
+
+// acceptGUID is appended to the client's key to compute
+// Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize is the largest message ReadMessage accepts.
+const DefaultMaxMessageSize = 1 << 20
+
+type config struct {
+	maxMessageSize int
+	compression    bool
+	level          int
+}
+
+// Option configures Upgrade.
+type Option func(*config)
+
+// WithMaxMessageSize replaces DefaultMaxMessageSize. It applies to messages
+// once decompressed.
+func WithMaxMessageSize(n int) Option {
+	return func(c *config) {
+		c.maxMessageSize = n
+	}
+}
+
+// WithCompression accepts permessage-deflate when the client offers it, and
+// then compresses outgoing messages at level, from flate.BestSpeed to
+// flate.BestCompression.
+func WithCompression(level int) Option {
+	return func(c *config) {
+		c.compression = true
+		c.level = level
+	}
+}
+
+// Upgrade completes the opening handshake of req with 101 Switching
+// Protocols and takes over the connection.
+//
+// A request that isn't a valid handshake is answered with 426 Upgrade
+// Required when it doesn't ask for the protocol, or for another version of
+// it, or 400 otherwise, and Upgrade returns the reason.
+func Upgrade(w *response.Writer, req *request.Request, opts ...Option) (*Conn, error) {
+	cfg := config{
+		maxMessageSize: DefaultMaxMessageSize,
+		level:          flate.DefaultCompression,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	key, err := checkHandshake(req)
+	if err != nil {
+		rejectHandshake(w, err)
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h.Set(headers.UpgradeHeader, "websocket")
+	h.Set(headers.ConnectionHeader, "Upgrade")
+	h.Set(headers.SecWebSocketAcceptHeader, acceptKey(key))
+
+	compress := false
+	if extensions, ok := req.Headers.Get(headers.SecWebSocketExtensionsHeader); ok && cfg.compression {
+		compress = acceptsDeflate(extensions)
+	}
+	if compress {
+		h.Set(headers.SecWebSocketExtensionsHeader, deflateResponse)
+	}
+
+	err = w.WriteStatusLine(response.StatusCodeSwitchingProtocols)
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, buffered, cfg, compress), nil
+}
+
+// checkHandshake validates the opening handshake (RFC 6455 section 4.2.1)
+// and returns the client's key.
+func checkHandshake(req *request.Request) (string, error) {
+	upgrade, _ := req.Headers.Get(headers.UpgradeHeader)
+	connection, _ := req.Headers.Get(headers.ConnectionHeader)
+	if !hasToken(upgrade, "websocket") || !hasToken(connection, "upgrade") {
+		return "", ErrorUpgradeRequired
+	}
+	if req.RequestLine.Method != request.MethodGet {
+		return "", fmt.Errorf("%w: method %s", ErrorBadHandshake, req.RequestLine.Method)
+	}
+	if v, _ := req.Headers.Get(headers.SecWebSocketVersionHeader); v != version {
+		return "", fmt.Errorf("%w: %q", ErrorUnsupportedVersion, v)
+	}
+
+	key, _ := req.Headers.Get(headers.SecWebSocketKeyHeader)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return "", fmt.Errorf("%w: invalid key %q", ErrorBadHandshake, key)
+	}
+	return key, nil
+}
+
+// rejectHandshake answers a request that failed checkHandshake.
+func rejectHandshake(w *response.Writer, err error) {
+	statusCode := response.StatusCodeBadRequest
+	extra := headers.NewHeaders()
+	if errors.Is(err, ErrorUpgradeRequired) || errors.Is(err, ErrorUnsupportedVersion) {
+		statusCode = response.StatusCodeUpgradeRequired
+		extra.Set(headers.UpgradeHeader, "websocket")
+		extra.Set(headers.SecWebSocketVersionHeader, version)
+	}
+
+	writeErr := w.WriteErrorDetail(statusCode, err.Error(), extra)
+	if writeErr != nil {
+		log.Printf("Failed to write %d response: %v", statusCode, writeErr)
+	}
+}
+
+// acceptKey computes Sec-WebSocket-Accept for the client's key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether the comma separated list value contains token.
+func hasToken(value, token string) bool {
+	for _, v := range headers.SplitList(value) {
+		if strings.EqualFold(v, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/itsjoeoui/httpfromtcp/internal/request"
+	"github.com/itsjoeoui/httpfromtcp/internal/response"
+	"github.com/itsjoeoui/httpfromtcp/internal/server"
+)
+
+// sampleKey is the key of the example handshake in RFC 6455 section 1.3.
+const sampleKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+var testMaskKey = [4]byte{0x37, 0xfa, 0x21, 0x3d}
+
+// echo upgrades every request and sends each message back. The error that
+// ended the connection is sent to errs, if given.
+func echo(errs chan<- error, opts ...Option) server.Handler {
+	return func(w *response.Writer, r *request.Request) {
+		conn, err := Upgrade(w, r, opts...)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err == nil {
+				err = conn.WriteMessage(typ, msg)
+			}
+			if err != nil {
+				if errs != nil {
+					errs <- err
+				}
+				return
+			}
+		}
+	}
+}
+
+// dial starts a server for handler and completes the opening handshake with
+// it, sending extra header lines along.
+func dial(t *testing.T, handler server.Handler, extra string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+
+	s, err := server.Serve(handler, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	_, err = io.WriteString(conn, "GET /chat HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+sampleKey+"\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		extra+"\r\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	return conn, reader, resp
+}
+
+func send(t *testing.T, conn net.Conn, f frame) {
+	t.Helper()
+
+	f.masked, f.maskKey = true, testMaskKey
+	require.NoError(t, writeFrame(conn, f))
+}
+
+func receive(t *testing.T, r io.Reader) frame {
+	t.Helper()
+
+	f, err := readFrame(r, 1<<30)
+	require.NoError(t, err)
+	assert.False(t, f.masked, "server frames are not masked")
+	return f
+}
+
+func closeCode(t *testing.T, f frame) int {
+	t.Helper()
+
+	require.Equal(t, opClose, f.op)
+	require.GreaterOrEqual(t, len(f.payload), 2)
+	return int(binary.BigEndian.Uint16(f.payload))
+}
+
+func TestUpgrade(t *testing.T) {
+	// Test: The handshake is accepted with the key from the RFC
+	_, _, resp := dial(t, echo(nil), "")
+	assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	// Test: Compression is only accepted when enabled
+	_, _, resp = dial(t, echo(nil), "Sec-WebSocket-Extensions: permessage-deflate\r\n")
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	_, _, resp = dial(t, echo(nil, WithCompression(flate.BestSpeed)), "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	assert.Equal(t, deflateResponse, resp.Header.Get("Sec-WebSocket-Extensions"))
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	const valid = "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: " + sampleKey + "\r\n"
+
+	cases := []struct {
+		name, raw string
+		want      error
+		status    string
+	}{
+		{"plain request", "GET / HTTP/1.1\r\n\r\n", ErrorUpgradeRequired, "426 Upgrade Required"},
+		{"other upgrade", "GET / HTTP/1.1\r\nUpgrade: h2c\r\nConnection: Upgrade\r\n\r\n", ErrorUpgradeRequired, "426 Upgrade Required"},
+		{"no version", "GET / HTTP/1.1\r\n" + valid + "\r\n", ErrorUnsupportedVersion, "426 Upgrade Required"},
+		{"old version", "GET / HTTP/1.1\r\n" + valid + "Sec-WebSocket-Version: 8\r\n\r\n", ErrorUnsupportedVersion, "426 Upgrade Required"},
+		{"wrong method", "POST / HTTP/1.1\r\n" + valid + "Sec-WebSocket-Version: 13\r\n\r\n", ErrorBadHandshake, "400 Bad Request"},
+		{"short key", "GET / HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: c2hvcnQ=\r\nSec-WebSocket-Version: 13\r\n\r\n", ErrorBadHandshake, "400 Bad Request"},
+	}
+
+	for _, tc := range cases {
+		req, err := request.RequestFromReader(strings.NewReader(tc.raw))
+		require.NoError(t, err, tc.name)
+
+		buf := &bytes.Buffer{}
+		_, err = Upgrade(response.NewWriter(buf), req)
+		assert.ErrorIs(t, err, tc.want, tc.name)
+		assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 "+tc.status+"\r\n"), tc.name)
+		if tc.status == "426 Upgrade Required" {
+			assert.Contains(t, buf.String(), "sec-websocket-version: 13\r\n", tc.name)
+		}
+	}
+}
+
+func TestConnEcho(t *testing.T) {
+	conn, r, _ := dial(t, echo(nil), "")
+
+	// Test: Text and binary messages
+	send(t, conn, frame{fin: true, op: opText, payload: []byte("hello")})
+	f := receive(t, r)
+	assert.Equal(t, frame{fin: true, op: opText, payload: []byte("hello")}, f)
+
+	send(t, conn, frame{fin: true, op: opBinary, payload: []byte{0, 1, 2}})
+	f = receive(t, r)
+	assert.Equal(t, frame{fin: true, op: opBinary, payload: []byte{0, 1, 2}}, f)
+
+	// Test: Fragments are reassembled, with a ping answered in between
+	send(t, conn, frame{op: opText, payload: []byte("frag")})
+	send(t, conn, frame{fin: true, op: opPing, payload: []byte("are you there")})
+	send(t, conn, frame{op: opContinuation, payload: []byte("men")})
+	send(t, conn, frame{fin: true, op: opContinuation, payload: []byte("ted")})
+
+	f = receive(t, r)
+	assert.Equal(t, frame{fin: true, op: opPong, payload: []byte("are you there")}, f)
+	f = receive(t, r)
+	assert.Equal(t, frame{fin: true, op: opText, payload: []byte("fragmented")}, f)
+
+	// Test: Large messages use the extended lengths
+	for _, size := range []int{126, 70000} {
+		payload := bytes.Repeat([]byte("x"), size)
+		send(t, conn, frame{fin: true, op: opBinary, payload: payload})
+		f = receive(t, r)
+		assert.Equal(t, payload, f.payload)
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	// Test: A close from the client is answered and ends the connection
+	errs := make(chan error, 1)
+	conn, r, _ := dial(t, echo(errs), "")
+
+	send(t, conn, frame{fin: true, op: opClose, payload: closePayload(CloseGoingAway, "bye")})
+	f := receive(t, r)
+	assert.Equal(t, CloseGoingAway, closeCode(t, f))
+
+	var closeErr *CloseError
+	require.ErrorAs(t, <-errs, &closeErr)
+	assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: "bye"}, closeErr)
+
+	_, err := r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: An empty close frame is answered with an empty one
+	conn, r, _ = dial(t, echo(errs), "")
+	send(t, conn, frame{fin: true, op: opClose})
+	f = receive(t, r)
+	assert.Equal(t, opClose, f.op)
+	assert.Empty(t, f.payload)
+	require.ErrorAs(t, <-errs, &closeErr)
+	assert.Equal(t, CloseNoStatus, closeErr.Code)
+
+	// Test: The server may start the handshake
+	conn, r, _ = dial(t, func(w *response.Writer, req *request.Request) {
+		ws, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		err = ws.WriteClose(CloseNormal, "done")
+		if err == nil {
+			err = ws.WriteMessage(TextMessage, []byte("too late"))
+			assert.ErrorIs(t, err, ErrorClosed)
+			_, _, err = ws.ReadMessage()
+		}
+		errs <- err
+	}, "")
+
+	f = receive(t, r)
+	assert.Equal(t, CloseNormal, closeCode(t, f))
+	assert.Equal(t, "done", string(f.payload[2:]))
+	send(t, conn, frame{fin: true, op: opClose, payload: closePayload(CloseNormal, "")})
+
+	require.ErrorAs(t, <-errs, &closeErr)
+	assert.Equal(t, CloseNormal, closeErr.Code)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestConnViolations(t *testing.T) {
+	cases := []struct {
+		name   string
+		frames []frame
+		want   int
+		err    error
+	}{
+		{"unmasked", nil, CloseProtocolError, ErrorProtocol},
+		{"invalid utf-8", []frame{{fin: true, op: opText, payload: []byte{0xff, 0xfe}}}, CloseInvalidPayload, ErrorInvalidPayload},
+		{"too big", []frame{{fin: true, op: opBinary, payload: make([]byte, 11)}}, CloseMessageTooBig, ErrorMessageTooBig},
+		{"too big once reassembled", []frame{
+			{op: opBinary, payload: make([]byte, 6)},
+			{fin: true, op: opContinuation, payload: make([]byte, 6)},
+		}, CloseMessageTooBig, ErrorMessageTooBig},
+		{"continuation first", []frame{{fin: true, op: opContinuation, payload: []byte("hi")}}, CloseProtocolError, ErrorProtocol},
+		{"new message mid-message", []frame{
+			{op: opText, payload: []byte("hi")},
+			{fin: true, op: opText, payload: []byte("hi")},
+		}, CloseProtocolError, ErrorProtocol},
+		{"fragmented ping", []frame{{op: opPing}}, CloseProtocolError, ErrorProtocol},
+		{"compressed without negotiation", []frame{{fin: true, rsv1: true, op: opText, payload: []byte("hi")}}, CloseProtocolError, ErrorProtocol},
+		{"unknown opcode", []frame{{fin: true, op: 0x3}}, CloseProtocolError, ErrorProtocol},
+		{"reserved close code", []frame{{fin: true, op: opClose, payload: closePayload(CloseNoStatus, "")}}, CloseProtocolError, ErrorProtocol},
+		{"invalid close reason", []frame{{fin: true, op: opClose, payload: append(closePayload(CloseNormal, ""), 0xff)}}, CloseInvalidPayload, ErrorInvalidPayload},
+	}
+
+	for _, tc := range cases {
+		errs := make(chan error, 1)
+		conn, r, _ := dial(t, echo(errs, WithMaxMessageSize(10)), "")
+
+		if tc.frames == nil {
+			require.NoError(t, writeFrame(conn, frame{fin: true, op: opText, payload: []byte("hi")}), tc.name)
+		}
+		for _, f := range tc.frames {
+			send(t, conn, f)
+		}
+
+		f := receive(t, r)
+		assert.Equal(t, tc.want, closeCode(t, f), tc.name)
+		assert.ErrorIs(t, <-errs, tc.err, tc.name)
+	}
+}
+
+func TestConnCompression(t *testing.T) {
+	conn, r, _ := dial(t, echo(nil, WithCompression(flate.BestCompression)), "Sec-WebSocket-Extensions: permessage-deflate\r\n")
+
+	message := strings.Repeat("compress me ", 100)
+	compressed, err := compressMessage([]byte(message), flate.BestSpeed)
+	require.NoError(t, err)
+	assert.Less(t, len(compressed), len(message))
+
+	// Test: Compressed messages are inflated, even when fragmented, and the
+	// echo comes back compressed
+	send(t, conn, frame{rsv1: true, op: opText, payload: compressed[:10]})
+	send(t, conn, frame{fin: true, op: opContinuation, payload: compressed[10:]})
+
+	f := receive(t, r)
+	assert.True(t, f.rsv1)
+	decompressed, err := decompressMessage(f.payload, len(message))
+	require.NoError(t, err)
+	assert.Equal(t, message, string(decompressed))
+
+	// Test: Uncompressed messages are still allowed
+	send(t, conn, frame{fin: true, op: opText, payload: []byte("plain")})
+	f = receive(t, r)
+	decompressed, err = decompressMessage(f.payload, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(decompressed))
+
+	// Test: Messages that inflate beyond the limit are refused
+	_, err = decompressMessage(compressed, len(message)-1)
+	assert.ErrorIs(t, err, ErrorMessageTooBig)
+	_, err = decompressMessage([]byte{0xff, 0xff, 0xff}, 100)
+	assert.ErrorIs(t, err, ErrorInvalidPayload)
+}
+
+func TestAcceptsDeflate(t *testing.T) {
+	assert.True(t, acceptsDeflate("permessage-deflate"))
+	assert.True(t, acceptsDeflate("permessage-deflate; client_max_window_bits"))
+	assert.True(t, acceptsDeflate("x-webkit-deflate-frame, permessage-deflate; server_no_context_takeover"))
+	assert.True(t, acceptsDeflate("permessage-deflate; server_max_window_bits=10, permessage-deflate"))
+	assert.False(t, acceptsDeflate("permessage-deflate; server_max_window_bits=10"))
+	assert.False(t, acceptsDeflate("x-webkit-deflate-frame"))
+	assert.False(t, acceptsDeflate(""))
+}
+
+func TestFrame(t *testing.T) {
+	// Test: Masked frames survive a round trip
+	buf := &bytes.Buffer{}
+	f := frame{fin: true, op: opText, masked: true, maskKey: testMaskKey, payload: []byte("Hello")}
+	require.NoError(t, writeFrame(buf, f))
+	assert.Equal(t, []byte("Hello"), f.payload, "the payload is masked in a copy")
+
+	got, err := readFrame(buf, 100)
+	require.NoError(t, err)
+	assert.Equal(t, f, got)
+
+	// Test: The unmasked example from RFC 6455 section 5.7
+	got, err = readFrame(bytes.NewReader([]byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}), 100)
+	require.NoError(t, err)
+	assert.Equal(t, frame{fin: true, op: opText, payload: []byte("Hello")}, got)
+
+	// Test: Oversized control frames and reserved bits are refused
+	_, err = readFrame(bytes.NewReader([]byte{0x89, 126, 0, 126}), 1000)
+	assert.ErrorIs(t, err, ErrorProtocol)
+	_, err = readFrame(bytes.NewReader([]byte{0xa1, 0x00}), 100)
+	assert.ErrorIs(t, err, ErrorProtocol)
+
+	// Test: A truncated frame
+	_, err = readFrame(bytes.NewReader([]byte{0x81, 0x05, 0x48}), 100)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}